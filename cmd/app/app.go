@@ -0,0 +1,318 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/argoproj/argo-cd/pkg/client/clientset/versioned"
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
+	"github.com/codefresh-io/cf-argo/pkg/kube"
+	"github.com/codefresh-io/cf-argo/pkg/log"
+	"github.com/codefresh-io/cf-argo/pkg/store"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+)
+
+const (
+	waitInterval       = time.Second * 5
+	defaultWaitTimeout = time.Minute * 5
+)
+
+type addOptions struct {
+	common.RepoOptions
+	common.CommitOptions
+	envName      string
+	appName      string
+	namespace    string
+	manifests    string
+	kustomizeURL string
+	helmChart    string
+	helmRepo     string
+	helmVersion  string
+	dryRun       bool
+}
+
+type removeOptions struct {
+	common.RepoOptions
+	common.CommitOptions
+	envName     string
+	appName     string
+	wait        bool
+	waitTimeout time.Duration
+	dryRun      bool
+}
+
+func New(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "app",
+		Short: "Manage the applications of an environment",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(newAddCommand(ctx))
+	cmd.AddCommand(newRemoveCommand(ctx))
+
+	return cmd
+}
+
+func newAddCommand(ctx context.Context) *cobra.Command {
+	var opts addOptions
+
+	cmd := &cobra.Command{
+		Use:   "add <env> <app>",
+		Short: "Add a new managed application to an environment",
+		Long:  "This command will create the kustomize base of the application, if no other environment has it, and the overlay of the environment, add the Argo-CD application to the environment, and push the changes to the gitops repository",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.envName, opts.appName = args[0], args[1]
+			validateAddOpts(&opts)
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			add(ctx, &opts)
+		},
+	}
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	common.AddCommitFlags(cmd, &opts.CommitOptions)
+	cmd.Flags().StringVar(&opts.namespace, "namespace", "", "the namespace the application is deployed to (default: the application name)")
+	cmd.Flags().StringVar(&opts.manifests, "manifests", "", "path to a manifest file, or a directory of manifests, copied into the kustomize base")
+	cmd.Flags().StringVar(&opts.kustomizeURL, "kustomize-url", "", "a remote kustomize base, e.g. github.com/owner/repo/path?ref=v1.0.0, referenced by the kustomize base")
+	cmd.Flags().StringVar(&opts.helmChart, "helm-chart", "", "the name of a helm chart, inflated by the kustomize base")
+	cmd.Flags().StringVar(&opts.helmRepo, "helm-repo", "", "the url of the helm repository of --helm-chart")
+	cmd.Flags().StringVar(&opts.helmVersion, "helm-version", "", "the version of --helm-chart (default: the latest version)")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "when true, the changes are only committed in memory, and are not pushed")
+
+	return cmd
+}
+
+func newRemoveCommand(ctx context.Context) *cobra.Command {
+	var opts removeOptions
+
+	cmd := &cobra.Command{
+		Use:   "remove <env> <app>",
+		Short: "Remove a managed application from an environment",
+		Long:  "This command will remove the Argo-CD application and the overlay of the environment, and the kustomize base of the application if no other environment references it, push the changes to the gitops repository, and wait for Argo-CD to prune the application",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts.envName, opts.appName = args[0], args[1]
+			common.ValidateCommitOpts(&opts.CommitOptions)
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			remove(ctx, &opts)
+		},
+	}
+
+	// add kubernetes flags
+	store.Get().KubeConfig.AddFlagSet(cmd)
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	common.AddCommitFlags(cmd, &opts.CommitOptions)
+	cmd.Flags().BoolVar(&opts.wait, "wait", true, "when true, wait for argo-cd to prune the application from the cluster")
+	cmd.Flags().DurationVar(&opts.waitTimeout, "wait-timeout", defaultWaitTimeout, "how long to wait for argo-cd to prune the application")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "when true, the changes are only committed in memory, and are not pushed")
+
+	return cmd
+}
+
+func validateAddOpts(opts *addOptions) {
+	common.ValidateCommitOpts(&opts.CommitOptions)
+
+	sources := 0
+	for _, s := range []string{opts.manifests, opts.kustomizeURL, opts.helmChart} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		panic("only one of --manifests, --kustomize-url and --helm-chart can be provided")
+	}
+	if opts.helmChart != "" && opts.helmRepo == "" {
+		panic("--helm-chart requires --helm-repo")
+	}
+	if opts.helmChart == "" && (opts.helmRepo != "" || opts.helmVersion != "") {
+		panic("--helm-repo and --helm-version require --helm-chart")
+	}
+	if opts.namespace == "" {
+		opts.namespace = opts.appName
+	}
+}
+
+func add(ctx context.Context, opts *addOptions) {
+	addAppOpts := &envman.AddAppOptions{
+		Name:         opts.appName,
+		Namespace:    opts.namespace,
+		KustomizeURL: opts.kustomizeURL,
+	}
+
+	if opts.manifests != "" {
+		var err error
+		addAppOpts.Manifests, err = readManifests(opts.manifests)
+		cferrors.CheckErr(err)
+	}
+
+	if opts.helmChart != "" {
+		addAppOpts.HelmChart = &kustomize.HelmChartArgs{
+			ChartName:        opts.helmChart,
+			ChartRepoURL:     opts.helmRepo,
+			ChartVersion:     opts.helmVersion,
+			ReleaseName:      opts.appName,
+			ReleaseNamespace: opts.namespace,
+		}
+	}
+
+	r, fs, cleanup := common.CloneRepo(ctx, &opts.RepoOptions, opts.dryRun)
+	defer cleanup()
+
+	conf, err := envman.LoadConfig(fs)
+	cferrors.CheckErr(err)
+
+	env := common.GetEnvironment(conf, opts.envName)
+
+	app, err := env.AddApp(addAppOpts)
+	cferrors.CheckErr(err)
+
+	msg := fmt.Sprintf("added app %s to environment %s", opts.appName, opts.envName)
+	common.PersistRepo(ctx, r, &opts.RepoOptions, &opts.CommitOptions, msg, opts.dryRun, func(ctx context.Context) error {
+		env, err := common.LoadEnvironment(fs, opts.envName)
+		if err != nil {
+			return err
+		}
+
+		app, err = env.AddApp(addAppOpts)
+		return err
+	})
+
+	if opts.dryRun {
+		log.G(ctx).Printf("dry run, the application %s was not pushed", app.Path)
+		return
+	}
+
+	log.G(ctx).Printf("added application '%s', argo-cd will deploy it to namespace '%s' on the next sync", app.Name, opts.namespace)
+}
+
+func remove(ctx context.Context, opts *removeOptions) {
+	r, fs, cleanup := common.CloneRepo(ctx, &opts.RepoOptions, opts.dryRun)
+	defer cleanup()
+
+	conf, err := envman.LoadConfig(fs)
+	cferrors.CheckErr(err)
+
+	env := common.GetEnvironment(conf, opts.envName)
+
+	app, err := env.RemoveApp(opts.appName)
+	cferrors.CheckErr(err)
+
+	msg := fmt.Sprintf("removed app %s from environment %s", opts.appName, opts.envName)
+	common.PersistRepo(ctx, r, &opts.RepoOptions, &opts.CommitOptions, msg, opts.dryRun, func(ctx context.Context) error {
+		env, err := common.LoadEnvironment(fs, opts.envName)
+		if err != nil {
+			return err
+		}
+
+		app, err = env.RemoveApp(opts.appName)
+		return err
+	})
+
+	if opts.dryRun {
+		log.G(ctx).Printf("dry run, the removal of %s was not pushed", app.Path)
+		return
+	}
+
+	if !opts.wait {
+		log.G(ctx).Printf("removed application '%s', argo-cd will prune it on the next sync", app.Name)
+		return
+	}
+
+	namespace := app.Namespace
+	if namespace == "" {
+		namespace = env.Namespace()
+	}
+
+	log.G(ctx).Printf("waiting for argo-cd to prune the application... (might take a few minutes)")
+	awaitPrune(ctx, opts, app.Name, namespace)
+	log.G(ctx).Printf("application '%s' was pruned", app.Name)
+}
+
+// awaitPrune waits until the argo-cd application is deleted from the cluster
+func awaitPrune(ctx context.Context, opts *removeOptions, name, namespace string) {
+	o := &kube.WaitOptions{
+		Interval: waitInterval,
+		Timeout:  opts.waitTimeout,
+		Resources: []*kube.ResourceInfo{
+			{
+				Name:      name,
+				Namespace: namespace,
+				Func: func(ctx context.Context, c kube.Client, ns, name string) (bool, error) {
+					config, err := c.ToRESTConfig()
+					if err != nil {
+						return false, err
+					}
+
+					argoClient, err := versioned.NewForConfig(config)
+					if err != nil {
+						return false, err
+					}
+
+					_, err = argoClient.ArgoprojV1alpha1().Applications(ns).Get(ctx, name, metav1.GetOptions{})
+					if err != nil {
+						if kerrors.IsNotFound(err) {
+							return true, nil
+						}
+
+						return false, err
+					}
+
+					return false, nil
+				},
+			},
+		},
+	}
+
+	cferrors.CheckErr(store.Get().NewKubeClient(ctx).Wait(ctx, o))
+}
+
+// readManifests returns the yaml files in path by file name, path is either a
+// manifest file, or a directory of manifests
+func readManifests(path string) (map[string][]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if fi.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, matches...)
+		}
+	}
+
+	res := map[string][]byte{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		res[filepath.Base(f)] = data
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no manifests found in: %s", path)
+	}
+
+	return res, nil
+}
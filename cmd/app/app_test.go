@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/codefresh-io/cf-argo/test/utils/testrepo"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixture = "../../test/e2e/structures/uc3"
+
+// loadConfig clones the repository in memory and loads its config
+func loadConfig(ctx context.Context, t *testing.T, repoOpts *common.RepoOptions) *envman.Config {
+	_, fs, _ := common.CloneRepo(ctx, repoOpts, true)
+	conf, err := envman.LoadConfig(fs)
+	assert.NoError(t, err)
+
+	return conf
+}
+
+func Test_validateAddOpts(t *testing.T) {
+	tests := map[string]struct {
+		opts          *addOptions
+		wantNamespace string
+		panic         string
+	}{
+		"Default namespace": {
+			opts:          &addOptions{appName: "redis", kustomizeURL: "github.com/foo/redis"},
+			wantNamespace: "redis",
+		},
+		"Namespace": {
+			opts:          &addOptions{appName: "redis", namespace: "cache", helmChart: "redis", helmRepo: "https://charts.bitnami.com/bitnami"},
+			wantNamespace: "cache",
+		},
+		"Several sources": {
+			opts:  &addOptions{manifests: "manifests", kustomizeURL: "github.com/foo/redis"},
+			panic: "only one of --manifests, --kustomize-url and --helm-chart can be provided",
+		},
+		"Helm chart without repo": {
+			opts:  &addOptions{helmChart: "redis"},
+			panic: "--helm-chart requires --helm-repo",
+		},
+		"Helm version without chart": {
+			opts:  &addOptions{helmVersion: "1.0.0"},
+			panic: "--helm-repo and --helm-version require --helm-chart",
+		},
+		"Author name without email": {
+			opts:  &addOptions{CommitOptions: common.CommitOptions{AuthorName: "bot"}},
+			panic: "--git-author-name and --git-author-email must be provided together",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			if tt.panic != "" {
+				assert.PanicsWithValue(t, tt.panic, func() { validateAddOpts(tt.opts) })
+				return
+			}
+
+			validateAddOpts(tt.opts)
+			assert.Equal(t, tt.wantNamespace, tt.opts.namespace)
+		})
+	}
+}
+
+func Test_readManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "deployment.yaml"), []byte("kind: Deployment"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "service.yml"), []byte("kind: Service"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("readme"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "empty"), 0755))
+
+	tests := map[string]struct {
+		path string
+		want map[string][]byte
+		err  string
+	}{
+		"Directory": {
+			path: dir,
+			want: map[string][]byte{
+				"deployment.yaml": []byte("kind: Deployment"),
+				"service.yml":     []byte("kind: Service"),
+			},
+		},
+		"File": {
+			path: filepath.Join(dir, "deployment.yaml"),
+			want: map[string][]byte{
+				"deployment.yaml": []byte("kind: Deployment"),
+			},
+		},
+		"Empty directory": {
+			path: filepath.Join(dir, "empty"),
+			err:  "no manifests found in: " + filepath.Join(dir, "empty"),
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			got, err := readManifests(tt.path)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_add(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	repoURL, host := testrepo.New(ctx, t, fixture)
+
+	repoOpts := common.RepoOptions{
+		RepoURL:     repoURL,
+		GitProvider: "file",
+		GitHost:     host,
+	}
+	commitOpts := common.CommitOptions{
+		AuthorName:  "test",
+		AuthorEmail: "test@example.com",
+	}
+
+	add(ctx, &addOptions{
+		RepoOptions:   repoOpts,
+		CommitOptions: commitOpts,
+		envName:       "production",
+		appName:       "guestbook",
+		namespace:     "guestbook",
+	})
+
+	app, err := loadConfig(ctx, t, &repoOpts).Environments["production"].GetApp("guestbook")
+	assert.NoError(t, err)
+	assert.Equal(t, "production-guestbook", app.Name)
+	assert.Equal(t, "kustomize/components/guestbook/overlays/production", app.Spec.Source.Path)
+	assert.Equal(t, "guestbook", app.Spec.Destination.Namespace)
+
+	// a dry run is not pushed
+	add(ctx, &addOptions{
+		RepoOptions:   repoOpts,
+		CommitOptions: commitOpts,
+		envName:       "production",
+		appName:       "redis",
+		namespace:     "redis",
+		kustomizeURL:  "github.com/foo/redis",
+		dryRun:        true,
+	})
+
+	_, err = loadConfig(ctx, t, &repoOpts).Environments["production"].GetApp("redis")
+	assert.ErrorIs(t, err, envman.ErrAppNotFound)
+}
+
+func Test_remove(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	repoURL, host := testrepo.New(ctx, t, fixture)
+
+	repoOpts := common.RepoOptions{
+		RepoURL:     repoURL,
+		GitProvider: "file",
+		GitHost:     host,
+	}
+	commitOpts := common.CommitOptions{
+		AuthorName:  "test",
+		AuthorEmail: "test@example.com",
+	}
+
+	// a dry run is not pushed
+	remove(ctx, &removeOptions{
+		RepoOptions:   repoOpts,
+		CommitOptions: commitOpts,
+		envName:       "staging",
+		appName:       "guestbook",
+		dryRun:        true,
+	})
+
+	_, err := loadConfig(ctx, t, &repoOpts).Environments["staging"].GetApp("guestbook")
+	assert.NoError(t, err)
+
+	remove(ctx, &removeOptions{
+		RepoOptions:   repoOpts,
+		CommitOptions: commitOpts,
+		envName:       "staging",
+		appName:       "guestbook",
+	})
+
+	_, err = loadConfig(ctx, t, &repoOpts).Environments["staging"].GetApp("guestbook")
+	assert.ErrorIs(t, err, envman.ErrAppNotFound)
+
+	assert.PanicsWithError(t, "environment does not exist: foo", func() {
+		remove(ctx, &removeOptions{
+			RepoOptions: repoOpts,
+			envName:     "foo",
+			appName:     "guestbook",
+		})
+	})
+}
@@ -0,0 +1,305 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	"github.com/codefresh-io/cf-argo/pkg/log"
+	"github.com/go-git/go-billy/v5"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// RepoOptions the flags used to access an existing gitops repository
+type RepoOptions struct {
+	RepoURL     string
+	GitProvider string
+	GitHost     string
+	GitToken    string
+	// GitUsername the username of the git token, when it is filled from the
+	// git credential helpers or the netrc file
+	GitUsername             string
+	GithubAppID             int64
+	GithubAppInstallationID int64
+	GithubAppPrivateKeyPath string
+	SSHPrivateKeyPath       string
+	SSHPrivateKeyPassword   string
+	SSHAgent                bool
+	SSHKnownHosts           string
+}
+
+// CommitOptions the flags of the commits made to the gitops repository
+type CommitOptions struct {
+	AuthorName        string
+	AuthorEmail       string
+	SigningKey        string
+	SigningFormat     string
+	SigningPassphrase string
+}
+
+// AddRepoFlags adds the flags of the gitops repository to cmd, --repo-url is
+// required
+func AddRepoFlags(ctx context.Context, cmd *cobra.Command, opts *RepoOptions) {
+	_ = viper.BindEnv("repo-url", "REPO_URL")
+
+	cmd.Flags().StringVar(&opts.RepoURL, "repo-url", viper.GetString("repo-url"), "the gitops repository url [REPO_URL]")
+	AddGitFlags(cmd, opts)
+
+	cferrors.MustContext(ctx, cmd.MarkFlagRequired("repo-url"))
+}
+
+// AddGitFlags adds the flags of the git provider and its credentials to cmd,
+// without --repo-url
+func AddGitFlags(cmd *cobra.Command, opts *RepoOptions) {
+	_ = viper.BindEnv("git-provider", "GIT_PROVIDER")
+	_ = viper.BindEnv("git-host", "GIT_HOST")
+	_ = viper.BindEnv("git-token", "GIT_TOKEN")
+	_ = viper.BindEnv("github-app-id", "GITHUB_APP_ID")
+	_ = viper.BindEnv("github-app-installation-id", "GITHUB_APP_INSTALLATION_ID")
+	_ = viper.BindEnv("github-app-private-key-path", "GITHUB_APP_PRIVATE_KEY_PATH")
+	_ = viper.BindEnv("ssh-private-key-path", "SSH_PRIVATE_KEY_PATH")
+	_ = viper.BindEnv("ssh-private-key-password", "SSH_PRIVATE_KEY_PASSWORD")
+	_ = viper.BindEnv("ssh-agent", "SSH_AGENT")
+	_ = viper.BindEnv("ssh-known-hosts", "SSH_KNOWN_HOSTS")
+	viper.SetDefault("git-provider", "github")
+
+	cmd.Flags().StringVar(&opts.GitProvider, "git-provider", viper.GetString("git-provider"), "the git provider hosting the gitops repository, one of: github, gitlab, bitbucket, bitbucket-server, gitea, azure, file [GIT_PROVIDER]")
+	cmd.Flags().StringVar(&opts.GitHost, "git-host", viper.GetString("git-host"), "the url of a self hosted git server, e.g. GitHub Enterprise, or the base directory of the repositories with the file provider [GIT_HOST]")
+	cmd.Flags().StringVar(&opts.GitToken, "git-token", viper.GetString("git-token"), "git token used to access the gitops repository [GIT_TOKEN]")
+	cmd.Flags().Int64Var(&opts.GithubAppID, "github-app-id", viper.GetInt64("github-app-id"), "the id of a GitHub App, when set the app installation is used to access the gitops repository instead of --git-token [GITHUB_APP_ID]")
+	cmd.Flags().Int64Var(&opts.GithubAppInstallationID, "github-app-installation-id", viper.GetInt64("github-app-installation-id"), "the id of the GitHub App installation [GITHUB_APP_INSTALLATION_ID]")
+	cmd.Flags().StringVar(&opts.GithubAppPrivateKeyPath, "github-app-private-key-path", viper.GetString("github-app-private-key-path"), "path to the private key of the GitHub App [GITHUB_APP_PRIVATE_KEY_PATH]")
+	cmd.Flags().StringVar(&opts.SSHPrivateKeyPath, "ssh-private-key-path", viper.GetString("ssh-private-key-path"), "path to an ssh private key, when set git operations will use ssh to access the gitops repository [SSH_PRIVATE_KEY_PATH]")
+	cmd.Flags().StringVar(&opts.SSHPrivateKeyPassword, "ssh-private-key-password", viper.GetString("ssh-private-key-password"), "the passphrase of the ssh private key [SSH_PRIVATE_KEY_PASSWORD]")
+	cmd.Flags().BoolVar(&opts.SSHAgent, "ssh-agent", viper.GetBool("ssh-agent"), "when true, git operations will use the ssh agent to access the gitops repository [SSH_AGENT]")
+	cmd.Flags().StringVar(&opts.SSHKnownHosts, "ssh-known-hosts", viper.GetString("ssh-known-hosts"), "path to the known_hosts file used to verify the git server host key (default: ~/.ssh/known_hosts) [SSH_KNOWN_HOSTS]")
+}
+
+// ValidateRepoOpts panics if the repository flags are invalid, and fills the
+// git token from the git credential helpers or the netrc file, when it was not
+// provided
+func ValidateRepoOpts(ctx context.Context, opts *RepoOptions) {
+	if _, err := git.ParseRef(opts.RepoURL); err != nil {
+		panic(fmt.Sprintf("invalid --repo-url: %s", err))
+	}
+	ValidateGithubAppOpts(opts)
+
+	FillGitToken(ctx, opts, opts.RepoURL)
+}
+
+// ValidateGithubAppOpts panics if the github app flags are not provided
+// together, or are used with another git provider
+func ValidateGithubAppOpts(opts *RepoOptions) {
+	if opts.GithubAppID == 0 && opts.GithubAppInstallationID == 0 && opts.GithubAppPrivateKeyPath == "" {
+		return
+	}
+	if opts.GithubAppID == 0 || opts.GithubAppInstallationID == 0 || opts.GithubAppPrivateKeyPath == "" {
+		panic("--github-app-id, --github-app-installation-id and --github-app-private-key-path must be provided together")
+	}
+	if opts.GitProvider != "github" {
+		panic("--github-app-id requires --git-provider github")
+	}
+}
+
+// AddCommitFlags adds the flags of the commit author and signature to cmd
+func AddCommitFlags(cmd *cobra.Command, opts *CommitOptions) {
+	_ = viper.BindEnv("git-author-name", "GIT_AUTHOR_NAME")
+	_ = viper.BindEnv("git-author-email", "GIT_AUTHOR_EMAIL")
+	_ = viper.BindEnv("signing-key", "GIT_SIGNING_KEY")
+	_ = viper.BindEnv("signing-format", "GIT_SIGNING_FORMAT")
+	_ = viper.BindEnv("signing-key-passphrase", "GIT_SIGNING_KEY_PASSPHRASE")
+	viper.SetDefault("signing-format", git.SignFormatOpenPGP)
+
+	cmd.Flags().StringVar(&opts.AuthorName, "git-author-name", viper.GetString("git-author-name"), "the name of the commits author (default: user.name from the git config) [GIT_AUTHOR_NAME]")
+	cmd.Flags().StringVar(&opts.AuthorEmail, "git-author-email", viper.GetString("git-author-email"), "the email of the commits author (default: user.email from the git config) [GIT_AUTHOR_EMAIL]")
+	cmd.Flags().StringVar(&opts.SigningKey, "signing-key", viper.GetString("signing-key"), "path to an armored openpgp private key or an ssh private key, when set commits are signed with it [GIT_SIGNING_KEY]")
+	cmd.Flags().StringVar(&opts.SigningFormat, "signing-format", viper.GetString("signing-format"), "the format of the signing key, one of: openpgp, ssh [GIT_SIGNING_FORMAT]")
+	cmd.Flags().StringVar(&opts.SigningPassphrase, "signing-key-passphrase", viper.GetString("signing-key-passphrase"), "the passphrase of the signing key [GIT_SIGNING_KEY_PASSPHRASE]")
+}
+
+// ValidateCommitOpts panics if the commit flags are invalid
+func ValidateCommitOpts(opts *CommitOptions) {
+	if (opts.AuthorName == "") != (opts.AuthorEmail == "") {
+		panic("--git-author-name and --git-author-email must be provided together")
+	}
+}
+
+// GitCommitOptions returns the options of a commit with msg
+func (o *CommitOptions) GitCommitOptions(msg string) *git.CommitOptions {
+	commitOpts := &git.CommitOptions{
+		Message: msg,
+	}
+
+	if o.AuthorName != "" {
+		commitOpts.Author = &git.Signature{
+			Name:  o.AuthorName,
+			Email: o.AuthorEmail,
+		}
+	}
+
+	if o.SigningKey != "" {
+		commitOpts.Sign = &git.SignOptions{
+			Format:     o.SigningFormat,
+			KeyPath:    o.SigningKey,
+			Passphrase: o.SigningPassphrase,
+		}
+	}
+
+	return commitOpts
+}
+
+// GitOptions returns the provider options of the gitops repository. It is only
+// cloned in memory in dry-run, when nothing should be written to disk.
+func (o *RepoOptions) GitOptions(dryRun bool) *git.Options {
+	return &git.Options{
+		Type:     o.GitProvider,
+		Auth:     o.GitAuth(),
+		Host:     o.GitHost,
+		InMemory: dryRun,
+	}
+}
+
+// GitAuth returns the credentials of the gitops repository
+func (o *RepoOptions) GitAuth() *git.Auth {
+	auth := &git.Auth{
+		Username: o.GitUsername,
+		Password: o.GitToken,
+	}
+
+	if o.GithubAppID != 0 {
+		auth.GithubApp = &git.GithubAppAuth{
+			AppID:          o.GithubAppID,
+			InstallationID: o.GithubAppInstallationID,
+			PrivateKeyPath: o.GithubAppPrivateKeyPath,
+			Host:           o.GitHost,
+		}
+	}
+
+	if o.SSHPrivateKeyPath != "" || o.SSHAgent {
+		auth.SSH = &git.SSHAuth{
+			PrivateKeyPath:     o.SSHPrivateKeyPath,
+			PrivateKeyPassword: o.SSHPrivateKeyPassword,
+		}
+		if o.SSHKnownHosts != "" {
+			auth.SSH.KnownHostsPaths = []string{o.SSHKnownHosts}
+		}
+	}
+
+	return auth
+}
+
+// CloneRepo clones the gitops repository into a temp dir, or in memory in
+// dry-run, and returns it with its filesystem, and a func that removes the
+// temp dir
+func CloneRepo(ctx context.Context, opts *RepoOptions, dryRun bool) (git.Repository, billy.Filesystem, func()) {
+	p, err := git.NewProvider(opts.GitOptions(dryRun))
+	cferrors.CheckErr(err)
+
+	r, err := p.CloneRepository(ctx, opts.RepoURL)
+	cferrors.CheckErr(err)
+
+	fs, err := r.Filesystem()
+	cferrors.CheckErr(err)
+
+	clonePath := ""
+	if !dryRun {
+		clonePath, err = r.Root()
+		cferrors.CheckErr(err)
+	}
+
+	return r, fs, func() {
+		log.G(ctx).Debugf("cleaning dir: %s", clonePath)
+		if err := os.RemoveAll(clonePath); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Error("failed to clean user local repo")
+		}
+	}
+}
+
+// GetEnvironment returns the environment name of the config, and panics if it
+// does not exist
+func GetEnvironment(conf *envman.Config, name string) *envman.Environment {
+	env, exists := conf.Environments[name]
+	if !exists {
+		panic(fmt.Errorf("%w: %s", envman.ErrEnvironmentNotExist, name))
+	}
+
+	return env
+}
+
+// LoadEnvironment loads the config from fs, and returns its environment name
+func LoadEnvironment(fs billy.Filesystem, name string) (*envman.Environment, error) {
+	conf, err := envman.LoadConfig(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	env, exists := conf.Environments[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", envman.ErrEnvironmentNotExist, name)
+	}
+
+	return env, nil
+}
+
+// FillGitToken looks up the git token and its username in the git credential
+// helpers and the netrc file, by the host of repoURL, when it was not provided.
+// The file provider needs no credentials.
+func FillGitToken(ctx context.Context, opts *RepoOptions, repoURL string) {
+	if opts.GitToken != "" || opts.GithubAppID != 0 || opts.GitProvider == "file" {
+		return
+	}
+
+	ref, err := git.ParseRef(repoURL)
+	if err != nil {
+		return
+	}
+
+	auth, err := git.LookupCredentials(ctx, ref.URL)
+	cferrors.CheckErr(err)
+	if auth != nil {
+		opts.GitUsername = auth.Username
+		opts.GitToken = auth.Password
+	}
+}
+
+// PersistRepo commits all the changes in the gitops repository with msg, and
+// pushes them, unless dryRun is true. When the push is rejected since the
+// remote branch has new commits, the repository is reset to the remote branch
+// and replay applies the changes again before the push is retried. Without
+// replay, a rejected push fails. It returns the sha of the last commit.
+func PersistRepo(ctx context.Context, r git.Repository, repoOpts *RepoOptions, commitOpts *CommitOptions, msg string, dryRun bool, replay func(ctx context.Context) error) string {
+	cferrors.CheckErr(r.Add(ctx, "."))
+
+	sha, err := r.Commit(ctx, commitOpts.GitCommitOptions(msg))
+	cferrors.CheckErr(err)
+
+	if dryRun {
+		return sha
+	}
+
+	log.G(ctx).Printf("pushing to gitops repo...")
+	pushOpts := &git.PushOptions{
+		Auth: repoOpts.GitAuth(),
+	}
+	if replay == nil {
+		cferrors.CheckErr(r.Push(ctx, pushOpts))
+		return sha
+	}
+
+	replayed, err := git.PushWithRetry(ctx, r, &git.PushRetryOptions{
+		Push:   pushOpts,
+		Commit: commitOpts.GitCommitOptions(msg),
+		Replay: replay,
+	})
+	cferrors.CheckErr(err)
+
+	if replayed != "" {
+		sha = replayed
+	}
+
+	return sha
+}
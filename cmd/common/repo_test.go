@@ -0,0 +1,138 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	mockGit "github.com/codefresh-io/cf-argo/pkg/git/mocks"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_PersistRepo(t *testing.T) {
+	tests := map[string]struct {
+		dryRun      bool
+		pushErrs    []error
+		wantPushes  int
+		wantReplays int
+		wantSHA     string
+	}{
+		"Push": {
+			pushErrs:   []error{nil},
+			wantPushes: 1,
+			wantSHA:    "hash",
+		},
+		"Dry run": {
+			dryRun:  true,
+			wantSHA: "hash",
+		},
+		"Rejected push": {
+			pushErrs:    []error{git.ErrPushRejected, nil},
+			wantPushes:  2,
+			wantReplays: 1,
+			wantSHA:     "replayed",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			repoOpts := &RepoOptions{GitToken: "token"}
+			commitOpts := &CommitOptions{AuthorName: "cf-argo", AuthorEmail: "cf-argo@example.com"}
+
+			r := new(mockGit.Repository)
+			r.On("Add", mock.Anything, ".").Return(nil)
+			r.On("Commit", mock.Anything, commitOpts.GitCommitOptions("some message")).Return("hash", nil).Once()
+			r.On("Commit", mock.Anything, commitOpts.GitCommitOptions("some message")).Return("replayed", nil)
+			r.On("ResetToRemote", mock.Anything, &git.FetchOptions{Auth: repoOpts.GitAuth()}).Return(nil)
+			for _, err := range tt.pushErrs {
+				r.On("Push", mock.Anything, &git.PushOptions{Auth: repoOpts.GitAuth()}).Return(err).Once()
+			}
+
+			replays := 0
+			sha := PersistRepo(ctx, r, repoOpts, commitOpts, "some message", tt.dryRun, func(ctx context.Context) error {
+				replays++
+				return nil
+			})
+
+			r.AssertNumberOfCalls(t, "Push", tt.wantPushes)
+			r.AssertNumberOfCalls(t, "ResetToRemote", tt.wantReplays)
+			r.AssertNumberOfCalls(t, "Commit", tt.wantReplays+1)
+			assert.Equal(t, tt.wantReplays, replays)
+			assert.Equal(t, tt.wantSHA, sha)
+		})
+	}
+}
+
+// setenv sets the environment variable for the duration of the test
+func setenv(t *testing.T, key, value string) {
+	orig, exists := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if exists {
+			os.Setenv(key, orig)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	os.Setenv(key, value)
+}
+
+func Test_ValidateRepoOpts_netrc(t *testing.T) {
+	tests := map[string]struct {
+		gitToken string
+		wantAuth string
+	}{
+		"Netrc login and password": {
+			wantAuth: "Basic YWxpY2U6bmV0cmMtdG9rZW4=", // alice:netrc-token
+		},
+		"Git token": {
+			gitToken: "token",
+			wantAuth: "token token",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/v1/repos/foo/bar", r.URL.Path)
+				assert.Equal(t, tt.wantAuth, r.Header.Get("Authorization"))
+				assert.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"permissions": map[string]bool{"push": true}}))
+			}))
+			defer srv.Close()
+
+			// no git credential helpers, only the netrc file
+			home, err := ioutil.TempDir("", "home-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(home)
+			u, err := url.Parse(srv.URL)
+			assert.NoError(t, err)
+			netrc := filepath.Join(home, ".netrc")
+			assert.NoError(t, ioutil.WriteFile(netrc, []byte("machine "+u.Hostname()+" login alice password netrc-token\n"), 0600))
+			setenv(t, "HOME", home)
+			setenv(t, "GIT_CONFIG_NOSYSTEM", "1")
+			setenv(t, "NETRC", netrc)
+
+			opts := &RepoOptions{
+				RepoURL:     srv.URL + "/foo/bar.git",
+				GitProvider: "gitea",
+				GitHost:     srv.URL,
+				GitToken:    tt.gitToken,
+			}
+			ValidateRepoOpts(ctx, opts)
+
+			p, err := git.NewProvider(opts.GitOptions(true))
+			assert.NoError(t, err)
+			assert.NoError(t, p.ValidateAccess(ctx, &git.ValidateAccessOptions{RepoURL: opts.RepoURL}))
+		})
+	}
+}
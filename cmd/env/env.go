@@ -0,0 +1,251 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/cobra"
+)
+
+// output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+type options struct {
+	common.RepoOptions
+	output string
+}
+
+type envInfo struct {
+	Name                string `json:"name"`
+	TemplateRef         string `json:"templateRef"`
+	RootApplicationPath string `json:"rootAppPath"`
+	Namespace           string `json:"namespace"`
+	ManagedApps         int    `json:"managedApps"`
+}
+
+type appNode struct {
+	Name        string     `json:"name"`
+	Path        string     `json:"path"`
+	Destination string     `json:"destination"`
+	ManagedBy   string     `json:"managedBy"`
+	Leaf        bool       `json:"leaf"`
+	Children    []*appNode `json:"children,omitempty"`
+}
+
+func New(ctx context.Context) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Manage the environments of a gitops repository",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(newListCommand(ctx))
+	cmd.AddCommand(newDescribeCommand(ctx))
+
+	return cmd
+}
+
+func newListCommand(ctx context.Context) *cobra.Command {
+	var opts options
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the environments of a gitops repository",
+		Run: func(cmd *cobra.Command, args []string) {
+			validateOutput(opts.output)
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			list(ctx, &opts, os.Stdout)
+		},
+	}
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, "the output format, one of: table, json, yaml")
+
+	return cmd
+}
+
+func newDescribeCommand(ctx context.Context) *cobra.Command {
+	var opts options
+
+	cmd := &cobra.Command{
+		Use:   "describe <name>",
+		Short: "Show the app-of-apps tree of an environment",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			validateOutput(opts.output)
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			describe(ctx, &opts, args[0], os.Stdout)
+		},
+	}
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputTable, "the output format, one of: table, json, yaml")
+
+	return cmd
+}
+
+func validateOutput(output string) {
+	switch output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		panic(fmt.Sprintf("invalid --output: %s, must be one of: table, json, yaml", output))
+	}
+}
+
+func list(ctx context.Context, opts *options, w io.Writer) {
+	// read only, so the repository is kept in memory
+	_, fs, _ := common.CloneRepo(ctx, &opts.RepoOptions, true)
+
+	conf, err := envman.LoadConfig(fs)
+	cferrors.CheckErr(err)
+
+	infos, err := getEnvInfos(conf)
+	cferrors.CheckErr(err)
+
+	cferrors.CheckErr(printEnvInfos(w, opts.output, infos))
+}
+
+// getEnvInfos returns the environments of the config, sorted by name
+func getEnvInfos(conf *envman.Config) ([]*envInfo, error) {
+	res := []*envInfo{}
+	for _, env := range conf.Environments {
+		apps, err := env.ManagedApps()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the applications of environment %s: %w", env.Name(), err)
+		}
+
+		res = append(res, &envInfo{
+			Name:                env.Name(),
+			TemplateRef:         env.TemplateRef,
+			RootApplicationPath: env.RootApplicationPath,
+			Namespace:           env.Namespace(),
+			ManagedApps:         len(apps),
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
+
+func printEnvInfos(w io.Writer, output string, infos []*envInfo) error {
+	switch output {
+	case outputJSON:
+		return printJSON(w, infos)
+	case outputYAML:
+		return printYAML(w, infos)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTEMPLATE REF\tROOT APP PATH\tNAMESPACE\tMANAGED APPS")
+	for _, i := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", i.Name, i.TemplateRef, i.RootApplicationPath, i.Namespace, i.ManagedApps)
+	}
+
+	return tw.Flush()
+}
+
+func describe(ctx context.Context, opts *options, name string, w io.Writer) {
+	// read only, so the repository is kept in memory
+	_, fs, _ := common.CloneRepo(ctx, &opts.RepoOptions, true)
+
+	conf, err := envman.LoadConfig(fs)
+	cferrors.CheckErr(err)
+
+	env := common.GetEnvironment(conf, name)
+
+	tree, err := env.AppTree()
+	cferrors.CheckErr(err)
+
+	cferrors.CheckErr(printAppTree(w, opts.output, newAppNode(tree)))
+}
+
+func newAppNode(n *envman.AppNode) *appNode {
+	dest := n.Spec.Destination.Server
+	if dest == "" {
+		dest = n.Spec.Destination.Name
+	}
+
+	node := &appNode{
+		Name:        n.Name,
+		Path:        n.Spec.Source.Path,
+		Destination: fmt.Sprintf("%s/%s", dest, n.Spec.Destination.Namespace),
+		ManagedBy:   n.ManagedBy(),
+		Leaf:        n.IsLeaf(),
+	}
+	for _, c := range n.Children {
+		node.Children = append(node.Children, newAppNode(c))
+	}
+
+	return node
+}
+
+// printAppTree prints the tree with one application per line, and the
+// children of each application indented below it
+func printAppTree(w io.Writer, output string, root *appNode) error {
+	switch output {
+	case outputJSON:
+		return printJSON(w, root)
+	case outputYAML:
+		return printYAML(w, root)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPATH\tDESTINATION\tMANAGED BY\tLEAF")
+	printAppNode(tw, root, "", "")
+
+	return tw.Flush()
+}
+
+func printAppNode(w io.Writer, n *appNode, prefix, childPrefix string) {
+	managedBy := n.ManagedBy
+	if managedBy == "" {
+		managedBy = "-"
+	}
+
+	fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%t\n", prefix, n.Name, n.Path, n.Destination, managedBy, n.Leaf)
+	for i, c := range n.Children {
+		if i == len(n.Children)-1 {
+			printAppNode(w, c, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			printAppNode(w, c, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func printYAML(w io.Writer, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
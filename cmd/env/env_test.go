@@ -0,0 +1,184 @@
+package env
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/codefresh-io/cf-argo/test/utils/testrepo"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixture = "../../test/e2e/structures/uc3"
+
+func Test_validateOutput(t *testing.T) {
+	tests := map[string]struct {
+		output string
+		panic  string
+	}{
+		"Table": {output: "table"},
+		"JSON":  {output: "json"},
+		"YAML":  {output: "yaml"},
+		"Invalid": {
+			output: "xml",
+			panic:  "invalid --output: xml, must be one of: table, json, yaml",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			if tt.panic != "" {
+				assert.PanicsWithValue(t, tt.panic, func() { validateOutput(tt.output) })
+				return
+			}
+
+			assert.NotPanics(t, func() { validateOutput(tt.output) })
+		})
+	}
+}
+
+func Test_list(t *testing.T) {
+	tests := map[string]struct {
+		output string
+		want   string
+	}{
+		"Table": {
+			output: "table",
+			want: `NAME         TEMPLATE REF                                             ROOT APP PATH                 NAMESPACE           MANAGED APPS
+production   https://github.com/codefresh-io/argocd-template@v0.1.0   argocd-apps/production.yaml   production-argocd   1
+staging      https://github.com/codefresh-io/argocd-template          argocd-apps/staging.yaml      staging-argocd      2
+`,
+		},
+		"JSON": {
+			output: "json",
+			want: `[
+  {
+    "name": "production",
+    "templateRef": "https://github.com/codefresh-io/argocd-template@v0.1.0",
+    "rootAppPath": "argocd-apps/production.yaml",
+    "namespace": "production-argocd",
+    "managedApps": 1
+  },
+  {
+    "name": "staging",
+    "templateRef": "https://github.com/codefresh-io/argocd-template",
+    "rootAppPath": "argocd-apps/staging.yaml",
+    "namespace": "staging-argocd",
+    "managedApps": 2
+  }
+]
+`,
+		},
+		"YAML": {
+			output: "yaml",
+			want: `- managedApps: 1
+  name: production
+  namespace: production-argocd
+  rootAppPath: argocd-apps/production.yaml
+  templateRef: https://github.com/codefresh-io/argocd-template@v0.1.0
+- managedApps: 2
+  name: staging
+  namespace: staging-argocd
+  rootAppPath: argocd-apps/staging.yaml
+  templateRef: https://github.com/codefresh-io/argocd-template
+`,
+		},
+	}
+
+	ctx := utils.MockLoggerContext()
+	repoURL, host := testrepo.New(ctx, t, fixture)
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			list(ctx, &options{
+				RepoOptions: common.RepoOptions{
+					RepoURL:     repoURL,
+					GitProvider: "file",
+					GitHost:     host,
+				},
+				output: tt.output,
+			}, w)
+
+			assert.Equal(t, tt.want, w.String())
+		})
+	}
+}
+
+func Test_describe(t *testing.T) {
+	tests := map[string]struct {
+		env    string
+		output string
+		want   string
+		panic  string
+	}{
+		"Table": {
+			env:    "production",
+			output: "table",
+			want: `NAME                      PATH                                               DESTINATION                                        MANAGED BY       LEAF
+production                argocd-apps/production                             https://kubernetes.default.svc/production-argocd   argo-installer   false
+├── production-argo-cd    kustomize/components/argo-cd/overlays/production   https://kubernetes.default.svc/production          argo-installer   true
+└── production-external   manifests                                          https://kubernetes.default.svc/production          -                true
+`,
+		},
+		"JSON": {
+			env:    "staging",
+			output: "json",
+			want: `{
+  "name": "staging",
+  "path": "argocd-apps/staging",
+  "destination": "https://kubernetes.default.svc/staging-argocd",
+  "managedBy": "argo-installer",
+  "leaf": false,
+  "children": [
+    {
+      "name": "staging-argo-cd",
+      "path": "kustomize/components/argo-cd/overlays/staging",
+      "destination": "https://kubernetes.default.svc/staging",
+      "managedBy": "argo-installer",
+      "leaf": true
+    },
+    {
+      "name": "staging-guestbook",
+      "path": "kustomize/components/guestbook/overlays/staging",
+      "destination": "https://kubernetes.default.svc/staging",
+      "managedBy": "argo-installer",
+      "leaf": true
+    }
+  ]
+}
+`,
+		},
+		"Environment does not exist": {
+			env:    "foo",
+			output: "table",
+			panic:  "environment does not exist: foo",
+		},
+	}
+
+	ctx := utils.MockLoggerContext()
+	repoURL, host := testrepo.New(ctx, t, fixture)
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			w := &bytes.Buffer{}
+			opts := &options{
+				RepoOptions: common.RepoOptions{
+					RepoURL:     repoURL,
+					GitProvider: "file",
+					GitHost:     host,
+				},
+				output: tt.output,
+			}
+
+			if tt.panic != "" {
+				assert.PanicsWithError(t, tt.panic, func() { describe(ctx, opts, tt.env, w) })
+				return
+			}
+
+			describe(ctx, opts, tt.env, w)
+			assert.Equal(t, tt.want, w.String())
+		})
+	}
+}
@@ -2,15 +2,19 @@ package install
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/codefresh-io/cf-argo/cmd/common"
 	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
 	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
 	"github.com/codefresh-io/cf-argo/pkg/git"
@@ -20,27 +24,82 @@ import (
 	ss "github.com/codefresh-io/cf-argo/pkg/sealed-secrets"
 	"github.com/codefresh-io/cf-argo/pkg/store"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	argocdSecretTypeLabel = "argocd.argoproj.io/secret-type"
+	mergePollInterval     = time.Second * 10
+	argocdPartOfLabel     = "app.kubernetes.io/part-of"
+)
+
+// argocdWebhookSecretKeys the argocd-secret key of the webhook secret, by the
+// git providers argo-cd accepts webhooks from
+var argocdWebhookSecretKeys = map[string]string{
+	"github": "webhook.github.secret",
+	"gitlab": "webhook.gitlab.secret",
+	"gitea":  "webhook.gogs.secret",
+}
+
 type options struct {
-	repoURL   string
-	repoOwner string
-	repoName  string
-	envName   string
-	gitToken  string
-	baseRepo  string
-	dryRun    bool
+	common.RepoOptions
+	common.CommitOptions
+	repoOwner               string
+	repoName                string
+	repoDescription         string
+	repoDefaultBranch       string
+	repoVisibility          string
+	repoTeams               []string
+	protectDefaultBranch    bool
+	requiredApprovals       int
+	requireCodeOwnerReviews bool
+	requiredStatusChecks    []string
+	enforceAdmins           bool
+	envName                 string
+	deployKey               bool
+	argocdWebhookURL        string
+	baseRepo                string
+	pullRequest             bool
+	waitForMerge            bool
+	mergeTimeout            time.Duration
+	dryRun                  bool
 }
 
 var values struct {
-	BootstrapDir          string
-	Namespace             string
+	BootstrapDir string
+	Namespace    string
+	// TemplateRepoClonePath the temp dir of TemplateRepoFS, empty in dry-run,
+	// when the template is kept in memory
 	TemplateRepoClonePath string
-	GitopsRepoClonePath   string
-	GitopsRepo            git.Repository
+	TemplateRepoFS        billy.Filesystem
+	// TemplateSnapshotFS a copy of the template before the environment was
+	// installed from it, used to replay the installation after a rejected push
+	TemplateSnapshotFS   billy.Filesystem
+	TemplateSnapshotPath string
+	// GitopsRepoClonePath the temp dir of GitopsRepoFS, empty in dry-run, when
+	// the gitops repository is kept in memory
+	GitopsRepoClonePath string
+	GitopsRepoFS        billy.Filesystem
+	GitopsRepo          git.Repository
+	// SealedSecret the sealed secret written to the argo-cd application
+	SealedSecret []byte
+	// DeployKey the keypair argo-cd uses to access a new gitops repository
+	DeployKey *git.DeployKey
+	// ArgocdResources the sealed secrets added to the argo-cd application, by
+	// file name
+	ArgocdResources map[string][]byte
+	// ArgocdPatches the patches added to the argo-cd application, by file name
+	ArgocdPatches map[string][]byte
+	// WebhookSecret the secret of the gitops repository webhook
+	WebhookSecret string
 }
 
 var renderValues struct {
@@ -58,6 +117,7 @@ func New(ctx context.Context) *cobra.Command {
 		Short: "Installs the Argo Enterprise solution on a specified cluster",
 		Long:  "This command will create a new git repository that manages an Argo Enterprise solution using Argo-CD with gitops.",
 		Run: func(cmd *cobra.Command, args []string) {
+			fillGitToken(ctx, &opts)
 			validateOpts(&opts)
 			fillValues(&opts)
 			install(ctx, &opts)
@@ -70,37 +130,224 @@ func New(ctx context.Context) *cobra.Command {
 	_ = viper.BindEnv("repo-url", "REPO_URL")
 	_ = viper.BindEnv("repo-owner", "REPO_OWNER")
 	_ = viper.BindEnv("repo-name", "REPO_NAME")
+	_ = viper.BindEnv("repo-description", "REPO_DESCRIPTION")
+	_ = viper.BindEnv("repo-default-branch", "REPO_DEFAULT_BRANCH")
+	_ = viper.BindEnv("repo-visibility", "REPO_VISIBILITY")
+	_ = viper.BindEnv("repo-team", "REPO_TEAMS")
+	_ = viper.BindEnv("protect-default-branch", "PROTECT_DEFAULT_BRANCH")
+	_ = viper.BindEnv("required-approvals", "REQUIRED_APPROVALS")
+	_ = viper.BindEnv("require-code-owner-reviews", "REQUIRE_CODE_OWNER_REVIEWS")
+	_ = viper.BindEnv("required-status-checks", "REQUIRED_STATUS_CHECKS")
+	_ = viper.BindEnv("enforce-admins", "ENFORCE_ADMINS")
 	_ = viper.BindEnv("env-name", "ENV_NAME")
-	_ = viper.BindEnv("git-token", "GIT_TOKEN")
+	_ = viper.BindEnv("deploy-key", "DEPLOY_KEY")
+	_ = viper.BindEnv("argocd-webhook-url", "ARGOCD_WEBHOOK_URL")
 	_ = viper.BindEnv("base-repo", "BASE_REPO")
+	_ = viper.BindEnv("pull-request", "PULL_REQUEST")
+	_ = viper.BindEnv("wait-for-merge", "WAIT_FOR_MERGE")
+	_ = viper.BindEnv("merge-timeout", "MERGE_TIMEOUT")
 	viper.SetDefault("env-name", "production")
+	viper.SetDefault("repo-visibility", git.RepoVisibilityPrivate)
 	viper.SetDefault("base-repo", store.Get().BaseGitURL)
 	viper.SetDefault("dry-run", false)
+	viper.SetDefault("merge-timeout", time.Hour)
 
-	cmd.Flags().StringVar(&opts.repoURL, "repo-url", viper.GetString("repo-url"), "the clone url of an existing gitops repository url [REPO_URL]")
+	cmd.Flags().StringVar(&opts.RepoURL, "repo-url", viper.GetString("repo-url"), "the clone url of an existing gitops repository url, use \"<url>#<branch>\" to install to a branch other than the default branch [REPO_URL]")
 	cmd.Flags().StringVar(&opts.repoOwner, "repo-owner", viper.GetString("repo-owner"), "the name of the owner of the gitops repository to be created [REPO_OWNER]")
 	cmd.Flags().StringVar(&opts.repoName, "repo-name", viper.GetString("repo-name"), "the name of the gitops repository to be created [REPO_NAME]")
+	cmd.Flags().StringVar(&opts.repoDescription, "repo-description", viper.GetString("repo-description"), "the description of the gitops repository to be created (github only) [REPO_DESCRIPTION]")
+	cmd.Flags().StringVar(&opts.repoDefaultBranch, "repo-default-branch", viper.GetString("repo-default-branch"), "the name of the default branch of the gitops repository to be created (default: master) [REPO_DEFAULT_BRANCH]")
+	cmd.Flags().StringVar(&opts.repoVisibility, "repo-visibility", viper.GetString("repo-visibility"), "the visibility of the gitops repository to be created, one of: private, public, internal (github only) [REPO_VISIBILITY]")
+	cmd.Flags().StringSliceVar(&opts.repoTeams, "repo-team", viper.GetStringSlice("repo-team"), "grant a team access to the gitops repository to be created, in the form of \"<team-slug>:<permission>\", permission is one of: pull, triage, push, maintain, admin (github only, can be repeated) [REPO_TEAMS]")
+	cmd.Flags().BoolVar(&opts.protectDefaultBranch, "protect-default-branch", viper.GetBool("protect-default-branch"), "when true, the default branch of the gitops repository to be created is protected, after the initial push (github only) [PROTECT_DEFAULT_BRANCH]")
+	cmd.Flags().IntVar(&opts.requiredApprovals, "required-approvals", viper.GetInt("required-approvals"), "the number of approving reviews required to merge into the protected default branch, when 0 pull requests are not required [REQUIRED_APPROVALS]")
+	cmd.Flags().BoolVar(&opts.requireCodeOwnerReviews, "require-code-owner-reviews", viper.GetBool("require-code-owner-reviews"), "when true, merging into the protected default branch requires an approving review of a code owner [REQUIRE_CODE_OWNER_REVIEWS]")
+	cmd.Flags().StringSliceVar(&opts.requiredStatusChecks, "required-status-checks", viper.GetStringSlice("required-status-checks"), "status checks that must pass before merging into the protected default branch [REQUIRED_STATUS_CHECKS]")
+	cmd.Flags().BoolVar(&opts.enforceAdmins, "enforce-admins", viper.GetBool("enforce-admins"), "when true, the default branch protection applies to administrators too [ENFORCE_ADMINS]")
 	cmd.Flags().StringVar(&opts.envName, "env-name", viper.GetString("env-name"), "name of the Argo Enterprise environment to create [ENV_NAME")
-	cmd.Flags().StringVar(&opts.gitToken, "git-token", viper.GetString("git-token"), "git token which will be used by argo-cd to create the gitops repository [GIT_TOKEN]")
+	common.AddGitFlags(cmd, &opts.RepoOptions)
+	// argo-cd gets the credentials of the git operations
+	for name, usage := range map[string]string{
+		"git-token":                "git token which will be used by argo-cd to create the gitops repository [GIT_TOKEN]",
+		"ssh-private-key-path":     "path to an ssh private key, when set git operations and argo-cd will use ssh to access the gitops repository [SSH_PRIVATE_KEY_PATH]",
+		"ssh-private-key-password": "the passphrase of the ssh private key, argo-cd gets the decrypted key [SSH_PRIVATE_KEY_PASSWORD]",
+		"ssh-agent":                "when true, git operations will use the ssh agent to access the gitops repository to be created, requires --deploy-key for argo-cd [SSH_AGENT]",
+	} {
+		cmd.Flags().Lookup(name).Usage = usage
+	}
+	cmd.Flags().BoolVar(&opts.deployKey, "deploy-key", viper.GetBool("deploy-key"), "when true, argo-cd uses a generated read-only deploy key to access the gitops repository to be created, instead of the git token [DEPLOY_KEY]")
+	cmd.Flags().StringVar(&opts.argocdWebhookURL, "argocd-webhook-url", viper.GetString("argocd-webhook-url"), "the url of the argo-cd server webhook endpoint, e.g. \"https://argocd.example.com/api/webhook\", when set the gitops repository notifies argo-cd of pushes (github, gitlab and gitea only) [ARGOCD_WEBHOOK_URL]")
+	common.AddCommitFlags(cmd, &opts.CommitOptions)
+	cmd.Flags().BoolVar(&opts.pullRequest, "pull-request", viper.GetBool("pull-request"), "when true, the changes are pushed to a new branch and a pull request is opened, instead of pushing to the default branch [PULL_REQUEST]")
+	cmd.Flags().BoolVar(&opts.waitForMerge, "wait-for-merge", viper.GetBool("wait-for-merge"), "when true, wait for the pull request to be merged before creating the argo-cd application [WAIT_FOR_MERGE]")
+	cmd.Flags().DurationVar(&opts.mergeTimeout, "merge-timeout", viper.GetDuration("merge-timeout"), "how long to wait for the pull request to be merged [MERGE_TIMEOUT]")
 	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", viper.GetBool("dry-run"), "when true, the command will have no side effects, and will only output the manifests to stdout")
 	cmd.Flags().StringVar(&opts.baseRepo, "base-repo", viper.GetString("base-repo"), "the template repository url [BASE_REPO]")
 
-	cferrors.MustContext(ctx, cmd.MarkFlagRequired("git-token"))
 	cferrors.MustContext(ctx, cmd.Flags().MarkHidden("base-repo")) // hidden, for now
 
 	return cmd
 }
 
 func validateOpts(opts *options) {
-	if opts.repoURL != "" && opts.repoOwner != "" {
+	if opts.RepoURL != "" && opts.repoOwner != "" {
 		panic("--repo-url and --repo-owner are mutually exclusive")
 	}
-	if opts.repoURL != "" && opts.repoName != "" {
+	if opts.RepoURL != "" && opts.repoName != "" {
 		panic("--repo-url and --repo-name are mutually exclusive")
 	}
-	if opts.repoURL == "" && (opts.repoOwner == "" || opts.repoName == "") {
+	if opts.RepoURL == "" && (opts.repoOwner == "" || opts.repoName == "") {
 		panic("must provide --repo-url or --repo-owner and --repo-name")
 	}
+	if opts.pullRequest && opts.RepoURL == "" {
+		panic("--pull-request requires --repo-url")
+	}
+	if opts.waitForMerge && !opts.pullRequest {
+		panic("--wait-for-merge requires --pull-request")
+	}
+	common.ValidateCommitOpts(&opts.CommitOptions)
+	validateRefOpts(opts)
+	validateGithubAppOpts(opts)
+	validateNewRepoOpts(opts)
+	validateDeployKeyOpts(opts)
+	validateSSHOpts(opts)
+	if _, ok := argocdWebhookSecretKeys[opts.GitProvider]; opts.argocdWebhookURL != "" && !ok {
+		panic(fmt.Sprintf("--argocd-webhook-url is not supported with --git-provider %s", opts.GitProvider))
+	}
+	// the token is only optional when argo-cd and git use ssh to access an existing repo,
+	// when using a github app, or when the repo is on the local filesystem
+	if opts.GitToken == "" && opts.GithubAppID == 0 && opts.GitProvider != "file" && (opts.RepoURL == "" || opts.SSHPrivateKeyPath == "") {
+		panic("must provide --git-token, or configure a git credential helper or ~/.netrc for the git host")
+	}
+}
+
+// fillGitToken fills the git token by the host of the gitops repository, or
+// of the repository to be created
+func fillGitToken(ctx context.Context, opts *options) {
+	repoURL := opts.RepoURL
+	if repoURL == "" {
+		// invalid options are reported by validateOpts
+		repoURL, _ = git.RepoURL(opts.GitOptions(opts.dryRun), opts.repoOwner, opts.repoName)
+	}
+
+	common.FillGitToken(ctx, &opts.RepoOptions, repoURL)
+}
+
+// validateRefOpts checks the references in the repository urls, the gitops
+// repository can only be pushed to a branch, so a fragment that looks like a
+// commit sha is the name of a branch
+func validateRefOpts(opts *options) {
+	ref, err := git.ParseRef(opts.RepoURL)
+	if err != nil {
+		panic(fmt.Sprintf("invalid --repo-url: %s", err))
+	}
+	if ref.Type == git.RefTypeTag {
+		panic(fmt.Sprintf("--repo-url must reference a branch, got %s: %s", ref.Type, ref.Name))
+	}
+	if _, err = git.ParseRef(opts.baseRepo); err != nil {
+		panic(fmt.Sprintf("invalid --base-repo: %s", err))
+	}
+}
+
+func validateGithubAppOpts(opts *options) {
+	common.ValidateGithubAppOpts(&opts.RepoOptions)
+	if opts.GithubAppID != 0 && opts.SSHPrivateKeyPath != "" {
+		panic("--github-app-id and --ssh-private-key-path are mutually exclusive")
+	}
+}
+
+func validateDeployKeyOpts(opts *options) {
+	if !opts.deployKey {
+		return
+	}
+	if opts.RepoURL != "" {
+		panic("--deploy-key only applies to a new repository, and can not be used with --repo-url")
+	}
+	if opts.SSHPrivateKeyPath != "" {
+		panic("--deploy-key and --ssh-private-key-path are mutually exclusive")
+	}
+	if opts.GithubAppID != 0 {
+		panic("--deploy-key and --github-app-id are mutually exclusive")
+	}
+	if opts.GitProvider == "azure" || opts.GitProvider == "file" {
+		panic(fmt.Sprintf("--deploy-key is not supported with --git-provider %s", opts.GitProvider))
+	}
+}
+
+// validateSSHOpts checks the ssh options of git operations, argo-cd can not
+// use the keys of the ssh agent, so it requires a deploy key
+func validateSSHOpts(opts *options) {
+	if opts.SSHPrivateKeyPassword != "" && opts.SSHPrivateKeyPath == "" {
+		panic("--ssh-private-key-password requires --ssh-private-key-path")
+	}
+	if !opts.SSHAgent {
+		return
+	}
+	if opts.SSHPrivateKeyPath != "" {
+		panic("--ssh-agent and --ssh-private-key-path are mutually exclusive")
+	}
+	if !opts.deployKey {
+		panic("--ssh-agent requires --deploy-key, argo-cd can not use the keys of the ssh agent")
+	}
+}
+
+// validateNewRepoOpts validates the options of the gitops repository to be
+// created
+func validateNewRepoOpts(opts *options) {
+	isSet := opts.repoDescription != "" || opts.repoDefaultBranch != "" || len(opts.repoTeams) > 0 || opts.protectDefaultBranch ||
+		(opts.repoVisibility != "" && opts.repoVisibility != string(git.RepoVisibilityPrivate))
+	if opts.RepoURL != "" && isSet {
+		panic("--repo-description, --repo-default-branch, --repo-visibility, --repo-team and --protect-default-branch only apply to a new repository, and can not be used with --repo-url")
+	}
+
+	switch git.RepoVisibility(opts.repoVisibility) {
+	case "", git.RepoVisibilityPrivate, git.RepoVisibilityPublic, git.RepoVisibilityInternal:
+	default:
+		panic(fmt.Sprintf("--repo-visibility must be one of: private, public, internal, got: %s", opts.repoVisibility))
+	}
+
+	if _, err := parseTeams(opts.repoTeams); err != nil {
+		panic(err.Error())
+	}
+
+	if !opts.protectDefaultBranch && (opts.requiredApprovals != 0 || opts.requireCodeOwnerReviews || len(opts.requiredStatusChecks) > 0 || opts.enforceAdmins) {
+		panic("--required-approvals, --require-code-owner-reviews, --required-status-checks and --enforce-admins require --protect-default-branch")
+	}
+	if opts.requiredApprovals < 0 || opts.requiredApprovals > 6 {
+		panic("--required-approvals must be between 0 and 6")
+	}
+	if opts.requireCodeOwnerReviews && opts.requiredApprovals == 0 {
+		panic("--require-code-owner-reviews requires --required-approvals")
+	}
+
+	if opts.GitProvider != "github" && (opts.repoDescription != "" || len(opts.repoTeams) > 0 || opts.protectDefaultBranch ||
+		opts.repoVisibility == string(git.RepoVisibilityInternal)) {
+		panic("--repo-description, --repo-visibility internal, --repo-team and --protect-default-branch require --git-provider github")
+	}
+}
+
+// parseTeams parses "<team-slug>:<permission>" values
+func parseTeams(teams []string) ([]*git.TeamAccess, error) {
+	res := make([]*git.TeamAccess, 0, len(teams))
+	for _, t := range teams {
+		parts := strings.Split(t, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("--repo-team must be in the form of \"<team-slug>:<permission>\", got: %s", t)
+		}
+
+		switch parts[1] {
+		case "pull", "triage", "push", "maintain", "admin":
+		default:
+			return nil, fmt.Errorf("--repo-team permission must be one of: pull, triage, push, maintain, admin, got: %s", t)
+		}
+
+		res = append(res, &git.TeamAccess{
+			Slug:       parts[0],
+			Permission: parts[1],
+		})
+	}
+
+	return res, nil
 }
 
 // fill the values used to render the templates
@@ -112,14 +359,25 @@ func fillValues(opts *options) {
 	values.Namespace = fmt.Sprintf("%s-argocd", opts.envName)
 
 	renderValues.EnvName = opts.envName
-	if opts.repoURL != "" {
-		renderValues.RepoURL = opts.repoURL
-	} else {
-		renderValues.RepoURL = fmt.Sprintf("https://github.com/%s/%s", opts.repoOwner, opts.repoName)
+	switch {
+	case opts.RepoURL != "":
+		// argo-cd gets the branch as the target revision of the applications
+		ref, _ := git.ParseRef(opts.RepoURL)
+		renderValues.RepoURL = ref.URL
+	case opts.SSHPrivateKeyPath != "" || opts.deployKey:
+		renderValues.RepoURL, err = git.SSHRepoURL(opts.GitOptions(opts.dryRun), opts.repoOwner, opts.repoName)
+		cferrors.CheckErr(err)
+	default:
+		renderValues.RepoURL, err = git.RepoURL(opts.GitOptions(opts.dryRun), opts.repoOwner, opts.repoName)
+		cferrors.CheckErr(err)
 	}
 
 	renderValues.RepoOwnerURL = renderValues.RepoURL[:strings.LastIndex(renderValues.RepoURL, "/")]
-	renderValues.GitToken = base64.StdEncoding.EncodeToString([]byte(opts.gitToken))
+	if opts.SSHPrivateKeyPath == "" && opts.GithubAppID == 0 && !opts.deployKey {
+		// with ssh, a deploy key or a github app, argo-cd gets a repository
+		// secret with the private key instead
+		renderValues.GitToken = base64.StdEncoding.EncodeToString([]byte(opts.GitToken))
+	}
 }
 
 func install(ctx context.Context, opts *options) {
@@ -130,9 +388,13 @@ func install(ctx context.Context, opts *options) {
 		}
 	}()
 
+	if !opts.dryRun {
+		validateGitAccess(ctx, opts)
+	}
+
 	prepareBase(ctx, opts)
 
-	if opts.repoURL != "" {
+	if opts.RepoURL != "" {
 		cloneGitopsRepo(ctx, opts)
 	} else {
 		initGitopsRepo(ctx, opts)
@@ -144,6 +406,22 @@ func install(ctx context.Context, opts *options) {
 
 	createSealedSecret(ctx, opts)
 
+	if opts.SSHPrivateKeyPath != "" {
+		createSSHRepoSecret(ctx, opts)
+	}
+
+	if opts.deployKey {
+		createDeployKeyRepoSecret(ctx, opts)
+	}
+
+	if opts.GithubAppID != 0 {
+		createGithubAppRepoSecret(ctx, opts)
+	}
+
+	if opts.argocdWebhookURL != "" {
+		createArgocdWebhookSecret(ctx, opts)
+	}
+
 	persistGitopsRepo(ctx, opts)
 
 	createArgocdApp(ctx, opts)
@@ -151,24 +429,37 @@ func install(ctx context.Context, opts *options) {
 	printArgocdData(ctx, opts)
 }
 
+// validateGitAccess checks that the git token can push to the gitops repository,
+// or create it, before any change is made to the cluster
+func validateGitAccess(ctx context.Context, opts *options) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	ref, _ := git.ParseRef(opts.RepoURL)
+	cferrors.CheckErr(p.ValidateAccess(ctx, &git.ValidateAccessOptions{
+		RepoURL: ref.URL,
+		Owner:   opts.repoOwner,
+		Name:    opts.repoName,
+		Private: opts.repoVisibility != string(git.RepoVisibilityPublic),
+	}))
+}
+
 func prepareBase(ctx context.Context, opts *options) {
 	var err error
 	log.G(ctx).Printf("cloning template repository...")
 
-	values.TemplateRepoClonePath, err = ioutil.TempDir("", "tpl-")
-	cferrors.CheckErr(err)
+	values.TemplateRepoFS, values.TemplateRepoClonePath = newWorkdir(opts, "tpl-")
 
+	// the template history is not needed, only the worktree is on the filesystem
 	_, err = git.Clone(ctx, &git.CloneOptions{
-		URL:  opts.baseRepo,
-		Path: values.TemplateRepoClonePath,
+		URL: opts.baseRepo,
+		FS:  values.TemplateRepoFS,
 	})
 	cferrors.CheckErr(err)
 
-	cferrors.CheckErr(os.RemoveAll(filepath.Join(values.TemplateRepoClonePath, ".git")))
-
-	cferrors.CheckErr(helpers.RenameFilesWithEnvName(ctx, values.TemplateRepoClonePath, opts.envName))
+	cferrors.CheckErr(helpers.RenameFilesWithEnvName(ctx, values.TemplateRepoFS, opts.envName))
 
-	cferrors.CheckErr(helpers.RenderDirRecurse(filepath.Join(values.TemplateRepoClonePath, "**/*.*"), renderValues))
+	cferrors.CheckErr(helpers.RenderDirRecurse(values.TemplateRepoFS, "*.*", renderValues))
 
 	log.G(ctx).WithFields(log.Fields{
 		"path":     values.TemplateRepoClonePath,
@@ -178,23 +469,23 @@ func prepareBase(ctx context.Context, opts *options) {
 
 func cloneGitopsRepo(ctx context.Context, opts *options) {
 	log.G(ctx).Printf("cloning Gitops Repo")
-	p, err := git.NewProvider(&git.Options{
-		Type: "github", // only option for now
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
-	})
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
 	cferrors.CheckErr(err)
 
-	values.GitopsRepo, err = p.CloneRepository(ctx, opts.repoURL)
+	values.GitopsRepo, err = p.CloneRepository(ctx, opts.RepoURL)
 	cferrors.CheckErr(err)
 
-	values.GitopsRepoClonePath, err = values.GitopsRepo.Root()
+	values.GitopsRepoFS, err = values.GitopsRepo.Filesystem()
 	cferrors.CheckErr(err)
 
+	if !opts.dryRun {
+		values.GitopsRepoClonePath, err = values.GitopsRepo.Root()
+		cferrors.CheckErr(err)
+	}
+
 	log.G(ctx).WithFields(log.Fields{
 		"path":     values.GitopsRepoClonePath,
-		"cloneURL": opts.repoURL,
+		"cloneURL": opts.RepoURL,
 	}).Debug("Cloned Gitops repository")
 }
 
@@ -204,13 +495,15 @@ func initGitopsRepo(ctx context.Context, opts *options) {
 	log.G(ctx).Printf("initializing a new Gitops repository")
 	var err error
 	// use the template repo to init the new repo
-	values.GitopsRepoClonePath, err = ioutil.TempDir("", "repo-")
-	cferrors.CheckErr(err)
-
-	values.GitopsRepo, err = git.Init(ctx, values.GitopsRepoClonePath)
+	values.GitopsRepoFS, values.GitopsRepoClonePath = newWorkdir(opts, "repo-")
+	if opts.dryRun {
+		values.GitopsRepo, err = git.InitMemory(ctx, values.GitopsRepoFS, opts.repoDefaultBranch)
+	} else {
+		values.GitopsRepo, err = git.InitBranch(ctx, values.GitopsRepoClonePath, opts.repoDefaultBranch)
+	}
 	cferrors.CheckErr(err)
 
-	conf := envman.NewConfig(values.GitopsRepoClonePath)
+	conf := envman.NewConfig(values.GitopsRepoFS)
 	cferrors.CheckErr(conf.Persist())
 
 	log.G(ctx).WithField("path", values.GitopsRepoClonePath).Debug("Initialized Gitops repository")
@@ -218,28 +511,104 @@ func initGitopsRepo(ctx context.Context, opts *options) {
 
 func addInstallationToRepo(ctx context.Context, opts *options) {
 	log.G(ctx).Printf("adding installation to Gitops repository")
-	conf, err := envman.LoadConfig(values.GitopsRepoClonePath)
+	conf, err := envman.LoadConfig(values.GitopsRepoFS)
 	cferrors.CheckErr(err)
 
 	if _, exists := conf.Environments[opts.envName]; exists {
 		panic(fmt.Errorf("environment with name \"%s\" already exists in target repository", opts.envName))
 	}
 
-	tplConf, err := envman.LoadConfig(values.TemplateRepoClonePath)
-	cferrors.CheckErr(err)
+	if opts.RepoURL != "" {
+		// installing the environment changes the template, keep a copy in case
+		// the push to the existing repository is rejected
+		values.TemplateSnapshotFS, values.TemplateSnapshotPath = newWorkdir(opts, "tpl-")
+		cferrors.CheckErr(helpers.CopyDir(values.TemplateRepoFS, "/", values.TemplateSnapshotFS, "/"))
+	}
 
-	tplEnv := tplConf.FirstEnv()
-	tplEnv.UpdateTemplateRef(opts.baseRepo)
+	tplEnv, err := loadTemplateEnv(values.TemplateRepoFS, opts)
+	cferrors.CheckErr(err)
 
 	log.G(ctx).Printf("installing bootstrap resources...")
 	cferrors.CheckErr(conf.AddEnvironmentP(ctx, tplEnv, renderValues, opts.dryRun))
 
+	cferrors.CheckErr(updateTargetRevision(conf, opts))
+
 	log.G(ctx).WithFields(log.Fields{
 		"env": opts.envName,
 		"tpl": opts.baseRepo,
 	}).Debug("added instlaation to Gitops repostory")
 }
 
+// replayInstallation adds the environment to the gitops repository again, after
+// it was reset to the remote branch. The bootstrap resources and the sealed
+// secrets were already applied, so only the repository is changed.
+func replayInstallation(ctx context.Context, opts *options) error {
+	log.G(ctx).Printf("replaying installation on top of the remote changes...")
+	tplFS := memfs.New()
+	if err := helpers.CopyDir(values.TemplateSnapshotFS, "/", tplFS, "/"); err != nil {
+		return err
+	}
+
+	conf, err := envman.LoadConfig(values.GitopsRepoFS)
+	if err != nil {
+		return err
+	}
+
+	tplEnv, err := loadTemplateEnv(tplFS, opts)
+	if err != nil {
+		return err
+	}
+
+	if err = conf.AddEnvironment(tplEnv); err != nil {
+		return err
+	}
+
+	if err = updateTargetRevision(conf, opts); err != nil {
+		return err
+	}
+
+	argocdApp := getArgocdApp(opts)
+	if values.SealedSecret != nil {
+		if err = writeSealedSecret(argocdApp, values.SealedSecret); err != nil {
+			return err
+		}
+	}
+
+	for fileName, data := range values.ArgocdResources {
+		if err = argocdApp.AddResource(fileName, data); err != nil {
+			return err
+		}
+	}
+
+	for fileName, data := range values.ArgocdPatches {
+		if err = argocdApp.AddPatch(fileName, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadTemplateEnv(fs billy.Filesystem, opts *options) (*envman.Environment, error) {
+	tplConf, err := envman.LoadConfig(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	tplEnv := tplConf.FirstEnv()
+	tplEnv.UpdateTemplateRef(opts.baseRepo)
+
+	return tplEnv, nil
+}
+
+func updateTargetRevision(conf *envman.Config, opts *options) error {
+	if ref, _ := git.ParseRef(opts.RepoURL); ref.Type == git.RefTypeBranch || ref.Type == git.RefTypeSHA {
+		return conf.Environments[opts.envName].UpdateTargetRevision(renderValues.RepoURL, ref.Name)
+	}
+
+	return nil
+}
+
 func waitForDeployments(ctx context.Context, opts *options) {
 	log.G(ctx).Printf("waiting for argocd initialization to complete... (might take a few seconds)")
 	deploymentTest := func(ctx context.Context, c kube.Client, ns, name string) (bool, error) {
@@ -278,8 +647,8 @@ func waitForDeployments(ctx context.Context, opts *options) {
 }
 
 func createSealedSecret(ctx context.Context, opts *options) {
-	secretPath := filepath.Join(values.TemplateRepoClonePath, values.BootstrapDir, "secret.yaml")
-	s, err := ss.CreateSealedSecretFromSecretFile(ctx, values.Namespace, secretPath, opts.dryRun)
+	secretPath := filepath.Join(values.BootstrapDir, "secret.yaml")
+	s, err := ss.CreateSealedSecretFromSecretFile(ctx, values.TemplateRepoFS, values.Namespace, secretPath, opts.dryRun)
 	cferrors.CheckErr(err)
 
 	data, err := json.Marshal(s)
@@ -288,23 +657,203 @@ func createSealedSecret(ctx context.Context, opts *options) {
 	err = apply(ctx, opts, data)
 	cferrors.CheckErr(err)
 
-	conf, err := envman.LoadConfig(values.GitopsRepoClonePath)
+	values.SealedSecret = data
+	cferrors.CheckErr(writeSealedSecret(getArgocdApp(opts), data))
+}
+
+func writeSealedSecret(argocdApp *envman.Application, data []byte) error {
+	destPath := filepath.Join(argocdApp.Spec.Source.Path, "sealed-secret.json")
+	return util.WriteFile(values.GitopsRepoFS, destPath, data, 0644)
+}
+
+// createSSHRepoSecret registers the gitops repository in argo-cd with the ssh
+// private key, as a sealed secret managed by the argo-cd application
+func createSSHRepoSecret(ctx context.Context, opts *options) {
+	key, err := ioutil.ReadFile(opts.SSHPrivateKeyPath)
+	cferrors.CheckErr(err)
+
+	if opts.SSHPrivateKeyPassword != "" {
+		// argo-cd does not support passphrase protected keys
+		key, err = git.DecryptPrivateKey(key, []byte(opts.SSHPrivateKeyPassword))
+		cferrors.CheckErr(err)
+	} else if _, err = ssh.ParseRawPrivateKey(key); err != nil {
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			panic(fmt.Errorf("argo-cd does not support passphrase protected ssh keys, provide --ssh-private-key-password"))
+		}
+		panic(err)
+	}
+
+	addSSHRepoSecret(ctx, opts, key)
+}
+
+// createDeployKeyRepoSecret generates the deploy key, and registers the gitops
+// repository in argo-cd with its private key. The public key is added to the
+// repository once it is created.
+func createDeployKeyRepoSecret(ctx context.Context, opts *options) {
+	var err error
+	values.DeployKey, err = git.GenerateDeployKey()
+	cferrors.CheckErr(err)
+
+	addSSHRepoSecret(ctx, opts, values.DeployKey.PrivateKey)
+}
+
+func addSSHRepoSecret(ctx context.Context, opts *options, key []byte) {
+	addArgocdSecret(ctx, opts, "repo-secret.json", &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-gitops-repo", opts.envName),
+			Namespace: values.Namespace,
+			Labels: map[string]string{
+				argocdSecretTypeLabel: "repository",
+			},
+		},
+		Data: map[string][]byte{
+			"type":          []byte("git"),
+			"url":           []byte(renderValues.RepoURL),
+			"sshPrivateKey": key,
+		},
+	})
+}
+
+// createGithubAppRepoSecret registers the gitops repository in argo-cd with the
+// github app credentials, as a sealed secret managed by the argo-cd application
+func createGithubAppRepoSecret(ctx context.Context, opts *options) {
+	key, err := ioutil.ReadFile(opts.GithubAppPrivateKeyPath)
+	cferrors.CheckErr(err)
+
+	data := map[string][]byte{
+		"type":                    []byte("git"),
+		"url":                     []byte(renderValues.RepoURL),
+		"githubAppID":             []byte(strconv.FormatInt(opts.GithubAppID, 10)),
+		"githubAppInstallationID": []byte(strconv.FormatInt(opts.GithubAppInstallationID, 10)),
+		"githubAppPrivateKey":     key,
+	}
+	if opts.GitHost != "" {
+		data["githubAppEnterpriseBaseUrl"] = []byte(git.GithubEnterpriseAPIURL(opts.GitHost))
+	}
+
+	addArgocdSecret(ctx, opts, "repo-secret.json", &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-gitops-repo", opts.envName),
+			Namespace: values.Namespace,
+			Labels: map[string]string{
+				argocdSecretTypeLabel: "repository",
+			},
+		},
+		Data: data,
+	})
+}
+
+// createArgocdWebhookSecret generates the webhook secret, and adds it to argo-cd
+// as a sealed secret. argocd-secret is patched to reference it, so the secret
+// is never committed in plain text.
+func createArgocdWebhookSecret(ctx context.Context, opts *options) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	cferrors.CheckErr(err)
+	values.WebhookSecret = hex.EncodeToString(secret)
+
+	name := fmt.Sprintf("%s-argocd-webhook", opts.envName)
+	key := argocdWebhookSecretKeys[opts.GitProvider]
+	addArgocdSecret(ctx, opts, "webhook-secret.json", &corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: values.Namespace,
+			Labels: map[string]string{
+				// argo-cd only resolves references to its own secrets
+				argocdPartOfLabel: "argocd",
+			},
+		},
+		Data: map[string][]byte{
+			key: []byte(values.WebhookSecret),
+		},
+	})
+
+	data, err := json.Marshal(&corev1.Secret{
+		TypeMeta: v1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: v1.ObjectMeta{
+			Name: "argocd-secret",
+		},
+		StringData: map[string]string{
+			key: fmt.Sprintf("$%s:%s", name, key),
+		},
+	})
+	cferrors.CheckErr(err)
+
+	addArgocdPatch(opts, "argocd-secret-webhook.json", data)
+}
+
+// addArgocdPatch adds the patch to the argo-cd application
+func addArgocdPatch(opts *options, fileName string, data []byte) {
+	if values.ArgocdPatches == nil {
+		values.ArgocdPatches = make(map[string][]byte)
+	}
+	values.ArgocdPatches[fileName] = data
+
+	cferrors.CheckErr(getArgocdApp(opts).AddPatch(fileName, data))
+}
+
+// addArgocdSecret seals the secret, applies it, and adds it to the argo-cd
+// application, so it will keep being managed by argo-cd
+func addArgocdSecret(ctx context.Context, opts *options, fileName string, secret *corev1.Secret) {
+	s, err := ss.CreateSealedSecret(ctx, values.Namespace, secret, opts.dryRun)
+	cferrors.CheckErr(err)
+
+	data, err := json.Marshal(s)
+	cferrors.CheckErr(err)
+
+	cferrors.CheckErr(apply(ctx, opts, data))
+
+	if values.ArgocdResources == nil {
+		values.ArgocdResources = make(map[string][]byte)
+	}
+	values.ArgocdResources[fileName] = data
+
+	cferrors.CheckErr(getArgocdApp(opts).AddResource(fileName, data))
+}
+
+func getArgocdApp(opts *options) *envman.Application {
+	conf, err := envman.LoadConfig(values.GitopsRepoFS)
 	cferrors.CheckErr(err)
 
 	env := conf.Environments[opts.envName]
 	argocdApp, err := env.GetApp("argo-cd")
 	cferrors.CheckErr(err)
 
-	destPath := filepath.Join(values.GitopsRepoClonePath, argocdApp.Spec.Source.Path, "sealed-secret.json")
-	cferrors.CheckErr(ioutil.WriteFile(destPath, data, 0644))
+	return argocdApp
 }
 
 func persistGitopsRepo(ctx context.Context, opts *options) {
-	cferrors.CheckErr(os.RemoveAll(filepath.Join(values.TemplateRepoClonePath, values.BootstrapDir)))
+	var err error
+	cferrors.CheckErr(util.RemoveAll(values.TemplateRepoFS, values.BootstrapDir))
+
+	msg := fmt.Sprintf("added environment %s", opts.envName)
+	base, head := "", ""
+	if opts.pullRequest {
+		base, err = values.GitopsRepo.CurrentBranch()
+		cferrors.CheckErr(err)
+
+		head = fmt.Sprintf("cf-argo/install-%s-%s", opts.envName, time.Now().Format("20060102-150405"))
+		cferrors.CheckErr(values.GitopsRepo.CreateBranch(ctx, head))
+	}
 
 	cferrors.CheckErr(values.GitopsRepo.Add(ctx, "."))
 
-	_, err := values.GitopsRepo.Commit(ctx, fmt.Sprintf("added environment %s", opts.envName))
+	_, err = values.GitopsRepo.Commit(ctx, opts.GitCommitOptions(msg))
 	cferrors.CheckErr(err)
 
 	if opts.dryRun {
@@ -319,27 +868,146 @@ func persistGitopsRepo(ctx context.Context, opts *options) {
 		cloneURL, err := createRemoteRepo(ctx, opts)
 		cferrors.CheckErr(err)
 
+		if opts.SSHPrivateKeyPath != "" || opts.SSHAgent {
+			cloneURL = renderValues.RepoURL
+		}
+
 		cferrors.CheckErr(values.GitopsRepo.AddRemote(ctx, "origin", cloneURL))
+
+		if opts.deployKey {
+			addDeployKey(ctx, opts, cloneURL)
+		}
 	}
 
 	log.G(ctx).Printf("pushing to gitops repo...")
-	err = values.GitopsRepo.Push(ctx, &git.PushOptions{
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
+	pushOpts := &git.PushOptions{
+		Auth: opts.GitAuth(),
+	}
+	if opts.pullRequest || isNewRepo {
+		// nobody else is pushing to the branch
+		err = values.GitopsRepo.Push(ctx, pushOpts)
+	} else {
+		_, err = git.PushWithRetry(ctx, values.GitopsRepo, &git.PushRetryOptions{
+			Push:   pushOpts,
+			Commit: opts.GitCommitOptions(msg),
+			Replay: func(ctx context.Context) error {
+				return replayInstallation(ctx, opts)
+			},
+		})
+	}
+	cferrors.CheckErr(err)
+
+	if isNewRepo && opts.protectDefaultBranch {
+		protectDefaultBranch(ctx, opts)
+	}
+
+	if opts.argocdWebhookURL != "" {
+		createWebhook(ctx, opts)
+	}
+
+	if opts.pullRequest {
+		createPullRequest(ctx, opts, base, head, msg)
+	}
+}
+
+// addDeployKey gives argo-cd read-only access to the new repository
+func addDeployKey(ctx context.Context, opts *options, cloneURL string) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	log.G(ctx).Printf("adding deploy key to gitops repository...")
+	cferrors.CheckErr(p.AddDeployKey(ctx, &git.DeployKeyOptions{
+		RepoURL:   cloneURL,
+		Title:     fmt.Sprintf("argo-cd %s", opts.envName),
+		PublicKey: values.DeployKey.PublicKey,
+		ReadOnly:  true,
+	}))
+}
+
+// createWebhook notifies argo-cd of pushes to the gitops repository, so it does
+// not wait for the next periodic refresh
+func createWebhook(ctx context.Context, opts *options) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	wc, ok := p.(git.WebhookCreator)
+	if !ok {
+		panic(fmt.Errorf("git provider %s does not support webhooks", opts.GitProvider))
+	}
+
+	log.G(ctx).Printf("creating gitops repository webhook...")
+	cferrors.CheckErr(wc.CreateWebhook(ctx, &git.WebhookOptions{
+		RepoURL: renderValues.RepoURL,
+		URL:     opts.argocdWebhookURL,
+		Secret:  values.WebhookSecret,
+	}))
+}
+
+// protectDefaultBranch protects the branch that was pushed to the new repository
+func protectDefaultBranch(ctx context.Context, opts *options) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	bp, ok := p.(git.BranchProtector)
+	if !ok {
+		panic(fmt.Errorf("git provider %s does not support branch protection", opts.GitProvider))
+	}
+
+	branch, err := values.GitopsRepo.CurrentBranch()
+	cferrors.CheckErr(err)
+
+	log.G(ctx).Printf("protecting branch %s...", branch)
+	cferrors.CheckErr(bp.ProtectBranch(ctx, &git.ProtectBranchOptions{
+		RepoURL:                 renderValues.RepoURL,
+		Branch:                  branch,
+		RequiredApprovals:       opts.requiredApprovals,
+		RequireCodeOwnerReviews: opts.requireCodeOwnerReviews,
+		RequiredStatusChecks:    opts.requiredStatusChecks,
+		EnforceAdmins:           opts.enforceAdmins,
+	}))
+}
+
+// createPullRequest opens a pull request from head into base, and waits for it
+// to be merged when required
+func createPullRequest(ctx context.Context, opts *options, base, head, title string) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	pr, err := p.CreatePullRequest(ctx, &git.PullRequestOptions{
+		RepoURL:     opts.RepoURL,
+		Head:        head,
+		Base:        base,
+		Title:       title,
+		Description: fmt.Sprintf("Installs the Argo Enterprise environment %s", opts.envName),
 	})
 	cferrors.CheckErr(err)
+
+	log.G(ctx).Printf("created pull request: %s", pr.URL)
+	if !opts.waitForMerge {
+		log.G(ctx).Printf("argo-cd will sync the environment once the pull request is merged")
+		return
+	}
+
+	log.G(ctx).Printf("waiting for the pull request to be merged...")
+	ctx, cancel := context.WithTimeout(ctx, opts.mergeTimeout)
+	defer cancel()
+
+	_, err = git.WaitForMerge(ctx, p, &git.GetPullRequestOptions{
+		RepoURL: opts.RepoURL,
+		ID:      pr.ID,
+	}, mergePollInterval)
+	cferrors.CheckErr(err)
 }
 
 func createArgocdApp(ctx context.Context, opts *options) {
-	tplConf, err := envman.LoadConfig(values.GitopsRepoClonePath)
+	tplConf, err := envman.LoadConfig(values.GitopsRepoFS)
 	cferrors.CheckErr(err)
-	absArgoAppsDir := filepath.Join(values.GitopsRepoClonePath, filepath.Dir(tplConf.Environments[opts.envName].RootApplicationPath))
+	argoAppsDir := filepath.Dir(tplConf.Environments[opts.envName].RootApplicationPath)
 
-	projData, err := ioutil.ReadFile(filepath.Join(absArgoAppsDir, fmt.Sprintf("%s-project.yaml", opts.envName)))
+	projData, err := util.ReadFile(values.GitopsRepoFS, filepath.Join(argoAppsDir, fmt.Sprintf("%s-project.yaml", opts.envName)))
 	cferrors.CheckErr(err)
 
-	appData, err := ioutil.ReadFile(filepath.Join(absArgoAppsDir, fmt.Sprintf("%s.yaml", opts.envName)))
+	appData, err := util.ReadFile(values.GitopsRepoFS, filepath.Join(argoAppsDir, fmt.Sprintf("%s.yaml", opts.envName)))
 	cferrors.CheckErr(err)
 
 	manifests := []byte(fmt.Sprintf("%s\n\n---\n%s", string(projData), string(appData)))
@@ -374,20 +1042,24 @@ func apply(ctx context.Context, opts *options, data []byte) error {
 }
 
 func createRemoteRepo(ctx context.Context, opts *options) (string, error) {
-	p, err := git.NewProvider(&git.Options{
-		Type: "github", // need to support other types
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
-	})
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	if err != nil {
+		return "", err
+	}
+
+	teams, err := parseTeams(opts.repoTeams)
 	if err != nil {
 		return "", err
 	}
 
 	cloneURL, err := p.CreateRepository(ctx, &git.CreateRepoOptions{
-		Owner:   opts.repoOwner,
-		Name:    opts.repoName,
-		Private: true,
+		Owner:         opts.repoOwner,
+		Name:          opts.repoName,
+		Private:       opts.repoVisibility != string(git.RepoVisibilityPublic),
+		Description:   opts.repoDescription,
+		DefaultBranch: opts.repoDefaultBranch,
+		Visibility:    git.RepoVisibility(opts.repoVisibility),
+		Teams:         teams,
 	})
 	if err != nil {
 		return "", err
@@ -397,29 +1069,43 @@ func createRemoteRepo(ctx context.Context, opts *options) (string, error) {
 }
 
 func checkRepoNotExist(ctx context.Context, opts *options) {
-	p, err := git.NewProvider(&git.Options{
-		Type: "github", // need to support other types
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
-	})
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
 	cferrors.CheckErr(err)
 
 	_, err = p.GetRepository(ctx, &git.GetRepoOptions{
 		Owner: opts.repoOwner,
 		Name:  opts.repoName,
 	})
+	if err == nil {
+		panic(fmt.Errorf("repository already exists: %s, you should use --repo-url if you want to add a new installation to it", renderValues.RepoURL))
+	}
 	if err != git.ErrRepoNotFound {
-		panic(fmt.Errorf("repository already exists: %s/%s, you should use --repo-url if you want to add a new installation to it", opts.repoOwner, opts.repoName))
+		panic(fmt.Errorf("failed to check if repository %s exists: %w", renderValues.RepoURL, err))
 	}
 }
 
+// newWorkdir returns the filesystem of a new temp dir, and its path. In dry-run
+// the filesystem is in memory and the path is empty, so nothing is left on disk
+func newWorkdir(opts *options, prefix string) (billy.Filesystem, string) {
+	if opts.dryRun {
+		return memfs.New(), ""
+	}
+
+	path, err := ioutil.TempDir("", prefix)
+	cferrors.CheckErr(err)
+
+	return osfs.New(path), path
+}
+
 func cleanup(ctx context.Context) {
-	log.G(ctx).Debugf("cleaning dirs: %s", strings.Join([]string{values.GitopsRepoClonePath, values.TemplateRepoClonePath}, ","))
+	log.G(ctx).Debugf("cleaning dirs: %s", strings.Join([]string{values.GitopsRepoClonePath, values.TemplateRepoClonePath, values.TemplateSnapshotPath}, ","))
 	if err := os.RemoveAll(values.GitopsRepoClonePath); err != nil && !os.IsNotExist(err) {
 		log.G(ctx).WithError(err).Error("failed to clean user local repo")
 	}
 	if err := os.RemoveAll(values.TemplateRepoClonePath); err != nil && !os.IsNotExist(err) {
 		log.G(ctx).WithError(err).Error("failed to clean template repo")
 	}
+	if err := os.RemoveAll(values.TemplateSnapshotPath); err != nil && !os.IsNotExist(err) {
+		log.G(ctx).WithError(err).Error("failed to clean template snapshot")
+	}
 }
@@ -0,0 +1,263 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_persistGitopsRepo_newFileRepo(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	values.TemplateRepoClonePath, err = ioutil.TempDir("", "tpl-")
+	assert.NoError(t, err)
+	values.GitopsRepoClonePath, err = ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer cleanup(ctx)
+
+	values.TemplateRepoFS = osfs.New(values.TemplateRepoClonePath)
+	values.GitopsRepoFS = osfs.New(values.GitopsRepoClonePath)
+	values.GitopsRepo, err = git.Init(ctx, values.GitopsRepoClonePath)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(values.GitopsRepoClonePath))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(values.GitopsRepoClonePath, "config.json"), []byte("{}"), 0644))
+
+	opts := &options{
+		RepoOptions: common.RepoOptions{GitProvider: "file"},
+		repoOwner:   host,
+		repoName:    "gitops",
+		envName:     "production",
+	}
+	persistGitopsRepo(ctx, opts)
+
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	assert.NoError(t, err)
+	cloneURL, err := p.GetRepository(ctx, &git.GetRepoOptions{Owner: host, Name: "gitops"})
+	assert.NoError(t, err)
+
+	r, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err := r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "config.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+}
+
+func Test_persistGitopsRepo_dryRun(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	opts := &options{
+		RepoOptions: common.RepoOptions{GitProvider: "github"},
+		CommitOptions: common.CommitOptions{
+			AuthorName:  "cf-argo",
+			AuthorEmail: "cf-argo@example.com",
+		},
+		repoOwner: "foo",
+		repoName:  "gitops",
+		envName:   "production",
+		dryRun:    true,
+	}
+
+	var err error
+	values.TemplateRepoFS, values.TemplateRepoClonePath = newWorkdir(opts, "tpl-")
+	values.GitopsRepoFS, values.GitopsRepoClonePath = newWorkdir(opts, "repo-")
+	defer cleanup(ctx)
+	assert.Empty(t, values.TemplateRepoClonePath)
+	assert.Empty(t, values.GitopsRepoClonePath)
+
+	values.GitopsRepo, err = git.InitMemory(ctx, values.GitopsRepoFS, "main")
+	assert.NoError(t, err)
+	assert.NoError(t, envman.NewConfig(values.GitopsRepoFS).Persist())
+
+	persistGitopsRepo(ctx, opts)
+
+	commits, err := values.GitopsRepo.Log("")
+	assert.NoError(t, err)
+	assert.Len(t, commits, 1)
+	branch, err := values.GitopsRepo.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+}
+
+func Test_fillValues(t *testing.T) {
+	tests := map[string]struct {
+		opts             *options
+		wantRepoURL      string
+		wantRepoOwnerURL string
+		wantGitToken     string
+	}{
+		"Github": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar"},
+			wantRepoURL:      "https://github.com/foo/bar",
+			wantRepoOwnerURL: "https://github.com/foo",
+			wantGitToken:     "dG9rZW4=",
+		},
+		"Github App": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GithubAppID: 1, GithubAppInstallationID: 2, GithubAppPrivateKeyPath: "app.pem"}, repoOwner: "foo", repoName: "bar"},
+			wantRepoURL:      "https://github.com/foo/bar",
+			wantRepoOwnerURL: "https://github.com/foo",
+		},
+		"Github Enterprise": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitHost: "https://github.example.com/"}, repoOwner: "foo", repoName: "bar"},
+			wantRepoURL:      "https://github.example.com/foo/bar",
+			wantRepoOwnerURL: "https://github.example.com/foo",
+		},
+		"Github Enterprise ssh": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitHost: "https://github.example.com/", SSHPrivateKeyPath: "id_rsa"}, repoOwner: "foo", repoName: "bar"},
+			wantRepoURL:      "git@github.example.com:foo/bar.git",
+			wantRepoOwnerURL: "git@github.example.com:foo",
+		},
+		"Deploy key": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", deployKey: true},
+			wantRepoURL:      "git@github.com:foo/bar.git",
+			wantRepoOwnerURL: "git@github.com:foo",
+		},
+		"Existing repo branch": {
+			opts:             &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitHost: "https://github.example.com/", RepoURL: "https://github.example.com/foo/bar#staging"}},
+			wantRepoURL:      "https://github.example.com/foo/bar",
+			wantRepoOwnerURL: "https://github.example.com/foo",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			renderValues.GitToken = ""
+			fillValues(test.opts)
+			assert.Equal(t, test.wantRepoURL, renderValues.RepoURL)
+			assert.Equal(t, test.wantRepoOwnerURL, renderValues.RepoOwnerURL)
+			assert.Equal(t, test.wantGitToken, renderValues.GitToken)
+		})
+	}
+}
+
+func Test_validateOpts(t *testing.T) {
+	tests := map[string]struct {
+		opts      *options
+		wantPanic string
+	}{
+		"Token": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar"},
+		},
+		"Missing token": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github"}, repoOwner: "foo", repoName: "bar"},
+			wantPanic: "must provide --git-token, or configure a git credential helper or ~/.netrc for the git host",
+		},
+		"Github App": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", GithubAppID: 1, GithubAppInstallationID: 2, GithubAppPrivateKeyPath: "app.pem"}, repoOwner: "foo", repoName: "bar"},
+		},
+		"Github App missing installation": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GithubAppID: 1, GithubAppPrivateKeyPath: "app.pem"}, repoOwner: "foo", repoName: "bar"},
+			wantPanic: "--github-app-id, --github-app-installation-id and --github-app-private-key-path must be provided together",
+		},
+		"Github App with gitlab": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "gitlab", GithubAppID: 1, GithubAppInstallationID: 2, GithubAppPrivateKeyPath: "app.pem"}, repoOwner: "foo", repoName: "bar"},
+			wantPanic: "--github-app-id requires --git-provider github",
+		},
+		"Github App with ssh": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "git@github.com:foo/bar.git", SSHPrivateKeyPath: "id_rsa", GithubAppID: 1, GithubAppInstallationID: 2, GithubAppPrivateKeyPath: "app.pem"}},
+			wantPanic: "--github-app-id and --ssh-private-key-path are mutually exclusive",
+		},
+		"New repo options": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", repoDescription: "gitops", repoDefaultBranch: "main", repoVisibility: "internal", repoTeams: []string{"devops:maintain"}, protectDefaultBranch: true, requiredApprovals: 1, requireCodeOwnerReviews: true},
+		},
+		"New repo options with repo url": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "https://github.com/foo/bar.git", GitToken: "token"}, repoDefaultBranch: "main"},
+			wantPanic: "--repo-description, --repo-default-branch, --repo-visibility, --repo-team and --protect-default-branch only apply to a new repository, and can not be used with --repo-url",
+		},
+		"Invalid visibility": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", repoVisibility: "secret"},
+			wantPanic: "--repo-visibility must be one of: private, public, internal, got: secret",
+		},
+		"Invalid team": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", repoTeams: []string{"devops"}},
+			wantPanic: "--repo-team must be in the form of \"<team-slug>:<permission>\", got: devops",
+		},
+		"Invalid team permission": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", repoTeams: []string{"devops:write"}},
+			wantPanic: "--repo-team permission must be one of: pull, triage, push, maintain, admin, got: devops:write",
+		},
+		"Protection rules without protection": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", requiredApprovals: 1},
+			wantPanic: "--required-approvals, --require-code-owner-reviews, --required-status-checks and --enforce-admins require --protect-default-branch",
+		},
+		"Protection with gitlab": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "gitlab", GitToken: "token"}, repoOwner: "foo", repoName: "bar", protectDefaultBranch: true},
+			wantPanic: "--repo-description, --repo-visibility internal, --repo-team and --protect-default-branch require --git-provider github",
+		},
+		"Deploy key": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "gitlab", GitToken: "token"}, repoOwner: "foo", repoName: "bar", deployKey: true},
+		},
+		"Deploy key with repo url": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "https://github.com/foo/bar.git", GitToken: "token"}, deployKey: true},
+			wantPanic: "--deploy-key only applies to a new repository, and can not be used with --repo-url",
+		},
+		"Deploy key with azure": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "azure", GitToken: "token"}, repoOwner: "org/project", repoName: "bar", deployKey: true},
+			wantPanic: "--deploy-key is not supported with --git-provider azure",
+		},
+		"Ssh key password": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "git@github.com:foo/bar.git", SSHPrivateKeyPath: "id_rsa", SSHPrivateKeyPassword: "secret"}},
+		},
+		"Ssh key password without key": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "https://github.com/foo/bar.git", GitToken: "token", SSHPrivateKeyPassword: "secret"}},
+			wantPanic: "--ssh-private-key-password requires --ssh-private-key-path",
+		},
+		"Ssh agent": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token", SSHAgent: true}, repoOwner: "foo", repoName: "bar", deployKey: true},
+		},
+		"Ssh agent without deploy key": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token", SSHAgent: true}, repoOwner: "foo", repoName: "bar"},
+			wantPanic: "--ssh-agent requires --deploy-key, argo-cd can not use the keys of the ssh agent",
+		},
+		"Ssh agent with ssh key": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "git@github.com:foo/bar.git", SSHPrivateKeyPath: "id_rsa", SSHAgent: true}},
+			wantPanic: "--ssh-agent and --ssh-private-key-path are mutually exclusive",
+		},
+		"Webhook": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "gitea", GitToken: "token"}, repoOwner: "foo", repoName: "bar", argocdWebhookURL: "https://argocd.example.com/api/webhook"},
+		},
+		"Webhook with bitbucket": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "bitbucket", GitToken: "token"}, repoOwner: "foo", repoName: "bar", argocdWebhookURL: "https://argocd.example.com/api/webhook"},
+			wantPanic: "--argocd-webhook-url is not supported with --git-provider bitbucket",
+		},
+		"Repo url with tag": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "https://github.com/foo/bar@v0.0.1", GitToken: "token"}},
+			wantPanic: "--repo-url must reference a branch, got tag: v0.0.1",
+		},
+		"Repo url with hex branch": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", RepoURL: "https://github.com/foo/bar#deadbeef", GitToken: "token"}},
+		},
+		"Base repo with sha": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", baseRepo: "https://github.com/foo/template#f24fcad"},
+		},
+		"Invalid base repo": {
+			opts:      &options{RepoOptions: common.RepoOptions{GitProvider: "github", GitToken: "token"}, repoOwner: "foo", repoName: "bar", baseRepo: "https://github.com/foo/template#"},
+			wantPanic: "invalid --base-repo: missing branch or commit sha after \"#\" in url: https://github.com/foo/template#",
+		},
+		"Default branch with gitlab": {
+			opts: &options{RepoOptions: common.RepoOptions{GitProvider: "gitlab", GitToken: "token"}, repoOwner: "foo", repoName: "bar", repoDefaultBranch: "main", repoVisibility: "public"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.wantPanic != "" {
+				assert.PanicsWithValue(t, test.wantPanic, func() { validateOpts(test.opts) })
+				return
+			}
+
+			assert.NotPanics(t, func() { validateOpts(test.opts) })
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package promote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
+	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	"github.com/codefresh-io/cf-argo/pkg/log"
+	"github.com/go-git/go-billy/v5"
+	"github.com/spf13/cobra"
+)
+
+type options struct {
+	common.RepoOptions
+	common.CommitOptions
+	appName string
+	from    string
+	to      string
+	replace bool
+	dryRun  bool
+}
+
+func New(ctx context.Context) *cobra.Command {
+	var opts options
+
+	cmd := &cobra.Command{
+		Use:   "promote",
+		Short: "Promote the overlay of an application from one environment to another",
+		Long:  "This command will merge the kustomize overlay of the application in the source environment into its overlay in the target environment, show the resulting diff, and push the changes to the gitops repository",
+		Run: func(cmd *cobra.Command, args []string) {
+			validateOpts(&opts)
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			promote(ctx, &opts, os.Stdout)
+		},
+	}
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	common.AddCommitFlags(cmd, &opts.CommitOptions)
+	cmd.Flags().StringVar(&opts.appName, "app", "", "the name of the application to promote")
+	cmd.Flags().StringVar(&opts.from, "from", "", "the environment to promote the application from")
+	cmd.Flags().StringVar(&opts.to, "to", "", "the environment to promote the application to")
+	cmd.Flags().BoolVar(&opts.replace, "replace", false, "when true, the overlay of the target environment is replaced with a copy of the source overlay, instead of merging them")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "when true, only show the diff, the changes are only committed in memory, and are not pushed")
+
+	cferrors.MustContext(ctx, cmd.MarkFlagRequired("app"))
+	cferrors.MustContext(ctx, cmd.MarkFlagRequired("from"))
+	cferrors.MustContext(ctx, cmd.MarkFlagRequired("to"))
+
+	return cmd
+}
+
+func validateOpts(opts *options) {
+	common.ValidateCommitOpts(&opts.CommitOptions)
+
+	if opts.from == opts.to {
+		panic("--from and --to must be different environments")
+	}
+}
+
+func promote(ctx context.Context, opts *options, w io.Writer) {
+	r, fs, cleanup := common.CloneRepo(ctx, &opts.RepoOptions, opts.dryRun)
+	defer cleanup()
+
+	changed, err := promoteApp(r, fs, opts)
+	cferrors.CheckErr(err)
+
+	if len(changed) == 0 {
+		log.G(ctx).Printf("app '%s' in '%s' is already up to date with '%s'", opts.appName, opts.to, opts.from)
+		return
+	}
+
+	persistPromotion(ctx, opts, r, fs, changed)
+
+	diff, err := r.Diff("HEAD~1", "")
+	cferrors.CheckErr(err)
+
+	for _, d := range diff {
+		_, err = fmt.Fprint(w, d.Patch)
+		cferrors.CheckErr(err)
+	}
+
+	if opts.dryRun {
+		log.G(ctx).Printf("dry run, the promotion was not pushed")
+		return
+	}
+
+	log.G(ctx).Printf("promoted app '%s' from '%s' to '%s'", opts.appName, opts.from, opts.to)
+}
+
+// promoteApp promotes the app in the config of fs, and returns the sorted paths
+// of the changed files
+func promoteApp(r git.Repository, fs billy.Filesystem, opts *options) ([]string, error) {
+	conf, err := envman.LoadConfig(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conf.PromoteApp(opts.appName, opts.from, opts.to, opts.replace); err != nil {
+		return nil, err
+	}
+
+	status, err := r.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0, len(status))
+	for _, s := range status {
+		changed = append(changed, s.Path)
+	}
+
+	return changed, nil
+}
+
+// persistPromotion commits and pushes the promotion. When the push is rejected,
+// the app is promoted again on top of the new commits, unless that changes
+// other files than the ones in the commit message.
+func persistPromotion(ctx context.Context, opts *options, r git.Repository, fs billy.Filesystem, changed []string) {
+	common.PersistRepo(ctx, r, &opts.RepoOptions, &opts.CommitOptions, commitMessage(opts, changed), opts.dryRun, func(ctx context.Context) error {
+		replayed, err := promoteApp(r, fs, opts)
+		if err != nil {
+			return err
+		}
+
+		if strings.Join(replayed, "\n") != strings.Join(changed, "\n") {
+			return fmt.Errorf("promoting app %s on top of the new commits in the gitops repository changes other files, run promote again", opts.appName)
+		}
+
+		return nil
+	})
+}
+
+// commitMessage returns the message of the promotion commit, with the changed
+// files in its body
+func commitMessage(opts *options, changed []string) string {
+	strategy := "merged"
+	if opts.replace {
+		strategy = "replaced"
+	}
+
+	return fmt.Sprintf("promoted app %s from %s to %s\n\nThe overlay of %s was %s with the overlay of %s, changed files:\n%s\n",
+		opts.appName, opts.from, opts.to, opts.to, strategy, opts.from, "* "+strings.Join(changed, "\n* "))
+}
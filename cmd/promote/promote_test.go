@@ -0,0 +1,164 @@
+package promote
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/cmd/common"
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/codefresh-io/cf-argo/test/utils/testrepo"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const fixture = "../../test/e2e/structures/uc3"
+
+func Test_validateOpts(t *testing.T) {
+	tests := map[string]struct {
+		opts  *options
+		panic string
+	}{
+		"Valid": {
+			opts: &options{appName: "guestbook", from: "staging", to: "production"},
+		},
+		"Same environment": {
+			opts:  &options{appName: "guestbook", from: "staging", to: "staging"},
+			panic: "--from and --to must be different environments",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			if tt.panic != "" {
+				assert.PanicsWithValue(t, tt.panic, func() { validateOpts(tt.opts) })
+				return
+			}
+
+			assert.NotPanics(t, func() { validateOpts(tt.opts) })
+		})
+	}
+}
+
+func Test_promote(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	repoURL, host := testrepo.New(ctx, t, fixture)
+
+	opts := &options{
+		RepoOptions: common.RepoOptions{
+			RepoURL:     repoURL,
+			GitProvider: "file",
+			GitHost:     host,
+		},
+		CommitOptions: common.CommitOptions{
+			AuthorName:  "test",
+			AuthorEmail: "test@example.com",
+		},
+		appName: "guestbook",
+		from:    "staging",
+		to:      "production",
+	}
+
+	w := &bytes.Buffer{}
+	promote(ctx, opts, w)
+	assert.Contains(t, w.String(), "+++ b/argocd-apps/production/guestbook.yaml\n")
+	assert.Contains(t, w.String(), "+++ b/kustomize/components/guestbook/overlays/production/replicas.yaml\n")
+	assert.Contains(t, w.String(), "+patchesStrategicMerge:\n+- replicas.yaml\n")
+
+	p, err := git.NewProvider(&git.Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+	r, err := p.CloneRepository(ctx, repoURL)
+	assert.NoError(t, err)
+	root, err := r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "kustomize/components/guestbook/overlays/production/replicas.yaml"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "replicas: 2")
+
+	// promoting again changes nothing
+	w.Reset()
+	promote(ctx, opts, w)
+	assert.Empty(t, w.String())
+}
+
+func Test_persistPromotion(t *testing.T) {
+	tests := map[string]struct {
+		// promotedMeanwhile when true, the commit that is pushed meanwhile
+		// promotes the app too, otherwise it only adds another file
+		promotedMeanwhile bool
+		wantPanic         string
+	}{
+		"Other file added meanwhile": {},
+		"Promoted meanwhile": {
+			promotedMeanwhile: true,
+			wantPanic:         "failed to replay changes: promoting app guestbook on top of the new commits in the gitops repository changes other files, run promote again",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			repoURL, host := testrepo.New(ctx, t, fixture)
+
+			opts := &options{
+				RepoOptions: common.RepoOptions{
+					RepoURL:     repoURL,
+					GitProvider: "file",
+					GitHost:     host,
+				},
+				CommitOptions: common.CommitOptions{
+					AuthorName:  "test",
+					AuthorEmail: "test@example.com",
+				},
+				appName: "guestbook",
+				from:    "staging",
+				to:      "production",
+			}
+
+			r, fs, cleanup := common.CloneRepo(ctx, &opts.RepoOptions, false)
+			defer cleanup()
+			changed, err := promoteApp(r, fs, opts)
+			assert.NoError(t, err)
+
+			other, otherFS, cleanupOther := common.CloneRepo(ctx, &opts.RepoOptions, false)
+			defer cleanupOther()
+			if tt.promotedMeanwhile {
+				_, err = promoteApp(other, otherFS, opts)
+				assert.NoError(t, err)
+			} else {
+				assert.NoError(t, util.WriteFile(otherFS, "other", []byte("other"), 0644))
+			}
+			common.PersistRepo(ctx, other, &opts.RepoOptions, &opts.CommitOptions, "other", false, nil)
+
+			if tt.wantPanic != "" {
+				assert.PanicsWithError(t, tt.wantPanic, func() { persistPromotion(ctx, opts, r, fs, changed) })
+				return
+			}
+
+			persistPromotion(ctx, opts, r, fs, changed)
+
+			_, fs, _ = common.CloneRepo(ctx, &opts.RepoOptions, true)
+			data, err := util.ReadFile(fs, "other")
+			assert.NoError(t, err)
+			assert.Equal(t, "other", string(data))
+			data, err = util.ReadFile(fs, "kustomize/components/guestbook/overlays/production/replicas.yaml")
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), "replicas: 2")
+		})
+	}
+}
+
+func Test_commitMessage(t *testing.T) {
+	got := commitMessage(&options{appName: "guestbook", from: "staging", to: "production"}, []string{"a.yaml", "b.yaml"})
+	assert.Equal(t, `promoted app guestbook from staging to production
+
+The overlay of production was merged with the overlay of staging, changed files:
+* a.yaml
+* b.yaml
+`, got)
+}
@@ -3,7 +3,10 @@ package root
 import (
 	"context"
 
+	"github.com/codefresh-io/cf-argo/cmd/app"
+	"github.com/codefresh-io/cf-argo/cmd/env"
 	"github.com/codefresh-io/cf-argo/cmd/install"
+	"github.com/codefresh-io/cf-argo/cmd/promote"
 	"github.com/codefresh-io/cf-argo/cmd/uninstall"
 	"github.com/codefresh-io/cf-argo/cmd/version"
 	"github.com/codefresh-io/cf-argo/pkg/store"
@@ -26,6 +29,9 @@ func New(ctx context.Context) *cobra.Command {
 	cmd.AddCommand(version.New(ctx))
 	cmd.AddCommand(install.New(ctx))
 	cmd.AddCommand(uninstall.New(ctx))
+	cmd.AddCommand(env.New(ctx))
+	cmd.AddCommand(app.New(ctx))
+	cmd.AddCommand(promote.New(ctx))
 
 	return cmd
 }
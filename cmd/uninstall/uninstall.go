@@ -3,11 +3,11 @@ package uninstall
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/codefresh-io/cf-argo/cmd/common"
 	envman "github.com/codefresh-io/cf-argo/pkg/environments-manager"
 	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -18,22 +18,33 @@ import (
 	"github.com/codefresh-io/cf-argo/pkg/kube"
 	"github.com/codefresh-io/cf-argo/pkg/log"
 	"github.com/codefresh-io/cf-argo/pkg/store"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
+const mergePollInterval = time.Second * 10
+
 type options struct {
-	repoURL  string
-	envName  string
-	gitToken string
-	dryRun   bool
+	common.RepoOptions
+	common.CommitOptions
+	envName      string
+	pullRequest  bool
+	waitForMerge bool
+	mergeTimeout time.Duration
+	dryRun       bool
 }
 
 var values struct {
+	// GitopsRepoClonePath the temp dir of GitopsRepoFS, empty in dry-run, when
+	// the gitops repository is kept in memory
 	GitopsRepoClonePath string
+	GitopsRepoFS        billy.Filesystem
 	GitopsRepo          git.Repository
 	CommitRev           string
+	BaseBranch          string
 }
 
 var renderValues struct {
@@ -51,6 +62,8 @@ func New(ctx context.Context) *cobra.Command {
 		Short: "Uninstalls an Argo Enterprise solution from a specified cluster and installation",
 		Long:  "This command will clear all Argo-CD managed resources relating to a specific installation, from a specific cluster",
 		Run: func(cmd *cobra.Command, args []string) {
+			common.ValidateRepoOpts(ctx, &opts.RepoOptions)
+			validateOpts(&opts)
 			fillValues(&opts)
 			uninstall(ctx, &opts)
 		},
@@ -59,22 +72,33 @@ func New(ctx context.Context) *cobra.Command {
 	// add kubernetes flags
 	store.Get().KubeConfig.AddFlagSet(cmd)
 
-	_ = viper.BindEnv("repo-url", "REPO_URL")
 	_ = viper.BindEnv("env-name", "ENV_NAME")
-	_ = viper.BindEnv("git-token", "GIT_TOKEN")
+	_ = viper.BindEnv("pull-request", "PULL_REQUEST")
+	_ = viper.BindEnv("wait-for-merge", "WAIT_FOR_MERGE")
+	_ = viper.BindEnv("merge-timeout", "MERGE_TIMEOUT")
 	viper.SetDefault("dry-run", false)
-
-	cmd.Flags().StringVar(&opts.repoURL, "repo-url", viper.GetString("repo-url"), "the gitops repository url. If it does not exist we will try to create it for you [REPO_URL]")
-	cmd.Flags().StringVar(&opts.envName, "env-name", viper.GetString("env-name"), "name of the Argo Enterprise environment to create")
-	cmd.Flags().StringVar(&opts.gitToken, "git-token", viper.GetString("git-token"), "git token which will be used by argo-cd to create the gitops repository")
+	viper.SetDefault("merge-timeout", time.Hour)
+
+	common.AddRepoFlags(ctx, cmd, &opts.RepoOptions)
+	common.AddCommitFlags(cmd, &opts.CommitOptions)
+	cmd.Flags().StringVar(&opts.envName, "env-name", viper.GetString("env-name"), "name of the Argo Enterprise environment to uninstall [ENV_NAME]")
+	cmd.Flags().BoolVar(&opts.pullRequest, "pull-request", viper.GetBool("pull-request"), "when true, the changes are pushed to a new branch and a pull request is opened, instead of pushing to the default branch [PULL_REQUEST]")
+	cmd.Flags().BoolVar(&opts.waitForMerge, "wait-for-merge", viper.GetBool("wait-for-merge"), "when true, wait for the pull request to be merged and continue with removing argo-cd [WAIT_FOR_MERGE]")
+	cmd.Flags().DurationVar(&opts.mergeTimeout, "merge-timeout", viper.GetDuration("merge-timeout"), "how long to wait for the pull request to be merged [MERGE_TIMEOUT]")
 	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", viper.GetBool("dry-run"), "when true, the command will have no side effects, and will only output the manifests to stdout")
 
-	cferrors.MustContext(ctx, cmd.MarkFlagRequired("repo-url"))
 	cferrors.MustContext(ctx, cmd.MarkFlagRequired("env-name"))
 
 	return cmd
 }
 
+func validateOpts(opts *options) {
+	if opts.waitForMerge && !opts.pullRequest {
+		panic("--wait-for-merge requires --pull-request")
+	}
+	common.ValidateCommitOpts(&opts.CommitOptions)
+}
+
 func fillValues(opts *options) {
 	var err error
 	cferrors.CheckErr(err)
@@ -90,20 +114,24 @@ func uninstall(ctx context.Context, opts *options) {
 		}
 	}()
 
+	if !opts.dryRun {
+		validateGitAccess(ctx, opts)
+	}
+
 	cloneExistingRepo(ctx, opts)
 
-	conf, err := envman.LoadConfig(values.GitopsRepoClonePath)
+	conf, env, shouldClean, err := uninstallEnvironment(opts)
 	cferrors.CheckErr(err)
 
-	env, exists := conf.Environments[opts.envName]
-	if !exists {
-		panic(envman.ErrEnvironmentNotExist)
-	}
-
-	shouldClean, err := env.Uninstall()
-	cferrors.CheckErr(err)
+	persistGitopsRepo(ctx, opts, fmt.Sprintf("uninstalled environment %s", opts.envName), func(ctx context.Context) error {
+		conf, env, shouldClean, err = uninstallEnvironment(opts)
+		return err
+	})
 
-	persistGitopsRepo(ctx, opts, fmt.Sprintf("uninstalled environment %s", opts.envName))
+	if opts.pullRequest && !opts.waitForMerge {
+		log.G(ctx).Printf("argo-cd will remove the managed resources in '%s' once the pull request is merged", opts.envName)
+		return
+	}
 
 	if shouldClean {
 		rootApp, err := env.GetRootApp()
@@ -118,7 +146,14 @@ func uninstall(ctx context.Context, opts *options) {
 		log.G(ctx).Printf("cleaning up the repository")
 		cferrors.CheckErr(conf.DeleteEnvironmentP(ctx, opts.envName, renderValues, opts.dryRun))
 
-		persistGitopsRepo(ctx, opts, fmt.Sprintf("cleanup %s resources", opts.envName))
+		persistGitopsRepo(ctx, opts, fmt.Sprintf("cleanup %s resources", opts.envName), func(ctx context.Context) error {
+			conf, err := envman.LoadConfig(values.GitopsRepoFS)
+			if err != nil {
+				return err
+			}
+
+			return conf.DeleteEnvironmentP(ctx, opts.envName, renderValues, opts.dryRun)
+		})
 
 		log.G(ctx).Printf("all managed resources in '%s' have been removed, including argo-cd", opts.envName)
 	} else {
@@ -126,40 +161,114 @@ func uninstall(ctx context.Context, opts *options) {
 	}
 }
 
+// uninstallEnvironment loads the config of the gitops repository, and removes
+// the managed applications of the environment. It returns true if argo-cd
+// should be removed too.
+func uninstallEnvironment(opts *options) (*envman.Config, *envman.Environment, bool, error) {
+	conf, err := envman.LoadConfig(values.GitopsRepoFS)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	env, exists := conf.Environments[opts.envName]
+	if !exists {
+		return nil, nil, false, envman.ErrEnvironmentNotExist
+	}
+
+	shouldClean, err := env.Uninstall()
+	return conf, env, shouldClean, err
+}
+
+// validateGitAccess checks that the git token can push to the gitops repository,
+// before any change is made to the cluster
+func validateGitAccess(ctx context.Context, opts *options) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
+	cferrors.CheckErr(err)
+
+	ref, err := git.ParseRef(opts.RepoURL)
+	cferrors.CheckErr(err)
+	cferrors.CheckErr(p.ValidateAccess(ctx, &git.ValidateAccessOptions{
+		RepoURL: ref.URL,
+	}))
+}
+
 func cloneExistingRepo(ctx context.Context, opts *options) {
-	p, err := git.NewProvider(&git.Options{
-		Type: "github", // only option for now
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
-	})
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
 	cferrors.CheckErr(err)
 
-	values.GitopsRepo, err = p.CloneRepository(ctx, opts.repoURL)
+	values.GitopsRepo, err = p.CloneRepository(ctx, opts.RepoURL)
 	cferrors.CheckErr(err)
 
-	values.GitopsRepoClonePath, err = values.GitopsRepo.Root()
+	values.GitopsRepoFS, err = values.GitopsRepo.Filesystem()
 	cferrors.CheckErr(err)
+
+	if !opts.dryRun {
+		values.GitopsRepoClonePath, err = values.GitopsRepo.Root()
+		cferrors.CheckErr(err)
+	}
 }
 
-func persistGitopsRepo(ctx context.Context, opts *options, msg string) {
+// persistGitopsRepo commits and pushes the changes, to a new branch with
+// --pull-request. When the push is rejected since the branch has new commits,
+// replay applies the changes again on top of them.
+func persistGitopsRepo(ctx context.Context, opts *options, msg string, replay func(ctx context.Context) error) {
 	var err error
-	cferrors.CheckErr(values.GitopsRepo.Add(ctx, "."))
+	head := ""
+	if opts.pullRequest {
+		if values.BaseBranch == "" {
+			// the following pull requests branch from the previous one, but are
+			// still merged into the original branch
+			values.BaseBranch, err = values.GitopsRepo.CurrentBranch()
+			cferrors.CheckErr(err)
+		}
+
+		head = fmt.Sprintf("cf-argo/uninstall-%s-%s", opts.envName, time.Now().Format("20060102-150405"))
+		cferrors.CheckErr(values.GitopsRepo.CreateBranch(ctx, head))
+
+		// nobody else is pushing to the new branch
+		replay = nil
+	}
+
+	values.CommitRev = common.PersistRepo(ctx, values.GitopsRepo, &opts.RepoOptions, &opts.CommitOptions, msg, opts.dryRun, replay)
 
-	values.CommitRev, err = values.GitopsRepo.Commit(ctx, msg)
+	if opts.pullRequest && !opts.dryRun {
+		createPullRequest(ctx, opts, values.BaseBranch, head, msg)
+	}
+}
+
+// createPullRequest opens a pull request from head into base, and waits for it
+// to be merged when required. Once merged, argo-cd syncs to the merge commit.
+func createPullRequest(ctx context.Context, opts *options, base, head, title string) {
+	p, err := git.NewProvider(opts.GitOptions(opts.dryRun))
 	cferrors.CheckErr(err)
 
-	if opts.dryRun {
+	pr, err := p.CreatePullRequest(ctx, &git.PullRequestOptions{
+		RepoURL:     opts.RepoURL,
+		Head:        head,
+		Base:        base,
+		Title:       title,
+		Description: fmt.Sprintf("Uninstalls the Argo Enterprise environment %s", opts.envName),
+	})
+	cferrors.CheckErr(err)
+
+	log.G(ctx).Printf("created pull request: %s", pr.URL)
+	if !opts.waitForMerge {
 		return
 	}
 
-	log.G(ctx).Printf("pushing to gitops repo...")
-	err = values.GitopsRepo.Push(ctx, &git.PushOptions{
-		Auth: &git.Auth{
-			Password: opts.gitToken,
-		},
-	})
+	log.G(ctx).Printf("waiting for the pull request to be merged...")
+	ctx, cancel := context.WithTimeout(ctx, opts.mergeTimeout)
+	defer cancel()
+
+	pr, err = git.WaitForMerge(ctx, p, &git.GetPullRequestOptions{
+		RepoURL: opts.RepoURL,
+		ID:      pr.ID,
+	}, mergePollInterval)
 	cferrors.CheckErr(err)
+
+	if pr.MergeCommit != "" {
+		values.CommitRev = pr.MergeCommit
+	}
 }
 
 func awaitSync(ctx context.Context, opts *options, app *envman.Application) {
@@ -173,10 +282,10 @@ func awaitSync(ctx context.Context, opts *options, app *envman.Application) {
 }
 
 func deleteArgocdApp(ctx context.Context, opts *options, app *envman.Application) {
-	projData, err := ioutil.ReadFile(filepath.Join(filepath.Dir(app.Path), fmt.Sprintf("%s-project.yaml", opts.envName)))
+	projData, err := util.ReadFile(values.GitopsRepoFS, filepath.Join(filepath.Dir(app.Path), fmt.Sprintf("%s-project.yaml", opts.envName)))
 	cferrors.CheckErr(err)
 
-	appData, err := ioutil.ReadFile(app.Path)
+	appData, err := util.ReadFile(values.GitopsRepoFS, app.Path)
 	cferrors.CheckErr(err)
 
 	manifests := []byte(fmt.Sprintf("%s\n\n---\n%s", string(projData), string(appData)))
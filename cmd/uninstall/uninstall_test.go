@@ -1,18 +1,24 @@
 package uninstall
 
 import (
+	"context"
+	"os"
 	"testing"
 
+	"github.com/codefresh-io/cf-argo/cmd/common"
 	"github.com/codefresh-io/cf-argo/pkg/git"
 	mockGit "github.com/codefresh-io/cf-argo/pkg/git/mocks"
 	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/codefresh-io/cf-argo/test/utils/testrepo"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 func newMockRepo() *mockGit.Repository {
 	mockRepo := new(mockGit.Repository)
 	mockRepo.On("Add", mock.AnythingOfType("*context.valueCtx"), mock.AnythingOfType("string")).Return(nil)
-	mockRepo.On("Commit", mock.AnythingOfType("*context.valueCtx"), mock.AnythingOfType("string")).Return("hash", nil)
+	mockRepo.On("Commit", mock.AnythingOfType("*context.valueCtx"), mock.AnythingOfType("*git.CommitOptions")).Return("hash", nil)
 	mockRepo.On("Push", mock.AnythingOfType("*context.valueCtx"), mock.AnythingOfType("*git.PushOptions")).Return(nil)
 	return mockRepo
 }
@@ -25,11 +31,11 @@ func Test_persistGitopsRepo(t *testing.T) {
 	msg, gitToken := "some message", "some token"
 
 	persistGitopsRepo(ctx, &options{
-		gitToken: gitToken,
-	}, msg)
+		RepoOptions: common.RepoOptions{GitToken: gitToken},
+	}, msg, nil)
 
 	mockRepo.AssertCalled(t, "Add", ctx, ".")
-	mockRepo.AssertCalled(t, "Commit", ctx, msg)
+	mockRepo.AssertCalled(t, "Commit", ctx, &git.CommitOptions{Message: msg})
 	mockRepo.AssertCalled(t, "Push", ctx, &git.PushOptions{
 		Auth: &git.Auth{
 			Password: gitToken,
@@ -45,9 +51,68 @@ func Test_persistGitopsRepo_dryRun(t *testing.T) {
 
 	persistGitopsRepo(ctx, &options{
 		dryRun: true,
-	}, msg)
+	}, msg, nil)
 
 	mockRepo.AssertCalled(t, "Add", ctx, ".")
-	mockRepo.AssertCalled(t, "Commit", ctx, msg)
+	mockRepo.AssertCalled(t, "Commit", ctx, &git.CommitOptions{Message: msg})
 	mockRepo.AssertNotCalled(t, "Push")
 }
+
+func Test_persistGitopsRepo_fileRepo(t *testing.T) {
+	tests := map[string]struct {
+		// pushedMeanwhile when true, another commit is pushed after the repository
+		// was cloned, so the changes are replayed on top of it
+		pushedMeanwhile bool
+		wantFiles       []string
+	}{
+		"Pushed": {},
+		"Pushed meanwhile": {
+			pushedMeanwhile: true,
+			wantFiles:       []string{"other"},
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			repoURL, _ := testrepo.New(ctx, t, "../../test/e2e/structures/uc3")
+
+			opts := &options{
+				RepoOptions: common.RepoOptions{
+					RepoURL:     repoURL,
+					GitProvider: "file",
+				},
+				CommitOptions: common.CommitOptions{
+					AuthorName:  "cf-argo",
+					AuthorEmail: "cf-argo@example.com",
+				},
+			}
+			cloneExistingRepo(ctx, opts)
+			defer cleanup(ctx)
+			assert.NoError(t, values.GitopsRepoFS.Remove("argo-installer.yaml"))
+
+			if tt.pushedMeanwhile {
+				other, fs, cleanupOther := common.CloneRepo(ctx, &opts.RepoOptions, false)
+				defer cleanupOther()
+				assert.NoError(t, util.WriteFile(fs, "other", []byte("other"), 0644))
+				common.PersistRepo(ctx, other, &opts.RepoOptions, &opts.CommitOptions, "other", false, nil)
+			}
+
+			persistGitopsRepo(ctx, opts, "some message", func(ctx context.Context) error {
+				return values.GitopsRepoFS.Remove("argo-installer.yaml")
+			})
+
+			r, fs, _ := common.CloneRepo(ctx, &opts.RepoOptions, true)
+			_, err := fs.Stat("argo-installer.yaml")
+			assert.True(t, os.IsNotExist(err))
+			for _, f := range tt.wantFiles {
+				_, err = fs.Stat(f)
+				assert.NoError(t, err)
+			}
+
+			commits, err := r.Log("")
+			assert.NoError(t, err)
+			assert.Equal(t, commits[0].SHA, values.CommitRev)
+		})
+	}
+}
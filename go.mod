@@ -3,20 +3,21 @@ module github.com/codefresh-io/cf-argo
 go 1.15
 
 require (
+	github.com/ProtonMail/go-crypto v0.0.0-20210428141323-04723f9f07d7
 	github.com/argoproj/argo-cd v1.8.4
 	github.com/bitnami-labs/sealed-secrets v0.14.1
 	github.com/ghodss/yaml v1.0.0
-	github.com/go-git/go-git v4.7.0+incompatible
-	github.com/go-git/go-git/v5 v5.2.0
+	github.com/go-git/go-billy/v5 v5.3.1
+	github.com/go-git/go-git/v5 v5.4.2
 	github.com/google/go-github/v32 v32.1.0
 	github.com/rhysd/go-fakeio v1.0.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.1.1
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.6.1
-	github.com/yargevad/filepathx v0.0.0-20161019152617-907099cb5a62
-	golang.org/x/net v0.0.0-20210119194325-5f4716e94777 // indirect
+	github.com/stretchr/testify v1.7.0
+	github.com/xanzy/go-gitlab v0.43.0
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
 	golang.org/x/text v0.3.5 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776 // indirect
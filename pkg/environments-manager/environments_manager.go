@@ -4,21 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/codefresh-io/cf-argo/pkg/git"
 	"github.com/codefresh-io/cf-argo/pkg/helpers"
 	"github.com/codefresh-io/cf-argo/pkg/kube"
 	"github.com/codefresh-io/cf-argo/pkg/store"
 	"github.com/ghodss/yaml"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	kustomize "sigs.k8s.io/kustomize/api/types"
 )
 
@@ -27,6 +31,12 @@ var (
 	ErrEnvironmentAlreadyExists = errors.New("environment already exists")
 	ErrEnvironmentNotExist      = errors.New("environment does not exist")
 	ErrAppNotFound              = errors.New("app not found")
+	ErrAppAlreadyExists         = errors.New("app already exists")
+	ErrRootApp                  = errors.New("cannot remove the root application of an environment")
+
+	// errNoBase the application kustomization has no resources, e.g. it only
+	// has a helm chart generator
+	errNoBase = errors.New("the kustomization has no base")
 
 	ConfigFileName = fmt.Sprintf("%s.yaml", store.AppName)
 
@@ -38,11 +48,15 @@ const (
 	labelsManagedBy = "app.kubernetes.io/managed-by"
 	labelsName      = "app.kubernetes.io/name"
 	bootstrapDir    = "bootstrap"
+	componentsDir   = "kustomize/components"
+	// resourcesFinalizer makes argo-cd delete the resources of an application
+	// when the application is deleted
+	resourcesFinalizer = "resources-finalizer.argocd.argoproj.io"
 )
 
 type (
 	Config struct {
-		path         string                  // the path from which the config was loaded
+		fs           billy.Filesystem        // the filesystem of the repository that contains the config
 		Version      string                  `json:"version"`
 		Environments map[string]*Environment `json:"environments"`
 	}
@@ -56,16 +70,42 @@ type (
 
 	Application struct {
 		*v1alpha1.Application
-		// Path the path from where the application manifest was read from
+		// Path the path from where the application manifest was read from,
+		// relative to the root of the config filesystem
 		Path string
 		// env the environment that contains this application
 		env *Environment
 	}
+
+	// AddAppOptions the application to add to an environment. When the
+	// application has no kustomize base in the repository, exactly one of
+	// Manifests, KustomizeURL and HelmChart is used to create it.
+	AddAppOptions struct {
+		// Name the name of the application, used for its kustomize component
+		// and its app.kubernetes.io/name label
+		Name string
+		// Namespace the destination namespace of the application
+		Namespace string
+		// Manifests local manifests by file name, added as resources of the base
+		Manifests map[string][]byte
+		// KustomizeURL a remote kustomize base, added as a resource of the base
+		KustomizeURL string
+		// HelmChart a helm chart, inflated by the base
+		HelmChart *kustomize.HelmChartArgs
+	}
+
+	// AppNode an application in the app-of-apps tree of an environment
+	AppNode struct {
+		*Application
+		// Children the applications in the source path of the application
+		Children []*AppNode
+	}
 )
 
-func NewConfig(path string) *Config {
+// NewConfig returns a new config of the repository on fs
+func NewConfig(fs billy.Filesystem) *Config {
 	return &Config{
-		path:         path,
+		fs:           fs,
 		Version:      configVersion,
 		Environments: make(map[string]*Environment),
 	}
@@ -78,34 +118,25 @@ func (c *Config) Persist() error {
 		return err
 	}
 
-	return ioutil.WriteFile(filepath.Join(c.path, ConfigFileName), data, 0644)
+	return util.WriteFile(c.fs, ConfigFileName, data, 0644)
 }
 
 // AddEnvironmentP adds a new environment, copies all of the argocd apps to the relative
-// location in the repository that c is managing, and persists the config object
+// location in the repository that c is managing, persists the config object, and
+// applies the bootstrap resources
 func (c *Config) AddEnvironmentP(ctx context.Context, env *Environment, values interface{}, dryRun bool) error {
-	if _, exists := c.Environments[env.name]; exists {
-		return fmt.Errorf("%w: %s", ErrEnvironmentAlreadyExists, env.name)
-	}
-
-	// copy all of the argocd apps to the correct location in the destination repo
-	newEnv, err := c.installEnv(env)
-	if err != nil {
-		return err
-	}
-
-	c.Environments[env.name] = newEnv
-	if err = c.Persist(); err != nil {
+	if err := c.AddEnvironment(env); err != nil {
 		return err
 	}
 
+	newEnv := c.Environments[env.name]
 	cs, err := store.Get().NewKubeClient(ctx).KubernetesClientSet()
 	if err != nil {
 		return err
 	}
 
 	_, err = cs.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-argocd", env.name)},
+		ObjectMeta: metav1.ObjectMeta{Name: env.Namespace()},
 	}, metav1.CreateOptions{})
 	if err != nil {
 		if !kerrors.IsAlreadyExists(err) {
@@ -124,6 +155,24 @@ func (c *Config) AddEnvironmentP(ctx context.Context, env *Environment, values i
 	})
 }
 
+// AddEnvironment adds a new environment, copies all of the argocd apps to the relative
+// location in the repository that c is managing, and persists the config object,
+// without applying anything to the cluster
+func (c *Config) AddEnvironment(env *Environment) error {
+	if _, exists := c.Environments[env.name]; exists {
+		return fmt.Errorf("%w: %s", ErrEnvironmentAlreadyExists, env.name)
+	}
+
+	// copy all of the argocd apps to the correct location in the destination repo
+	newEnv, err := c.installEnv(env)
+	if err != nil {
+		return err
+	}
+
+	c.Environments[env.name] = newEnv
+	return c.Persist()
+}
+
 // DeleteEnvironmentP deletes an environment and persists the config object
 func (c *Config) DeleteEnvironmentP(ctx context.Context, name string, values interface{}, dryRun bool) error {
 	env, exists := c.Environments[name]
@@ -159,18 +208,18 @@ func (c *Config) FirstEnv() *Environment {
 	return nil
 }
 
-// LoadConfig loads the config from the specified path
-func LoadConfig(path string) (*Config, error) {
-	data, err := ioutil.ReadFile(filepath.Join(path, ConfigFileName))
+// LoadConfig loads the config from the root of fs
+func LoadConfig(fs billy.Filesystem) (*Config, error) {
+	data, err := util.ReadFile(fs, ConfigFileName)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("config file does not exist: %s", path)
+			return nil, fmt.Errorf("config file does not exist: %s", fs.Root())
 		}
 		return nil, err
 	}
 
 	c := new(Config)
-	c.path = path
+	c.fs = fs
 	if err = yaml.Unmarshal(data, c); err != nil {
 		return nil, err
 	}
@@ -196,14 +245,14 @@ func (c *Config) installEnv(env *Environment) (*Environment, error) {
 	}
 	for _, la := range lapps {
 		if la.isManaged() {
-			if err = newEnv.installApp(env.c.path, la); err != nil {
+			if err = newEnv.installApp(env.c.fs, la); err != nil {
 				return nil, err
 			}
 		}
 	}
 
 	// copy the tpl "argocd-apps" to the matching dir in the dst repo
-	src := filepath.Join(env.c.path, filepath.Dir(env.RootApplicationPath))
+	src := filepath.Dir(env.RootApplicationPath)
 	var dstApplicationPath string
 	if len(c.Environments) == 0 {
 		dstApplicationPath = newEnv.RootApplicationPath
@@ -211,8 +260,8 @@ func (c *Config) installEnv(env *Environment) (*Environment, error) {
 		dstApplicationPath = c.FirstEnv().RootApplicationPath
 	}
 
-	dst := filepath.Join(c.path, filepath.Dir(dstApplicationPath))
-	err = helpers.CopyDir(src, dst)
+	dst := filepath.Dir(dstApplicationPath)
+	err = helpers.CopyDir(env.c.fs, src, c.fs, dst)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +269,76 @@ func (c *Config) installEnv(env *Environment) (*Environment, error) {
 	return newEnv, nil
 }
 
+// PromoteApp promotes the overlay of the application appName from the
+// environment from to the environment to. The application is added to the
+// environment to if it does not have it yet. By default the overlays are
+// merged: the files of the source overlay overwrite the files of the target
+// overlay, and the images, resources and patches of the source kustomization
+// are merged into the target kustomization, which keeps the rest of its fields.
+// When replace is true, the target overlay is replaced with a copy of the
+// source overlay.
+func (c *Config) PromoteApp(appName, from, to string, replace bool) error {
+	fromEnv, exists := c.Environments[from]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotExist, from)
+	}
+
+	toEnv, exists := c.Environments[to]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotExist, to)
+	}
+
+	srcApp, err := fromEnv.GetApp(appName)
+	if err != nil {
+		return err
+	}
+
+	dstApp, err := toEnv.GetApp(appName)
+	if err != nil {
+		if !errors.Is(err, ErrAppNotFound) {
+			return err
+		}
+
+		dstApp, err = toEnv.AddApp(&AddAppOptions{
+			Name:      appName,
+			Namespace: srcApp.Spec.Destination.Namespace,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	src, dst := srcApp.srcPath(), dstApp.srcPath()
+	if src == dst {
+		return fmt.Errorf("app %s has the same source path in %s and %s: %s", appName, from, to, src)
+	}
+
+	if replace {
+		if err = util.RemoveAll(c.fs, dst); err != nil {
+			return err
+		}
+
+		return helpers.CopyDir(c.fs, src, c.fs, dst)
+	}
+
+	dstKust, err := readKustomization(c.fs, dst)
+	if err != nil {
+		return err
+	}
+
+	if err = helpers.CopyDir(c.fs, src, c.fs, dst); err != nil {
+		return err
+	}
+
+	srcKust, err := readKustomization(c.fs, src)
+	if err != nil {
+		return err
+	}
+
+	mergeKustomization(dstKust, srcKust)
+	return writeKustomization(c.fs, dst, dstKust)
+}
+
 func (c *Config) getApp(appName string) (*Application, error) {
 	err := ErrAppNotFound
 	var app *Application
@@ -238,25 +357,59 @@ func (c *Config) getApp(appName string) (*Application, error) {
 	return app, err
 }
 
+// Name returns the name of the environment
+func (e *Environment) Name() string {
+	return e.name
+}
+
+// Namespace returns the namespace argo-cd of the environment is installed in
+func (e *Environment) Namespace() string {
+	return fmt.Sprintf("%s-argocd", e.name)
+}
+
+// ManagedApps returns the leaf applications of the environment that are
+// managed by cf-argo
+func (e *Environment) ManagedApps() ([]*Application, error) {
+	lapps, err := e.leafApps()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []*Application{}
+	for _, la := range lapps {
+		if la.isManaged() {
+			res = append(res, la)
+		}
+	}
+
+	return res, nil
+}
+
 func (e *Environment) UpdateTemplateRef(templateRef string) {
 	e.TemplateRef = templateRef
 }
 
-func (e *Environment) bootstrapUrl() string {
-	var parts []string
+// UpdateTargetRevision sets the target revision of all the applications in the
+// environment that are synced from repoURL
+func (e *Environment) UpdateTargetRevision(repoURL, revision string) error {
+	rootApp, err := e.GetRootApp()
+	if err != nil {
+		return err
+	}
 
-	switch {
-	case strings.Contains(e.TemplateRef, "#"):
-		parts = strings.Split(e.TemplateRef, "#")
-	case strings.Contains(e.TemplateRef, "@"):
-		parts = strings.Split(e.TemplateRef, "@")
-	default:
-		parts = []string{e.TemplateRef}
+	return rootApp.updateTargetRevision(repoURL, revision)
+}
+
+func (e *Environment) bootstrapUrl() string {
+	ref, err := git.ParseRef(e.TemplateRef)
+	if err != nil {
+		// let kustomize report the invalid url
+		return fmt.Sprintf("%s/%s", e.TemplateRef, bootstrapDir)
 	}
 
-	bootstrapUrl := fmt.Sprintf("%s/%s", parts[0], bootstrapDir)
-	if len(parts) > 1 {
-		return fmt.Sprintf("%s?ref=%s", bootstrapUrl, parts[1])
+	bootstrapUrl := fmt.Sprintf("%s/%s", ref.URL, bootstrapDir)
+	if ref.Name != "" {
+		return fmt.Sprintf("%s?ref=%s", bootstrapUrl, ref.Name)
 	}
 
 	return bootstrapUrl
@@ -271,7 +424,7 @@ func (e *Environment) cleanup() error {
 	return rootApp.deleteFromFilesystem()
 }
 
-func (e *Environment) installApp(srcRootPath string, app *Application) error {
+func (e *Environment) installApp(srcFS billy.Filesystem, app *Application) error {
 	appName := app.labelName()
 	refApp, err := e.c.getApp(appName)
 	if err != nil {
@@ -279,7 +432,7 @@ func (e *Environment) installApp(srcRootPath string, app *Application) error {
 			return err
 		}
 
-		return e.installNewApp(srcRootPath, app)
+		return e.installNewApp(srcFS, app)
 	}
 
 	baseLocation, err := refApp.getBaseLocation()
@@ -287,12 +440,8 @@ func (e *Environment) installApp(srcRootPath string, app *Application) error {
 		return err
 	}
 
-	absSrc := filepath.Join(srcRootPath, app.srcPath())
-
 	dst := filepath.Clean(filepath.Join(baseLocation, "..", "overlays", e.name))
-	absDst := filepath.Join(e.c.path, dst)
-
-	err = helpers.CopyDir(absSrc, absDst)
+	err = helpers.CopyDir(srcFS, app.srcPath(), e.c.fs, dst)
 	if err != nil {
 		return err
 	}
@@ -301,12 +450,346 @@ func (e *Environment) installApp(srcRootPath string, app *Application) error {
 	return app.save()
 }
 
-func (e *Environment) installNewApp(srcRootPath string, app *Application) error {
+func (e *Environment) installNewApp(srcFS billy.Filesystem, app *Application) error {
 	appFolder := filepath.Clean(filepath.Join(app.srcPath(), "..", ".."))
-	absSrc := filepath.Join(srcRootPath, appFolder)
-	absDst := filepath.Join(e.c.path, appFolder)
 
-	return helpers.CopyDir(absSrc, absDst)
+	return helpers.CopyDir(srcFS, appFolder, e.c.fs, appFolder)
+}
+
+// AddApp adds a managed application to the environment. It creates the
+// kustomize base of the application, if no other environment has it, and the
+// overlay of the environment, and writes the application manifest next to the
+// other applications of the environment. The application inherits the project,
+// repository, revision, sync policy and destination server of the root
+// application, and its resources are deleted with it.
+func (e *Environment) AddApp(opts *AddAppOptions) (*Application, error) {
+	_, err := e.GetApp(opts.Name)
+	if err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAppAlreadyExists, opts.Name)
+	}
+	if !errors.Is(err, ErrAppNotFound) {
+		return nil, err
+	}
+
+	rootApp, err := e.GetRootApp()
+	if err != nil {
+		return nil, err
+	}
+
+	appPath := filepath.Join(rootApp.srcPath(), fmt.Sprintf("%s.yaml", opts.Name))
+	if _, err = e.c.fs.Stat(appPath); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAppAlreadyExists, appPath)
+	}
+
+	fs := e.c.fs
+	baseDir, err := e.c.getAppBase(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseDir == "" {
+		baseDir = filepath.Join(componentsDir, opts.Name, "base")
+	}
+
+	if _, err = fs.Stat(baseDir); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err = createBase(fs, baseDir, opts); err != nil {
+			return nil, err
+		}
+	} else if len(opts.Manifests) > 0 || opts.KustomizeURL != "" || opts.HelmChart != nil {
+		return nil, fmt.Errorf("app %s already has a kustomize base: %s", opts.Name, baseDir)
+	}
+
+	overlayDir := filepath.Clean(filepath.Join(baseDir, "..", "overlays", e.name))
+	relBaseDir, err := filepath.Rel(overlayDir, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeKustomization(fs, overlayDir, &kustomize.Kustomization{
+		Resources: []string{relBaseDir},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app := &Application{
+		Application: &v1alpha1.Application{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "argoproj.io/v1alpha1",
+				Kind:       "Application",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%s", e.name, opts.Name),
+				Namespace: rootApp.Namespace,
+				Labels: map[string]string{
+					labelsManagedBy: store.AppName,
+					labelsName:      opts.Name,
+				},
+				Finalizers: []string{resourcesFinalizer},
+			},
+			Spec: v1alpha1.ApplicationSpec{
+				Project: rootApp.Spec.Project,
+				Source: v1alpha1.ApplicationSource{
+					RepoURL:        rootApp.Spec.Source.RepoURL,
+					TargetRevision: rootApp.Spec.Source.TargetRevision,
+					Path:           overlayDir,
+				},
+				Destination: v1alpha1.ApplicationDestination{
+					Server:    rootApp.Spec.Destination.Server,
+					Name:      rootApp.Spec.Destination.Name,
+					Namespace: opts.Namespace,
+				},
+				SyncPolicy: rootApp.Spec.SyncPolicy,
+			},
+		},
+		Path: appPath,
+		env:  e,
+	}
+
+	return app, app.save()
+}
+
+// RemoveApp removes a managed leaf application from the environment, with the
+// overlay of the environment. The kustomize base of the application is removed
+// too, if no other environment references it. It returns the removed
+// application.
+func (e *Environment) RemoveApp(appName string) (*Application, error) {
+	rootApp, err := e.GetRootApp()
+	if err != nil {
+		return nil, err
+	}
+
+	if rootApp.labelName() == appName {
+		return nil, ErrRootApp
+	}
+
+	app, err := e.GetApp(appName)
+	if err != nil {
+		return nil, err
+	}
+
+	childApps, err := app.childApps()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(childApps) > 0 {
+		return nil, fmt.Errorf("app %s has child applications, only leaf applications can be removed", appName)
+	}
+
+	baseLocation, err := app.getBaseLocation()
+	if err != nil {
+		if !os.IsNotExist(err) && !errors.Is(err, errNoBase) {
+			return nil, err
+		}
+
+		// the application source is not a kustomize overlay of a base
+		baseLocation = ""
+	}
+
+	fs := e.c.fs
+	if err = fs.Remove(app.Path); err != nil {
+		return nil, err
+	}
+
+	if err = util.RemoveAll(fs, app.srcPath()); err != nil {
+		return nil, err
+	}
+
+	if baseLocation == "" {
+		return app, nil
+	}
+
+	referenced, err := e.c.isBaseReferenced(appName, baseLocation)
+	if err != nil || referenced {
+		return app, err
+	}
+
+	return app, util.RemoveAll(fs, baseLocation)
+}
+
+// getAppBase returns the kustomize base of the application appName in the
+// other environments, or an empty string if none of them has a base for it
+func (c *Config) getAppBase(appName string) (string, error) {
+	app, err := c.getApp(appName)
+	if err != nil {
+		if errors.Is(err, ErrAppNotFound) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	baseLocation, err := app.getBaseLocation()
+	if err != nil {
+		if os.IsNotExist(err) || errors.Is(err, errNoBase) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	// the first resource may be a remote base
+	if _, err = c.fs.Stat(baseLocation); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return baseLocation, nil
+}
+
+// isBaseReferenced returns true if the application appName in any of the
+// environments uses the kustomize base at baseLocation
+func (c *Config) isBaseReferenced(appName, baseLocation string) (bool, error) {
+	for _, env := range c.Environments {
+		app, err := env.GetApp(appName)
+		if err != nil {
+			if errors.Is(err, ErrAppNotFound) {
+				continue
+			}
+
+			return false, err
+		}
+
+		location, err := app.getBaseLocation()
+		if err != nil {
+			if os.IsNotExist(err) || errors.Is(err, errNoBase) {
+				continue
+			}
+
+			return false, err
+		}
+
+		if location == baseLocation {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// createBase writes the kustomize base of a new application from the source
+// in opts
+func createBase(fs billy.Filesystem, baseDir string, opts *AddAppOptions) error {
+	k := &kustomize.Kustomization{}
+	switch {
+	case len(opts.Manifests) > 0:
+		for name, data := range opts.Manifests {
+			if err := util.WriteFile(fs, filepath.Join(baseDir, name), data, 0644); err != nil {
+				return err
+			}
+
+			k.Resources = append(k.Resources, name)
+		}
+
+		sort.Strings(k.Resources)
+	case opts.KustomizeURL != "":
+		k.Resources = []string{opts.KustomizeURL}
+	case opts.HelmChart != nil:
+		k.HelmChartInflationGenerator = []kustomize.HelmChartArgs{*opts.HelmChart}
+	default:
+		return fmt.Errorf("app %s has no kustomize base, a source is required", opts.Name)
+	}
+
+	return writeKustomization(fs, baseDir, k)
+}
+
+func readKustomization(fs billy.Filesystem, dir string) (*kustomize.Kustomization, error) {
+	data, err := util.ReadFile(fs, filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	k := &kustomize.Kustomization{}
+	if err = yaml.Unmarshal(data, k); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// mergeKustomization merges the images, resources and patches of src into dst,
+// the images of src replace the images of dst with the same name
+func mergeKustomization(dst, src *kustomize.Kustomization) {
+	for _, img := range src.Images {
+		replaced := false
+		for i := range dst.Images {
+			if dst.Images[i].Name == img.Name {
+				dst.Images[i] = img
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			dst.Images = append(dst.Images, img)
+		}
+	}
+
+	for _, r := range src.Resources {
+		if !containsString(dst.Resources, r) {
+			dst.Resources = append(dst.Resources, r)
+		}
+	}
+
+	for _, p := range src.PatchesStrategicMerge {
+		found := false
+		for _, dp := range dst.PatchesStrategicMerge {
+			found = found || dp == p
+		}
+
+		if !found {
+			dst.PatchesStrategicMerge = append(dst.PatchesStrategicMerge, p)
+		}
+	}
+
+	dst.PatchesJson6902 = mergePatches(dst.PatchesJson6902, src.PatchesJson6902)
+	dst.Patches = mergePatches(dst.Patches, src.Patches)
+}
+
+func mergePatches(dst, src []kustomize.Patch) []kustomize.Patch {
+	for _, p := range src {
+		found := false
+		for _, dp := range dst {
+			found = found || dp.Equals(p)
+		}
+
+		if !found {
+			dst = append(dst, p)
+		}
+	}
+
+	return dst
+}
+
+func containsString(l []string, s string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeKustomization(fs billy.Filesystem, dir string, k *kustomize.Kustomization) error {
+	k.TypeMeta = kustomize.TypeMeta{
+		APIVersion: kustomize.KustomizationVersion,
+		Kind:       kustomize.KustomizationKind,
+	}
+
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFile(fs, filepath.Join(dir, "kustomization.yaml"), data, 0644)
 }
 
 // Uninstall removes all managed apps and returns true if there are no more
@@ -319,7 +802,7 @@ func (e *Environment) Uninstall() (bool, error) {
 
 	uninstalled, err := rootApp.uninstall()
 	if uninstalled {
-		return true, createDummy(filepath.Join(e.c.path, rootApp.srcPath()))
+		return true, createDummy(e.c.fs, rootApp.srcPath())
 	}
 
 	return false, err
@@ -334,8 +817,19 @@ func (e *Environment) leafApps() ([]*Application, error) {
 	return rootApp.leafApps()
 }
 
+// AppTree returns the app-of-apps tree of the environment, from its root
+// application
+func (e *Environment) AppTree() (*AppNode, error) {
+	rootApp, err := e.GetRootApp()
+	if err != nil {
+		return nil, err
+	}
+
+	return rootApp.tree()
+}
+
 func (e *Environment) GetRootApp() (*Application, error) {
-	return e.getAppFromFile(filepath.Join(e.c.path, e.RootApplicationPath))
+	return e.getAppFromFile(e.RootApplicationPath)
 }
 
 func (e *Environment) GetApp(appName string) (*Application, error) {
@@ -360,7 +854,7 @@ func (e *Environment) getAppRecurse(root *Application, appName string) (*Applica
 	}
 
 	appsDir := root.srcPath() // check if it's not in this repo
-	filenames, err := filepath.Glob(filepath.Join(e.c.path, appsDir, "*.yaml"))
+	filenames, err := util.Glob(e.c.fs, filepath.Join(appsDir, "*.yaml"))
 	if err != nil {
 		return nil, err
 	}
@@ -386,7 +880,7 @@ func (e *Environment) getAppRecurse(root *Application, appName string) (*Applica
 }
 
 func (e *Environment) getAppFromFile(path string) (*Application, error) {
-	data, err := ioutil.ReadFile(path)
+	data, err := util.ReadFile(e.c.fs, path)
 	if err != nil {
 		return nil, err
 	}
@@ -415,19 +909,19 @@ func (e *Environment) getAppFromFile(path string) (*Application, error) {
 }
 
 func (a *Application) deleteFromFilesystem() error {
-	srcDir := filepath.Join(a.env.c.path, a.srcPath())
-	err := os.RemoveAll(srcDir)
+	fs := a.env.c.fs
+	err := util.RemoveAll(fs, a.srcPath())
 	if err != nil {
 		return err
 	}
 
 	projectPath := filepath.Join(filepath.Dir(a.Path), fmt.Sprintf("%s-project.yaml", a.Name))
-	err = os.Remove(projectPath)
+	err = fs.Remove(projectPath)
 	if err != nil {
 		return err
 	}
 
-	err = os.Remove(a.Path)
+	err = fs.Remove(a.Path)
 	if err != nil {
 		return err
 	}
@@ -435,6 +929,16 @@ func (a *Application) deleteFromFilesystem() error {
 	return nil
 }
 
+// IsLeaf returns true if the application has no child applications
+func (n *AppNode) IsLeaf() bool {
+	return len(n.Children) == 0
+}
+
+// ManagedBy returns the value of the managed-by label of the application
+func (a *Application) ManagedBy() string {
+	return a.labelValue(labelsManagedBy)
+}
+
 func (a *Application) srcPath() string {
 	return a.Spec.Source.Path
 }
@@ -460,8 +964,8 @@ func (a *Application) labelValue(label string) string {
 }
 
 func (a *Application) getBaseLocation() (string, error) {
-	refKust := filepath.Join(a.env.c.path, a.srcPath(), "kustomization.yaml")
-	bytes, err := ioutil.ReadFile(refKust)
+	refKust := filepath.Join(a.srcPath(), "kustomization.yaml")
+	bytes, err := util.ReadFile(a.env.c.fs, refKust)
 	if err != nil {
 		return "", err
 	}
@@ -472,16 +976,138 @@ func (a *Application) getBaseLocation() (string, error) {
 		return "", err
 	}
 
+	if len(k.Resources) == 0 {
+		return "", fmt.Errorf("%w: %s", errNoBase, refKust)
+	}
+
 	return filepath.Clean(filepath.Join(a.srcPath(), k.Resources[0])), nil
 }
 
+// AddResource writes the manifest to the application source path, and adds it
+// to the application kustomization resources, if it is not already there
+func (a *Application) AddResource(fileName string, data []byte) error {
+	return a.addKustomizationFile(fileName, data, func(k *kustomize.Kustomization) bool {
+		for _, r := range k.Resources {
+			if r == fileName {
+				return false
+			}
+		}
+
+		k.Resources = append(k.Resources, fileName)
+		return true
+	})
+}
+
+// AddPatch writes the strategic merge patch to the application source path,
+// and adds it to the application kustomization patches, if it is not already
+// there
+func (a *Application) AddPatch(fileName string, data []byte) error {
+	return a.addKustomizationFile(fileName, data, func(k *kustomize.Kustomization) bool {
+		for _, p := range k.PatchesStrategicMerge {
+			if string(p) == fileName {
+				return false
+			}
+		}
+
+		k.PatchesStrategicMerge = append(k.PatchesStrategicMerge, kustomize.PatchStrategicMerge(fileName))
+		return true
+	})
+}
+
+// addKustomizationFile writes the file to the application source path, and
+// updates the kustomization if add changed it
+func (a *Application) addKustomizationFile(fileName string, data []byte, add func(*kustomize.Kustomization) bool) error {
+	fs := a.env.c.fs
+	if err := util.WriteFile(fs, filepath.Join(a.srcPath(), fileName), data, 0644); err != nil {
+		return err
+	}
+
+	kustPath := filepath.Join(a.srcPath(), "kustomization.yaml")
+	bytes, err := util.ReadFile(fs, kustPath)
+	if err != nil {
+		return err
+	}
+
+	k := &kustomize.Kustomization{}
+	if err = yaml.Unmarshal(bytes, k); err != nil {
+		return err
+	}
+
+	if !add(k) {
+		return nil
+	}
+
+	bytes, err = yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFile(fs, kustPath, bytes, 0644)
+}
+
+// save writes the application manifest to its path, without the status and
+// the creation timestamp, which are only set by the cluster
 func (a *Application) save() error {
-	data, err := yaml.Marshal(a)
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(a.Application)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(a.Path, data, 0644)
+	delete(u, "status")
+	unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+	data, err := yaml.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFile(a.env.c.fs, a.Path, data, 0644)
+}
+
+// updateTargetRevision updates the application, and its child applications, if
+// they are synced from repoURL
+func (a *Application) updateTargetRevision(repoURL, revision string) error {
+	if a.Spec.Source.RepoURL != repoURL {
+		return nil
+	}
+
+	if a.Spec.Source.TargetRevision != revision {
+		a.Spec.Source.TargetRevision = revision
+		if err := a.save(); err != nil {
+			return err
+		}
+	}
+
+	childApps, err := a.childApps()
+	if err != nil {
+		return err
+	}
+
+	for _, childApp := range childApps {
+		if err = childApp.updateTargetRevision(repoURL, revision); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Application) tree() (*AppNode, error) {
+	childApps, err := a.childApps()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &AppNode{Application: a}
+	for _, childApp := range childApps {
+		child, err := childApp.tree()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Children = append(node.Children, child)
+	}
+
+	return node, nil
 }
 
 func (a *Application) leafApps() ([]*Application, error) {
@@ -525,7 +1151,7 @@ func (a *Application) uninstall() (bool, error) {
 			}
 
 			if childUninstalled {
-				err = os.Remove(childApp.Path)
+				err = a.env.c.fs.Remove(childApp.Path)
 				if err != nil {
 					return uninstalled, err
 				}
@@ -540,7 +1166,7 @@ func (a *Application) uninstall() (bool, error) {
 }
 
 func (a *Application) childApps() ([]*Application, error) {
-	filenames, err := filepath.Glob(filepath.Join(a.env.c.path, a.srcPath(), "*.yaml"))
+	filenames, err := util.Glob(a.env.c.fs, filepath.Join(a.srcPath(), "*.yaml"))
 	if err != nil {
 		return nil, err
 	}
@@ -561,8 +1187,8 @@ func (a *Application) childApps() ([]*Application, error) {
 	return res, nil
 }
 
-func createDummy(path string) error {
-	file, err := os.Create(filepath.Join(path, "DUMMY"))
+func createDummy(fs billy.Filesystem, path string) error {
+	file, err := fs.Create(filepath.Join(path, "DUMMY"))
 	if err != nil {
 		return err
 	}
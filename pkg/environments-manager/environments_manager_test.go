@@ -1,14 +1,18 @@
 package environments_manager
 
 import (
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/argoproj/argo-cd/pkg/apis/application/v1alpha1"
+	"github.com/codefresh-io/cf-argo/pkg/helpers"
+	"github.com/ghodss/yaml"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/stretchr/testify/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kustomize "sigs.k8s.io/kustomize/api/types"
 )
 
 func TestEnvironment_bootstrapUrl(t *testing.T) {
@@ -40,6 +44,12 @@ func TestEnvironment_bootstrapUrl(t *testing.T) {
 			},
 			"https://github.com/foo/bar/" + bootstrapDir + "?ref=f24fcad",
 		},
+		"With Ssh Tag": {
+			&Environment{
+				TemplateRef: "git@github.com:foo/bar.git@v0.0.1",
+			},
+			"git@github.com:foo/bar.git/" + bootstrapDir + "?ref=v0.0.1",
+		},
 	}
 	for tname, tt := range tests {
 		t.Run(tname, func(t *testing.T) {
@@ -165,24 +175,20 @@ spec:
 	}
 	for tname, tt := range tests {
 		t.Run(tname, func(t *testing.T) {
-			tf, err := ioutil.TempFile("", "")
-			assert.NoError(t, err)
-			defer func() { _ = os.Remove(tf.Name()) }()
-
-			_, err = tf.Write(tt.data)
-			assert.NoError(t, err)
+			fs := memfs.New()
+			assert.NoError(t, util.WriteFile(fs, "app.yaml", tt.data, 0644))
 			env := &Environment{
 				c: &Config{
-					path: filepath.Dir(tf.Name()),
+					fs: fs,
 				},
 			}
-			got, err := env.getAppFromFile(tf.Name())
+			got, err := env.getAppFromFile("app.yaml")
 			if tt.err != "" {
 				assert.Contains(t, err.Error(), tt.err)
 				return
 			}
 			assert.NoError(t, err)
-			assert.Equal(t, tf.Name(), got.Path)
+			assert.Equal(t, "app.yaml", got.Path)
 			assert.Equal(t, tt.want.Name, got.Name)
 			assert.Equal(t, tt.want.Spec.Source.Path, got.srcPath())
 			assert.Equal(t, tt.want.Spec.Source.RepoURL, got.Spec.Source.RepoURL)
@@ -191,11 +197,6 @@ spec:
 }
 
 func TestApplication_childApps(t *testing.T) {
-	must := func(path string, err error) string {
-		assert.NoError(t, err)
-		return path
-	}
-
 	tests := map[string]struct {
 		env  *Environment
 		want []*Application
@@ -204,7 +205,7 @@ func TestApplication_childApps(t *testing.T) {
 		"Simple": {
 			&Environment{
 				c: &Config{
-					path: must(filepath.Abs("../../test/e2e/structures/uc1")),
+					fs: osfs.New("../../test/e2e/structures/uc1"),
 				},
 				RootApplicationPath: "root.yaml",
 			},
@@ -215,7 +216,7 @@ func TestApplication_childApps(t *testing.T) {
 							Name: "leaf",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc1/apps/app1.yaml")),
+					"apps/app1.yaml",
 					nil,
 				},
 			},
@@ -224,7 +225,7 @@ func TestApplication_childApps(t *testing.T) {
 		"Two levels": {
 			&Environment{
 				c: &Config{
-					path: must(filepath.Abs("../../test/e2e/structures/uc2")),
+					fs: osfs.New("../../test/e2e/structures/uc2"),
 				},
 				RootApplicationPath: "root.yaml",
 			},
@@ -235,7 +236,7 @@ func TestApplication_childApps(t *testing.T) {
 							Name: "child1",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc2/apps/app1.yaml")),
+					"apps/app1.yaml",
 					nil,
 				},
 				{
@@ -244,7 +245,7 @@ func TestApplication_childApps(t *testing.T) {
 							Name: "leaf2",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc2/apps/app2.yaml")),
+					"apps/app2.yaml",
 					nil,
 				},
 			},
@@ -278,11 +279,6 @@ func TestApplication_childApps(t *testing.T) {
 }
 
 func TestApplication_leafApps(t *testing.T) {
-	must := func(path string, err error) string {
-		assert.NoError(t, err)
-		return path
-	}
-
 	tests := map[string]struct {
 		env  *Environment
 		want []*Application
@@ -291,7 +287,7 @@ func TestApplication_leafApps(t *testing.T) {
 		"Simple": {
 			&Environment{
 				c: &Config{
-					path: must(filepath.Abs("../../test/e2e/structures/uc1")),
+					fs: osfs.New("../../test/e2e/structures/uc1"),
 				},
 				RootApplicationPath: "root.yaml",
 			},
@@ -302,7 +298,7 @@ func TestApplication_leafApps(t *testing.T) {
 							Name: "leaf",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc1/apps/app1.yaml")),
+					"apps/app1.yaml",
 					nil,
 				},
 			},
@@ -311,7 +307,7 @@ func TestApplication_leafApps(t *testing.T) {
 		"Two levels": {
 			&Environment{
 				c: &Config{
-					path: must(filepath.Abs("../../test/e2e/structures/uc2")),
+					fs: osfs.New("../../test/e2e/structures/uc2"),
 				},
 				RootApplicationPath: "root.yaml",
 			},
@@ -322,7 +318,7 @@ func TestApplication_leafApps(t *testing.T) {
 							Name: "leaf1",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc2/apps/third/app3.yaml")),
+					"apps/third/app3.yaml",
 					nil,
 				},
 				{
@@ -331,7 +327,7 @@ func TestApplication_leafApps(t *testing.T) {
 							Name: "leaf2",
 						},
 					},
-					must(filepath.Abs("../../test/e2e/structures/uc2/apps/app2.yaml")),
+					"apps/app2.yaml",
 					nil,
 				},
 			},
@@ -363,3 +359,558 @@ func TestApplication_leafApps(t *testing.T) {
 		})
 	}
 }
+
+func TestApplication_AddResource(t *testing.T) {
+	tests := map[string]struct {
+		kustomization string
+		want          []string
+	}{
+		"New resource": {
+			kustomization: "resources:\n- foo.yaml\n",
+			want:          []string{"foo.yaml", "secret.json"},
+		},
+		"Existing resource": {
+			kustomization: "resources:\n- foo.yaml\n- secret.json\n",
+			want:          []string{"foo.yaml", "secret.json"},
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NoError(t, util.WriteFile(fs, "app/kustomization.yaml", []byte(tt.kustomization), 0644))
+
+			app := &Application{
+				&v1alpha1.Application{
+					Spec: v1alpha1.ApplicationSpec{
+						Source: v1alpha1.ApplicationSource{
+							Path: "app",
+						},
+					},
+				},
+				"",
+				&Environment{c: &Config{fs: fs}},
+			}
+
+			assert.NoError(t, app.AddResource("secret.json", []byte("{}")))
+
+			data, err := util.ReadFile(fs, "app/secret.json")
+			assert.NoError(t, err)
+			assert.Equal(t, "{}", string(data))
+
+			data, err = util.ReadFile(fs, "app/kustomization.yaml")
+			assert.NoError(t, err)
+			k := &kustomize.Kustomization{}
+			assert.NoError(t, yaml.Unmarshal(data, k))
+			assert.Equal(t, tt.want, k.Resources)
+		})
+	}
+}
+
+func TestApplication_AddPatch(t *testing.T) {
+	tests := map[string]struct {
+		kustomization string
+		want          []kustomize.PatchStrategicMerge
+	}{
+		"New patch": {
+			kustomization: "resources:\n- foo.yaml\n",
+			want:          []kustomize.PatchStrategicMerge{"patch.yaml"},
+		},
+		"Existing patch": {
+			kustomization: "resources:\n- foo.yaml\npatchesStrategicMerge:\n- patch.yaml\n",
+			want:          []kustomize.PatchStrategicMerge{"patch.yaml"},
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NoError(t, util.WriteFile(fs, "app/kustomization.yaml", []byte(tt.kustomization), 0644))
+
+			app := &Application{
+				&v1alpha1.Application{
+					Spec: v1alpha1.ApplicationSpec{
+						Source: v1alpha1.ApplicationSource{
+							Path: "app",
+						},
+					},
+				},
+				"",
+				&Environment{c: &Config{fs: fs}},
+			}
+
+			assert.NoError(t, app.AddPatch("patch.yaml", []byte("kind: Secret\n")))
+
+			data, err := util.ReadFile(fs, "app/patch.yaml")
+			assert.NoError(t, err)
+			assert.Equal(t, "kind: Secret\n", string(data))
+
+			data, err = util.ReadFile(fs, "app/kustomization.yaml")
+			assert.NoError(t, err)
+			k := &kustomize.Kustomization{}
+			assert.NoError(t, yaml.Unmarshal(data, k))
+			assert.Equal(t, []string{"foo.yaml"}, k.Resources)
+			assert.Equal(t, tt.want, k.PatchesStrategicMerge)
+		})
+	}
+}
+
+func TestEnvironment_UpdateTargetRevision(t *testing.T) {
+	fs := memfs.New()
+	assert.NoError(t, helpers.CopyDir(osfs.New("../../test/e2e/structures/uc2"), "/", fs, "/"))
+
+	env := &Environment{
+		c:                   &Config{fs: fs},
+		RootApplicationPath: "root.yaml",
+	}
+	assert.NoError(t, env.UpdateTargetRevision("https://github.com/foo/bar", "staging"))
+
+	for _, f := range []string{"root.yaml", "apps/app1.yaml", "apps/app2.yaml", "apps/third/app3.yaml"} {
+		app, err := env.getAppFromFile(f)
+		assert.NoError(t, err)
+		assert.Equal(t, "staging", app.Spec.Source.TargetRevision, f)
+	}
+}
+
+func TestEnvironment_ManagedApps(t *testing.T) {
+	tests := map[string]struct {
+		env  string
+		want []string
+	}{
+		"Two managed apps": {
+			env:  "staging",
+			want: []string{"staging-argo-cd", "staging-guestbook"},
+		},
+		"Skips apps not managed": {
+			env:  "production",
+			want: []string{"production-argo-cd"},
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			conf, err := LoadConfig(osfs.New("../../test/e2e/structures/uc3"))
+			assert.NoError(t, err)
+
+			env := conf.Environments[tt.env]
+			assert.Equal(t, tt.env, env.Name())
+			assert.Equal(t, tt.env+"-argocd", env.Namespace())
+
+			apps, err := env.ManagedApps()
+			assert.NoError(t, err)
+			got := []string{}
+			for _, a := range apps {
+				got = append(got, a.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnvironment_AppTree(t *testing.T) {
+	conf, err := LoadConfig(osfs.New("../../test/e2e/structures/uc3"))
+	assert.NoError(t, err)
+
+	root, err := conf.Environments["production"].AppTree()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "production", root.Name)
+	assert.Equal(t, "argo-installer", root.ManagedBy())
+	assert.False(t, root.IsLeaf())
+	assert.Len(t, root.Children, 2)
+
+	assert.Equal(t, "production-argo-cd", root.Children[0].Name)
+	assert.Equal(t, "argo-installer", root.Children[0].ManagedBy())
+	assert.True(t, root.Children[0].IsLeaf())
+
+	assert.Equal(t, "production-external", root.Children[1].Name)
+	assert.Equal(t, "", root.Children[1].ManagedBy())
+	assert.True(t, root.Children[1].IsLeaf())
+}
+
+func TestEnvironment_AddApp(t *testing.T) {
+	tests := map[string]struct {
+		env         string
+		opts        *AddAppOptions
+		files       map[string]string
+		wantBaseDir string
+		wantBase    *kustomize.Kustomization
+		err         string
+	}{
+		"Reuses the base of another environment": {
+			env: "production",
+			opts: &AddAppOptions{
+				Name:      "guestbook",
+				Namespace: "guestbook",
+			},
+			wantBase: &kustomize.Kustomization{
+				Resources: []string{"deployment.yaml"},
+			},
+		},
+		"Reuses a base in another location": {
+			env: "production",
+			opts: &AddAppOptions{
+				Name:      "guestbook",
+				Namespace: "guestbook",
+			},
+			files: map[string]string{
+				"kustomize/components/guestbook/overlays/staging/kustomization.yaml": "resources:\n- ../../common\n",
+				"kustomize/components/guestbook/common/kustomization.yaml":           "resources:\n- service.yaml\n",
+			},
+			wantBaseDir: "kustomize/components/guestbook/common",
+			wantBase: &kustomize.Kustomization{
+				Resources: []string{"service.yaml"},
+			},
+		},
+		"Local manifests": {
+			env: "staging",
+			opts: &AddAppOptions{
+				Name:      "redis",
+				Namespace: "redis",
+				Manifests: map[string][]byte{
+					"service.yaml":    []byte("kind: Service"),
+					"deployment.yaml": []byte("kind: Deployment"),
+				},
+			},
+			wantBase: &kustomize.Kustomization{
+				Resources: []string{"deployment.yaml", "service.yaml"},
+			},
+		},
+		"Remote kustomize base": {
+			env: "staging",
+			opts: &AddAppOptions{
+				Name:         "redis",
+				Namespace:    "redis",
+				KustomizeURL: "github.com/foo/redis/manifests?ref=v1.0.0",
+			},
+			wantBase: &kustomize.Kustomization{
+				Resources: []string{"github.com/foo/redis/manifests?ref=v1.0.0"},
+			},
+		},
+		"Helm chart": {
+			env: "staging",
+			opts: &AddAppOptions{
+				Name:      "redis",
+				Namespace: "redis",
+				HelmChart: &kustomize.HelmChartArgs{
+					ChartName:    "redis",
+					ChartVersion: "12.7.4",
+					ChartRepoURL: "https://charts.bitnami.com/bitnami",
+				},
+			},
+			wantBase: &kustomize.Kustomization{
+				HelmChartInflationGenerator: []kustomize.HelmChartArgs{
+					{
+						ChartName:    "redis",
+						ChartVersion: "12.7.4",
+						ChartRepoURL: "https://charts.bitnami.com/bitnami",
+					},
+				},
+			},
+		},
+		"App already exists": {
+			env: "staging",
+			opts: &AddAppOptions{
+				Name: "guestbook",
+			},
+			err: "app already exists: guestbook",
+		},
+		"No source": {
+			env: "staging",
+			opts: &AddAppOptions{
+				Name: "redis",
+			},
+			err: "app redis has no kustomize base, a source is required",
+		},
+		"Source with an existing base": {
+			env: "production",
+			opts: &AddAppOptions{
+				Name:         "guestbook",
+				KustomizeURL: "github.com/foo/guestbook",
+			},
+			err: "app guestbook already has a kustomize base: kustomize/components/guestbook/base",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NoError(t, helpers.CopyDir(osfs.New("../../test/e2e/structures/uc3"), "/", fs, "/"))
+			for p, data := range tt.files {
+				assert.NoError(t, util.WriteFile(fs, p, []byte(data), 0644))
+			}
+			conf, err := LoadConfig(fs)
+			assert.NoError(t, err)
+			env := conf.Environments[tt.env]
+
+			_, err = env.AddApp(tt.opts)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			app, err := env.GetApp(tt.opts.Name)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.env+"-"+tt.opts.Name, app.Name)
+			assert.Equal(t, "argocd-apps/"+tt.env+"/"+tt.opts.Name+".yaml", app.Path)
+			assert.Equal(t, "kustomize/components/"+tt.opts.Name+"/overlays/"+tt.env, app.Spec.Source.Path)
+			assert.Equal(t, tt.opts.Namespace, app.Spec.Destination.Namespace)
+			assert.Equal(t, "https://github.com/foo/gitops", app.Spec.Source.RepoURL)
+			assert.Equal(t, tt.env, app.Spec.Project)
+			assert.True(t, app.isManaged())
+			assert.Equal(t, []string{resourcesFinalizer}, app.Finalizers)
+
+			wantBaseDir := tt.wantBaseDir
+			if wantBaseDir == "" {
+				wantBaseDir = "kustomize/components/" + tt.opts.Name + "/base"
+			}
+			base, err := app.getBaseLocation()
+			assert.NoError(t, err)
+			assert.Equal(t, wantBaseDir, base)
+
+			data, err := util.ReadFile(fs, base+"/kustomization.yaml")
+			assert.NoError(t, err)
+			got := &kustomize.Kustomization{}
+			assert.NoError(t, yaml.Unmarshal(data, got))
+			assert.Equal(t, tt.wantBase.Resources, got.Resources)
+			assert.Equal(t, tt.wantBase.HelmChartInflationGenerator, got.HelmChartInflationGenerator)
+
+			for name, want := range tt.opts.Manifests {
+				data, err = util.ReadFile(fs, base+"/"+name)
+				assert.NoError(t, err)
+				assert.Equal(t, want, data)
+			}
+		})
+	}
+}
+
+func TestEnvironment_RemoveApp(t *testing.T) {
+	helmKust := `helmChartInflationGenerator:
+- chartName: guestbook
+  chartRepoUrl: https://charts.example.com
+  releaseName: guestbook
+`
+	tests := map[string]struct {
+		env         string
+		app         string
+		files       map[string]string
+		wantRemoved []string
+		wantKept    []string
+		err         string
+	}{
+		"Keeps a base referenced by another environment": {
+			env: "production",
+			app: "argo-cd",
+			wantRemoved: []string{
+				"argocd-apps/production/argo-cd.yaml",
+				"kustomize/components/argo-cd/overlays/production",
+			},
+			wantKept: []string{
+				"kustomize/components/argo-cd/base/kustomization.yaml",
+				"kustomize/components/argo-cd/overlays/staging/kustomization.yaml",
+			},
+		},
+		"Removes a base no other environment references": {
+			env: "staging",
+			app: "guestbook",
+			wantRemoved: []string{
+				"argocd-apps/staging/guestbook.yaml",
+				"kustomize/components/guestbook/overlays/staging",
+				"kustomize/components/guestbook/base",
+			},
+			wantKept: []string{
+				"argocd-apps/staging/argo-cd.yaml",
+			},
+		},
+		"Helm chart generator without a base": {
+			env: "staging",
+			app: "guestbook",
+			files: map[string]string{
+				"kustomize/components/guestbook/overlays/staging/kustomization.yaml": helmKust,
+			},
+			wantRemoved: []string{
+				"argocd-apps/staging/guestbook.yaml",
+				"kustomize/components/guestbook/overlays/staging",
+			},
+			wantKept: []string{
+				"kustomize/components/guestbook/base/kustomization.yaml",
+			},
+		},
+		"Ignores another environment without a base": {
+			env: "production",
+			app: "argo-cd",
+			files: map[string]string{
+				"kustomize/components/argo-cd/overlays/staging/kustomization.yaml": helmKust,
+			},
+			wantRemoved: []string{
+				"argocd-apps/production/argo-cd.yaml",
+				"kustomize/components/argo-cd/overlays/production",
+				"kustomize/components/argo-cd/base",
+			},
+		},
+		"Root application": {
+			env: "staging",
+			app: "root",
+			err: "cannot remove the root application of an environment",
+		},
+		"App not found": {
+			env: "production",
+			app: "guestbook",
+			err: "app not found: guestbook",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NoError(t, helpers.CopyDir(osfs.New("../../test/e2e/structures/uc3"), "/", fs, "/"))
+			for p, data := range tt.files {
+				assert.NoError(t, util.WriteFile(fs, p, []byte(data), 0644))
+			}
+			conf, err := LoadConfig(fs)
+			assert.NoError(t, err)
+
+			app, err := conf.Environments[tt.env].RemoveApp(tt.app)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.env+"-"+tt.app, app.Name)
+
+			for _, p := range tt.wantRemoved {
+				_, err = fs.Stat(p)
+				assert.True(t, os.IsNotExist(err), p)
+			}
+
+			for _, p := range tt.wantKept {
+				_, err = fs.Stat(p)
+				assert.NoError(t, err, p)
+			}
+		})
+	}
+}
+
+func TestConfig_PromoteApp(t *testing.T) {
+	stagingKust := `resources:
+- ../../base
+images:
+- name: argo-cd
+  newTag: v2.0.0
+patchesStrategicMerge:
+- replicas.yaml
+`
+	productionKust := `namespace: argocd
+resources:
+- ../../base
+- pdb.yaml
+images:
+- name: argo-cd
+  newTag: v1.8.4
+- name: redis
+  newTag: "6.0"
+`
+	tests := map[string]struct {
+		app       string
+		from      string
+		to        string
+		replace   bool
+		wantKust  *kustomize.Kustomization
+		wantFiles []string
+		err       string
+	}{
+		"Merges the overlays": {
+			app:  "argo-cd",
+			from: "staging",
+			to:   "production",
+			wantKust: &kustomize.Kustomization{
+				Namespace: "argocd",
+				Resources: []string{"../../base", "pdb.yaml"},
+				Images: []kustomize.Image{
+					{Name: "argo-cd", NewTag: "v2.0.0"},
+					{Name: "redis", NewTag: "6.0"},
+				},
+				PatchesStrategicMerge: []kustomize.PatchStrategicMerge{"replicas.yaml"},
+			},
+			wantFiles: []string{"pdb.yaml", "replicas.yaml"},
+		},
+		"Replaces the overlay": {
+			app:     "argo-cd",
+			from:    "staging",
+			to:      "production",
+			replace: true,
+			wantKust: &kustomize.Kustomization{
+				Resources: []string{"../../base"},
+				Images: []kustomize.Image{
+					{Name: "argo-cd", NewTag: "v2.0.0"},
+				},
+				PatchesStrategicMerge: []kustomize.PatchStrategicMerge{"replicas.yaml"},
+			},
+			wantFiles: []string{"replicas.yaml"},
+		},
+		"Adds the app to the target environment": {
+			app:  "guestbook",
+			from: "staging",
+			to:   "production",
+			wantKust: &kustomize.Kustomization{
+				Resources:             []string{"../../base"},
+				PatchesStrategicMerge: []kustomize.PatchStrategicMerge{"replicas.yaml"},
+			},
+			wantFiles: []string{"replicas.yaml"},
+		},
+		"App not found": {
+			app:  "guestbook",
+			from: "production",
+			to:   "staging",
+			err:  "app not found: guestbook",
+		},
+		"Environment does not exist": {
+			app:  "argo-cd",
+			from: "staging",
+			to:   "foo",
+			err:  "environment does not exist: foo",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NoError(t, helpers.CopyDir(osfs.New("../../test/e2e/structures/uc3"), "/", fs, "/"))
+			overlays := "kustomize/components/argo-cd/overlays/"
+			assert.NoError(t, util.WriteFile(fs, overlays+"staging/kustomization.yaml", []byte(stagingKust), 0644))
+			assert.NoError(t, util.WriteFile(fs, overlays+"staging/replicas.yaml", []byte("kind: Deployment"), 0644))
+			assert.NoError(t, util.WriteFile(fs, overlays+"production/kustomization.yaml", []byte(productionKust), 0644))
+			assert.NoError(t, util.WriteFile(fs, overlays+"production/pdb.yaml", []byte("kind: PodDisruptionBudget"), 0644))
+			conf, err := LoadConfig(fs)
+			assert.NoError(t, err)
+
+			err = conf.PromoteApp(tt.app, tt.from, tt.to, tt.replace)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			app, err := conf.Environments[tt.to].GetApp(tt.app)
+			assert.NoError(t, err)
+
+			got, err := readKustomization(fs, app.srcPath())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantKust.Namespace, got.Namespace)
+			assert.Equal(t, tt.wantKust.Resources, got.Resources)
+			assert.Equal(t, tt.wantKust.Images, got.Images)
+			assert.Equal(t, tt.wantKust.PatchesStrategicMerge, got.PatchesStrategicMerge)
+
+			infos, err := fs.ReadDir(app.srcPath())
+			assert.NoError(t, err)
+			gotFiles := []string{}
+			for _, i := range infos {
+				if i.Name() != "kustomization.yaml" {
+					gotFiles = append(gotFiles, i.Name())
+				}
+			}
+			assert.Equal(t, tt.wantFiles, gotFiles)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// apiClient is a minimal json REST client, used by providers we do not have a
+// go sdk for
+type apiClient struct {
+	client  *http.Client
+	baseURL string
+	// auth sets the authentication headers on each request
+	auth func(*http.Request)
+	// errMsg extracts the error message from an error response body
+	errMsg func([]byte) string
+}
+
+func newAPIClient(baseURL string, auth func(*http.Request), errMsg func([]byte) string) *apiClient {
+	return &apiClient{
+		client:  &http.Client{},
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		auth:    auth,
+		errMsg:  errMsg,
+	}
+}
+
+// do sends a request to the api and decodes the response into res.
+// It returns the response status code, or 0 if no response was received.
+func (c *apiClient) do(ctx context.Context, method, path string, body, res interface{}) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.auth != nil {
+		c.auth(req)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := ""
+		if c.errMsg != nil {
+			msg = c.errMsg(data)
+		}
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+
+		return resp.StatusCode, fmt.Errorf("%s %s: %d %s", req.Method, req.URL, resp.StatusCode, msg)
+	}
+
+	if res != nil && len(data) > 0 {
+		return resp.StatusCode, json.Unmarshal(data, res)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// accessErr reports authentication and authorization errors of api requests as
+// ErrAccessDenied
+func accessErr(status int, err error) error {
+	switch status {
+	case http.StatusUnauthorized:
+		return accessDenied("the git token is invalid or expired")
+	case http.StatusForbidden:
+		return accessDenied("%s", err)
+	}
+
+	return err
+}
@@ -0,0 +1,373 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	azureDefaultHost = "https://dev.azure.com"
+	azureSSHHost     = "ssh.dev.azure.com"
+	azureAPIVersion  = "api-version=6.0"
+
+	// azureGitNamespace the security namespace of git repositories
+	azureGitNamespace = "2e9eb7ed-3c0a-47d4-87c1-0ffdd275fd87"
+	// azureGenericContribute the permission bit required to push
+	azureGenericContribute = 4
+	// azureCreateRepository the permission bit required to create repositories
+	azureCreateRepository = 256
+)
+
+type (
+	// azure works with Azure DevOps Repos. The owner is the organization and
+	// project that contains the repository: "organization/project".
+	azure struct {
+		opts *Options
+		api  *apiClient
+	}
+
+	azureProject struct {
+		ID string `json:"id"`
+	}
+
+	azureRepo struct {
+		ID        string        `json:"id"`
+		RemoteURL string        `json:"remoteUrl"`
+		Project   *azureProject `json:"project"`
+	}
+
+	azurePermissions struct {
+		Value []bool `json:"value"`
+	}
+
+	azureCreateRepo struct {
+		Name    string        `json:"name"`
+		Project *azureProject `json:"project"`
+	}
+
+	azurePullRequest struct {
+		PullRequestID   int    `json:"pullRequestId"`
+		Status          string `json:"status"`
+		LastMergeCommit *struct {
+			CommitID string `json:"commitId"`
+		} `json:"lastMergeCommit"`
+	}
+
+	azureCreatePullRequest struct {
+		SourceRefName string `json:"sourceRefName"`
+		TargetRefName string `json:"targetRefName"`
+		Title         string `json:"title"`
+		Description   string `json:"description"`
+	}
+)
+
+func newAzure(opts *Options) (Provider, error) {
+	host := azureDefaultHost
+	if opts.Host != "" {
+		host = opts.Host
+	}
+
+	return &azure{
+		opts: opts,
+		api:  newAPIClient(host, azureAuth(opts.Auth), azureErrMsg),
+	}, nil
+}
+
+func azureRepoURL(host, owner, name string) (string, error) {
+	if host == "" {
+		host = azureDefaultHost
+	}
+
+	org, project, err := splitAzureOwner(owner)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s/%s/_git/%s", strings.TrimSuffix(host, "/"), org, project, name), nil
+}
+
+// azureSSHRepoURL returns the ssh url, azure devops server (custom host) uses
+// a different format than the cloud service
+func azureSSHRepoURL(host, owner, name string) (string, error) {
+	org, project, err := splitAzureOwner(owner)
+	if err != nil {
+		return "", err
+	}
+
+	if host == "" || host == azureDefaultHost {
+		return fmt.Sprintf("git@%s:v3/%s/%s/%s", azureSSHHost, org, project, name), nil
+	}
+
+	hostname, err := hostnameOf(host)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("ssh://%s:22/%s/%s/_git/%s", hostname, org, project, name), nil
+}
+
+// azureAuth uses the personal access token as the password of basic auth, the
+// username is ignored by azure devops
+func azureAuth(auth *Auth) func(*http.Request) {
+	return func(req *http.Request) {
+		if auth != nil {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+}
+
+func azureErrMsg(data []byte) string {
+	e := &struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(data, e) != nil {
+		return ""
+	}
+
+	return e.Message
+}
+
+func (a *azure) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
+	org, project, err := splitAzureOwner(opts.Owner)
+	if err != nil {
+		return "", err
+	}
+
+	r := &azureRepo{}
+	path := fmt.Sprintf("/%s/%s/_apis/git/repositories/%s?%s", org, project, url.PathEscape(opts.Name), azureAPIVersion)
+	status, err := a.api.do(ctx, http.MethodGet, path, nil, r)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return "", ErrRepoNotFound
+		}
+		return "", err
+	}
+
+	return azureCloneURL(r.RemoteURL)
+}
+
+// CreateRepository creates the repository in the project, repository visibility
+// is controlled by the project, so opts.Private is ignored
+func (a *azure) CreateRepository(ctx context.Context, opts *CreateRepoOptions) (string, error) {
+	l := log.G(ctx).WithFields(log.Fields{
+		"owner": opts.Owner,
+		"repo":  opts.Name,
+	})
+
+	l.Debug("creating repository")
+
+	org, project, err := splitAzureOwner(opts.Owner)
+	if err != nil {
+		return "", err
+	}
+
+	p := &azureProject{}
+	path := fmt.Sprintf("/%s/_apis/projects/%s?%s", org, url.PathEscape(project), azureAPIVersion)
+	if _, err = a.api.do(ctx, http.MethodGet, path, nil, p); err != nil {
+		return "", err
+	}
+
+	r := &azureRepo{}
+	path = fmt.Sprintf("/%s/%s/_apis/git/repositories?%s", org, project, azureAPIVersion)
+	_, err = a.api.do(ctx, http.MethodPost, path, &azureCreateRepo{
+		Name:    opts.Name,
+		Project: p,
+	}, r)
+	if err != nil {
+		return "", err
+	}
+
+	l.Debug("repository created")
+
+	return azureCloneURL(r.RemoteURL)
+}
+
+// CloneRepository clones the repository with the multi_ack capability, which
+// azure devops requires, also on a custom host
+func (a *azure) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
+	return cloneRepository(ctx, &CloneOptions{
+		URL:      cloneURL,
+		Auth:     a.opts.Auth,
+		MultiAck: true,
+	}, a.opts.InMemory)
+}
+
+func (a *azure) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	org, project, name, err := splitAzureRepoURL(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &azurePullRequest{}
+	path := fmt.Sprintf("/%s/%s/_apis/git/repositories/%s/pullrequests?%s", org, project, name, azureAPIVersion)
+	_, err = a.api.do(ctx, http.MethodPost, path, &azureCreatePullRequest{
+		SourceRefName: plumbing.NewBranchReferenceName(opts.Head).String(),
+		TargetRefName: plumbing.NewBranchReferenceName(opts.Base).String(),
+		Title:         opts.Title,
+		Description:   opts.Description,
+	}, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.toPullRequest(pr, org, project, name), nil
+}
+
+func (a *azure) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	org, project, name, err := splitAzureRepoURL(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &azurePullRequest{}
+	path := fmt.Sprintf("/%s/%s/_apis/git/repositories/%s/pullrequests/%d?%s", org, project, name, opts.ID, azureAPIVersion)
+	if _, err = a.api.do(ctx, http.MethodGet, path, nil, pr); err != nil {
+		return nil, err
+	}
+
+	return a.toPullRequest(pr, org, project, name), nil
+}
+
+// ValidateAccess checks the permission bits of the git repositories security
+// namespace, of the repository or of the project
+func (a *azure) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if opts.RepoURL != "" {
+		org, project, name, err := splitAzureRepoURL(opts.RepoURL)
+		if err != nil {
+			return err
+		}
+
+		r := &azureRepo{}
+		path := fmt.Sprintf("/%s/%s/_apis/git/repositories/%s?%s", org, project, url.PathEscape(name), azureAPIVersion)
+		status, err := a.api.do(ctx, http.MethodGet, path, nil, r)
+		if err != nil {
+			if status == http.StatusNotFound {
+				return accessDenied("repository %s/%s/%s does not exist, or the git token can not access it", org, project, name)
+			}
+			return accessErr(status, err)
+		}
+
+		token := fmt.Sprintf("repoV2/%s/%s", r.Project.ID, r.ID)
+		if err = a.hasPermission(ctx, org, token, azureGenericContribute); err != nil {
+			return fmt.Errorf("failed to push to repository %s/%s/%s: %w", org, project, name, err)
+		}
+
+		return nil
+	}
+
+	org, project, err := splitAzureOwner(opts.Owner)
+	if err != nil {
+		return err
+	}
+
+	p := &azureProject{}
+	path := fmt.Sprintf("/%s/_apis/projects/%s?%s", org, url.PathEscape(project), azureAPIVersion)
+	status, err := a.api.do(ctx, http.MethodGet, path, nil, p)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return accessDenied("project %s does not exist, or the git token can not access it", opts.Owner)
+		}
+		return accessErr(status, err)
+	}
+
+	if err = a.hasPermission(ctx, org, "repoV2/"+p.ID, azureCreateRepository); err != nil {
+		return fmt.Errorf("failed to create repositories in project %s: %w", opts.Owner, err)
+	}
+
+	return nil
+}
+
+func (a *azure) hasPermission(ctx context.Context, org, token string, bit int) error {
+	res := &azurePermissions{}
+	path := fmt.Sprintf("/%s/_apis/permissions/%s/%d?tokens=%s&%s", org, azureGitNamespace, bit, url.QueryEscape(token), azureAPIVersion)
+	if status, err := a.api.do(ctx, http.MethodGet, path, nil, res); err != nil {
+		return accessErr(status, err)
+	}
+
+	if len(res.Value) == 0 || !res.Value[0] {
+		perm := "Contribute"
+		if bit == azureCreateRepository {
+			perm = "Create repository"
+		}
+		return accessDenied("the git token does not have the \"%s\" permission", perm)
+	}
+
+	return nil
+}
+
+// AddDeployKey is not supported, azure devops ssh keys belong to a user
+func (a *azure) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	return ErrDeployKeysNotSupported
+}
+
+// toPullRequest converts the api pull request, the web url is not part of the
+// api response, so it is built from the repository
+func (a *azure) toPullRequest(pr *azurePullRequest, org, project, name string) *PullRequest {
+	res := &PullRequest{
+		ID:     pr.PullRequestID,
+		URL:    fmt.Sprintf("%s/%s/%s/_git/%s/pullrequest/%d", a.api.baseURL, org, project, name, pr.PullRequestID),
+		Merged: pr.Status == "completed",
+		Closed: pr.Status == "abandoned",
+	}
+
+	// the last merge commit is the result of the test merge, until completed
+	if res.Merged && pr.LastMergeCommit != nil {
+		res.MergeCommit = pr.LastMergeCommit.CommitID
+	}
+
+	return res
+}
+
+// azureCloneURL removes the user info from the remote url returned by the api
+// ("https://org@dev.azure.com/..."), so that it matches the url argo-cd is
+// configured with
+func azureCloneURL(remoteURL string) (string, error) {
+	if remoteURL == "" {
+		return "", fmt.Errorf("repo clone url is empty")
+	}
+
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.User = nil
+	return u.String(), nil
+}
+
+// splitAzureRepoURL returns the organization, project and name of the repository
+// from an https url (".../org/project/_git/name") or ssh url ("...:v3/org/project/name")
+func splitAzureRepoURL(repoURL string) (string, string, string, error) {
+	p, err := repoPathOf(repoURL)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	parts := strings.Split(strings.TrimPrefix(p, "v3/"), "/")
+	if len(parts) == 4 && parts[2] == "_git" {
+		parts = []string{parts[0], parts[1], parts[3]}
+	}
+
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("failed to get azure repository from url: %s", repoURL)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+func splitAzureOwner(owner string) (string, string, error) {
+	parts := strings.Split(owner, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("azure owner must be in the form of \"organization/project\", got: \"%s\"", owner)
+	}
+
+	return parts[0], parts[1], nil
+}
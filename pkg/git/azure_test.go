@@ -0,0 +1,239 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAzureTestServer(t *testing.T, handlers map[string]http.HandlerFunc) (*httptest.Server, Provider) {
+	srv := newTestServer(handlers)
+	p, err := newAzure(&Options{
+		Type: "azure",
+		Auth: &Auth{Password: "token"},
+		Host: srv.URL,
+	})
+	assert.NoError(t, err)
+
+	return srv, p
+}
+
+func Test_azure_GetRepository(t *testing.T) {
+	tests := map[string]struct {
+		owner       string
+		handlers    map[string]http.HandlerFunc
+		expectedURL string
+		expectedErr string
+	}{
+		"Exists": {
+			owner: "org/proj",
+			handlers: map[string]http.HandlerFunc{
+				"GET /org/proj/_apis/git/repositories/bar": func(w http.ResponseWriter, r *http.Request) {
+					_, pass, ok := r.BasicAuth()
+					assert.True(t, ok)
+					assert.Equal(t, "token", pass)
+					assert.Equal(t, "6.0", r.URL.Query().Get("api-version"))
+					writeJSON(t, w, http.StatusOK, map[string]string{"remoteUrl": "https://org@dev.azure.com/org/proj/_git/bar"})
+				},
+			},
+			expectedURL: "https://dev.azure.com/org/proj/_git/bar",
+		},
+		"Not found": {
+			owner:       "org/proj",
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: ErrRepoNotFound.Error(),
+		},
+		"Bad owner": {
+			owner:       "org",
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: "azure owner must be in the form of",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newAzureTestServer(t, test.handlers)
+			defer srv.Close()
+
+			url, err := p.GetRepository(utils.MockLoggerContext(), &GetRepoOptions{
+				Owner: test.owner,
+				Name:  "bar",
+			})
+			if test.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_azure_CreateRepository(t *testing.T) {
+	srv, p := newAzureTestServer(t, map[string]http.HandlerFunc{
+		"GET /org/_apis/projects/proj": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(t, w, http.StatusOK, map[string]string{"id": "proj-id"})
+		},
+		"POST /org/proj/_apis/git/repositories": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{
+				"name":    "bar",
+				"project": map[string]interface{}{"id": "proj-id"},
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]string{"remoteUrl": "https://org@dev.azure.com/org/proj/_git/bar"})
+		},
+	})
+	defer srv.Close()
+
+	url, err := p.CreateRepository(utils.MockLoggerContext(), &CreateRepoOptions{
+		Owner:   "org/proj",
+		Name:    "bar",
+		Private: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev.azure.com/org/proj/_git/bar", url)
+}
+
+func Test_azure_CreatePullRequest(t *testing.T) {
+	srv, p := newAzureTestServer(t, map[string]http.HandlerFunc{
+		"POST /org/proj/_apis/git/repositories/bar/pullrequests": func(w http.ResponseWriter, r *http.Request) {
+			body := &azureCreatePullRequest{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(body))
+			assert.Equal(t, &azureCreatePullRequest{
+				SourceRefName: "refs/heads/feature",
+				TargetRefName: "refs/heads/main",
+				Title:         "title",
+				Description:   "description",
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+				"pullRequestId":   1,
+				"status":          "active",
+				"lastMergeCommit": map[string]string{"commitId": "test-merge"},
+			})
+		},
+	})
+	defer srv.Close()
+
+	pr, err := p.CreatePullRequest(utils.MockLoggerContext(), &PullRequestOptions{
+		RepoURL:     "https://dev.azure.com/org/proj/_git/bar",
+		Head:        "feature",
+		Base:        "main",
+		Title:       "title",
+		Description: "description",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &PullRequest{ID: 1, URL: srv.URL + "/org/proj/_git/bar/pullrequest/1"}, pr)
+}
+
+func Test_azure_GetPullRequest(t *testing.T) {
+	tests := map[string]struct {
+		response   map[string]interface{}
+		expectedPR *PullRequest
+	}{
+		"Active": {
+			response:   map[string]interface{}{"pullRequestId": 1, "status": "active", "lastMergeCommit": map[string]string{"commitId": "sha"}},
+			expectedPR: &PullRequest{ID: 1},
+		},
+		"Completed": {
+			response:   map[string]interface{}{"pullRequestId": 1, "status": "completed", "lastMergeCommit": map[string]string{"commitId": "sha"}},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Abandoned": {
+			response:   map[string]interface{}{"pullRequestId": 1, "status": "abandoned"},
+			expectedPR: &PullRequest{ID: 1, Closed: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newAzureTestServer(t, map[string]http.HandlerFunc{
+				"GET /org/proj/_apis/git/repositories/bar/pullrequests/1": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.GetPullRequest(utils.MockLoggerContext(), &GetPullRequestOptions{
+				RepoURL: "git@ssh.dev.azure.com:v3/org/proj/bar",
+				ID:      1,
+			})
+			assert.NoError(t, err)
+			test.expectedPR.URL = srv.URL + "/org/proj/_git/bar/pullrequest/1"
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_azure_ValidateAccess(t *testing.T) {
+	permissionsHandler := func(expectedToken string, allowed bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, expectedToken, r.URL.Query().Get("tokens"))
+			writeJSON(t, w, http.StatusOK, map[string]interface{}{"count": 1, "value": []bool{allowed}})
+		}
+	}
+
+	tests := map[string]struct {
+		opts        *ValidateAccessOptions
+		handlers    map[string]http.HandlerFunc
+		expectedErr string
+	}{
+		"Push": {
+			opts: &ValidateAccessOptions{RepoURL: "https://dev.azure.com/org/project/_git/bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /org/project/_apis/git/repositories/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": "rid", "project": map[string]string{"id": "pid"}})
+				},
+				"GET /org/_apis/permissions/" + azureGitNamespace + "/4": permissionsHandler("repoV2/pid/rid", true),
+			},
+		},
+		"Push without permission": {
+			opts: &ValidateAccessOptions{RepoURL: "https://dev.azure.com/org/project/_git/bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /org/project/_apis/git/repositories/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": "rid", "project": map[string]string{"id": "pid"}})
+				},
+				"GET /org/_apis/permissions/" + azureGitNamespace + "/4": permissionsHandler("repoV2/pid/rid", false),
+			},
+			expectedErr: `failed to push to repository org/project/bar: git access denied: the git token does not have the "Contribute" permission`,
+		},
+		"Create": {
+			opts: &ValidateAccessOptions{Owner: "org/project", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /org/_apis/projects/project": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"id": "pid"})
+				},
+				"GET /org/_apis/permissions/" + azureGitNamespace + "/256": permissionsHandler("repoV2/pid", false),
+			},
+			expectedErr: `failed to create repositories in project org/project: git access denied: the git token does not have the "Create repository" permission`,
+		},
+		"Missing project": {
+			opts:        &ValidateAccessOptions{Owner: "org/project", Name: "bar"},
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: "git access denied: project org/project does not exist, or the git token can not access it",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newAzureTestServer(t, test.handlers)
+			defer srv.Close()
+
+			err := p.ValidateAccess(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.True(t, errors.Is(err, ErrAccessDenied))
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
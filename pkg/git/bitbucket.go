@@ -0,0 +1,508 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	bitbucketCloudAPI       = "https://api.bitbucket.org/2.0"
+	bitbucketServerAPIPath  = "/rest/api/1.0"
+	bitbucketServerKeysPath = "/rest/keys/1.0"
+	bitbucketCloudTokenUser = "x-token-auth"
+	bitbucketServerSSHPort  = 7999
+)
+
+type (
+	// bitbucket works with both the Bitbucket Cloud (2.0) and Bitbucket Server
+	// (1.0) REST APIs.
+	//
+	// On Cloud, the owner is the workspace, optionally followed by the project
+	// key the repository should be created in: "workspace[/PROJECT]".
+	// On Server, the owner is the project key, or "~user" for a personal repo.
+	bitbucket struct {
+		opts *Options
+		api  *apiClient
+		// keysAPI the server ssh access keys api
+		keysAPI *apiClient
+		server  bool
+	}
+
+	bitbucketLink struct {
+		Name string `json:"name"`
+		Href string `json:"href"`
+	}
+
+	bitbucketRepo struct {
+		Links struct {
+			Clone []bitbucketLink `json:"clone"`
+		} `json:"links"`
+	}
+
+	bitbucketPullRequest struct {
+		ID    int    `json:"id"`
+		State string `json:"state"`
+		// cloud
+		Links struct {
+			HTML bitbucketLink   `json:"html"`
+			Self []bitbucketLink `json:"self"`
+		} `json:"links"`
+		MergeCommit *struct {
+			Hash string `json:"hash"`
+		} `json:"merge_commit"`
+		// server
+		Properties struct {
+			MergeCommit *struct {
+				ID string `json:"id"`
+			} `json:"mergeCommit"`
+		} `json:"properties"`
+	}
+
+	// bitbucketPermissions a page of the cloud user permissions
+	bitbucketPermissions struct {
+		Values []struct {
+			Permission string `json:"permission"`
+		} `json:"values"`
+	}
+
+	// bitbucketServerPage a page of server repositories or projects
+	bitbucketServerPage struct {
+		Values []struct {
+			Key  string `json:"key"`
+			Slug string `json:"slug"`
+		} `json:"values"`
+	}
+
+	bitbucketError struct {
+		// cloud
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		// server
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+)
+
+func newBitbucket(opts *Options) (Provider, error) {
+	baseURL := bitbucketCloudAPI
+	if opts.Host != "" {
+		baseURL = opts.Host
+	}
+
+	return &bitbucket{
+		opts: opts,
+		api:  newAPIClient(baseURL, bitbucketAuth(opts.Auth), bitbucketErrMsg),
+	}, nil
+}
+
+func newBitbucketServer(opts *Options) (Provider, error) {
+	if opts.Host == "" {
+		return nil, ErrHostRequired
+	}
+
+	return &bitbucket{
+		opts:    opts,
+		api:     newAPIClient(strings.TrimSuffix(opts.Host, "/")+bitbucketServerAPIPath, bitbucketAuth(opts.Auth), bitbucketErrMsg),
+		keysAPI: newAPIClient(strings.TrimSuffix(opts.Host, "/")+bitbucketServerKeysPath, bitbucketAuth(opts.Auth), bitbucketErrMsg),
+		server:  true,
+	}, nil
+}
+
+// bitbucketAuth uses basic auth when a username is provided (app passwords),
+// and bearer auth otherwise (access tokens)
+func bitbucketAuth(auth *Auth) func(*http.Request) {
+	return func(req *http.Request) {
+		if auth == nil {
+			return
+		}
+
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+auth.Password)
+		}
+	}
+}
+
+func bitbucketRepoURL(owner, name string) string {
+	workspace, _ := splitBitbucketOwner(owner)
+	return fmt.Sprintf("https://bitbucket.org/%s/%s.git", workspace, strings.ToLower(name))
+}
+
+func bitbucketServerRepoURL(host, owner, name string) (string, error) {
+	if host == "" {
+		return "", ErrHostRequired
+	}
+
+	return fmt.Sprintf("%s/scm/%s/%s.git", strings.TrimSuffix(host, "/"), strings.ToLower(owner), strings.ToLower(name)), nil
+}
+
+func bitbucketSSHRepoURL(owner, name string) string {
+	workspace, _ := splitBitbucketOwner(owner)
+	return fmt.Sprintf("git@bitbucket.org:%s/%s.git", workspace, strings.ToLower(name))
+}
+
+// bitbucketServerSSHRepoURL returns the ssh url, assuming the server uses the
+// default ssh port
+func bitbucketServerSSHRepoURL(host, owner, name string) (string, error) {
+	hostname, err := hostnameOf(host)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("ssh://git@%s:%d/%s/%s.git", hostname, bitbucketServerSSHPort, strings.ToLower(owner), strings.ToLower(name)), nil
+}
+
+func (b *bitbucket) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
+	r := &bitbucketRepo{}
+	status, err := b.api.do(ctx, http.MethodGet, b.repoPath(opts.Owner, opts.Name), nil, r)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return "", ErrRepoNotFound
+		}
+		return "", err
+	}
+
+	return b.cloneURL(r)
+}
+
+func (b *bitbucket) CreateRepository(ctx context.Context, opts *CreateRepoOptions) (string, error) {
+	l := log.G(ctx).WithFields(log.Fields{
+		"owner": opts.Owner,
+		"repo":  opts.Name,
+	})
+
+	l.Debug("creating repository")
+
+	var path string
+	body := map[string]interface{}{}
+	if b.server {
+		path = fmt.Sprintf("/projects/%s/repos", opts.Owner)
+		body["name"] = opts.Name
+		body["scmId"] = "git"
+		body["public"] = !opts.Private
+	} else {
+		_, project := splitBitbucketOwner(opts.Owner)
+		path = b.repoPath(opts.Owner, opts.Name)
+		body["scm"] = "git"
+		body["is_private"] = opts.Private
+		if project != "" {
+			body["project"] = map[string]string{"key": project}
+		}
+	}
+
+	r := &bitbucketRepo{}
+	if _, err := b.api.do(ctx, http.MethodPost, path, body, r); err != nil {
+		return "", err
+	}
+
+	l.Debug("repository created")
+
+	return b.cloneURL(r)
+}
+
+func (b *bitbucket) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
+	return cloneRepository(ctx, &CloneOptions{URL: cloneURL, Auth: b.gitAuth()}, b.opts.InMemory)
+}
+
+// gitAuth returns the auth used for git operations. Cloud access tokens are
+// only accepted with a well known username.
+func (b *bitbucket) gitAuth() *Auth {
+	if b.server || b.opts.Auth == nil || b.opts.Auth.Username != "" {
+		return b.opts.Auth
+	}
+
+	return &Auth{
+		Username: bitbucketCloudTokenUser,
+		Password: b.opts.Auth.Password,
+	}
+}
+
+func (b *bitbucket) repoPath(owner, name string) string {
+	if b.server {
+		return fmt.Sprintf("/projects/%s/repos/%s", owner, strings.ToLower(name))
+	}
+
+	workspace, _ := splitBitbucketOwner(owner)
+	return fmt.Sprintf("/repositories/%s/%s", workspace, strings.ToLower(name))
+}
+
+func (b *bitbucket) cloneURL(r *bitbucketRepo) (string, error) {
+	for _, l := range r.Links.Clone {
+		if l.Name == "https" || l.Name == "http" {
+			return l.Href, nil
+		}
+	}
+
+	return "", fmt.Errorf("repo clone url is empty")
+}
+
+func (b *bitbucket) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	p, err := b.pullRequestsPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"title":       opts.Title,
+		"description": opts.Description,
+	}
+	if b.server {
+		body["fromRef"] = map[string]string{"id": plumbing.NewBranchReferenceName(opts.Head).String()}
+		body["toRef"] = map[string]string{"id": plumbing.NewBranchReferenceName(opts.Base).String()}
+	} else {
+		body["source"] = map[string]interface{}{"branch": map[string]string{"name": opts.Head}}
+		body["destination"] = map[string]interface{}{"branch": map[string]string{"name": opts.Base}}
+	}
+
+	pr := &bitbucketPullRequest{}
+	if _, err = b.api.do(ctx, http.MethodPost, p, body, pr); err != nil {
+		return nil, err
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (b *bitbucket) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	p, err := b.pullRequestsPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &bitbucketPullRequest{}
+	if _, err = b.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/%d", p, opts.ID), nil, pr); err != nil {
+		return nil, err
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (b *bitbucket) pullRequestsPath(repoURL string) (string, error) {
+	owner, name, err := splitRepoPath(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	if b.server {
+		if !strings.HasPrefix(owner, "~") {
+			owner = strings.ToUpper(owner)
+		}
+		return b.repoPath(owner, name) + "/pull-requests", nil
+	}
+
+	return b.repoPath(owner, name) + "/pullrequests", nil
+}
+
+// ValidateAccess checks the user permissions. Cloud access tokens have no user,
+// so only their access to the repository or workspace is checked.
+func (b *bitbucket) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if b.server {
+		return b.validateServerAccess(ctx, opts)
+	}
+
+	hasUser := b.opts.Auth != nil && b.opts.Auth.Username != ""
+	if opts.RepoURL != "" {
+		owner, name, err := splitRepoPath(opts.RepoURL)
+		if err != nil {
+			return err
+		}
+
+		if !hasUser {
+			status, err := b.api.do(ctx, http.MethodGet, b.repoPath(owner, name), nil, nil)
+			if status == http.StatusNotFound {
+				return accessDenied("repository %s/%s does not exist, or the git token can not access it", owner, name)
+			}
+			return accessErr(status, err)
+		}
+
+		perms := &bitbucketPermissions{}
+		q := url.Values{"q": []string{fmt.Sprintf("repository.full_name=\"%s/%s\"", owner, strings.ToLower(name))}}
+		if status, err := b.api.do(ctx, http.MethodGet, "/user/permissions/repositories?"+q.Encode(), nil, perms); err != nil {
+			return accessErr(status, err)
+		}
+
+		if len(perms.Values) == 0 {
+			return accessDenied("repository %s/%s does not exist, or user %s can not access it", owner, name, b.opts.Auth.Username)
+		}
+		if perms.Values[0].Permission == "read" {
+			return accessDenied("user %s can not push to repository %s/%s, it requires write permission", b.opts.Auth.Username, owner, name)
+		}
+
+		return nil
+	}
+
+	workspace, _ := splitBitbucketOwner(opts.Owner)
+	if !hasUser {
+		status, err := b.api.do(ctx, http.MethodGet, "/workspaces/"+workspace, nil, nil)
+		if status == http.StatusNotFound {
+			return accessDenied("workspace %s does not exist, or the git token can not access it", workspace)
+		}
+		return accessErr(status, err)
+	}
+
+	perms := &bitbucketPermissions{}
+	q := url.Values{"q": []string{fmt.Sprintf("workspace.slug=\"%s\"", workspace)}}
+	if status, err := b.api.do(ctx, http.MethodGet, "/user/permissions/workspaces?"+q.Encode(), nil, perms); err != nil {
+		return accessErr(status, err)
+	}
+
+	if len(perms.Values) == 0 {
+		return accessDenied("user %s is not a member of the workspace %s", b.opts.Auth.Username, workspace)
+	}
+
+	return nil
+}
+
+func (b *bitbucket) validateServerAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if opts.RepoURL != "" {
+		owner, name, err := splitRepoPath(opts.RepoURL)
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(owner, "~") {
+			owner = strings.ToUpper(owner)
+		}
+
+		page := &bitbucketServerPage{}
+		q := url.Values{
+			"projectkey": []string{owner},
+			"name":       []string{name},
+			"permission": []string{"REPO_WRITE"},
+		}
+		if status, err := b.api.do(ctx, http.MethodGet, "/repos?"+q.Encode(), nil, page); err != nil {
+			return accessErr(status, err)
+		}
+
+		for _, r := range page.Values {
+			if r.Slug == strings.ToLower(name) {
+				return nil
+			}
+		}
+
+		return accessDenied("repository %s/%s does not exist, or the git token does not have write permission to it", owner, name)
+	}
+
+	// users can create repositories in their personal project
+	if strings.HasPrefix(opts.Owner, "~") {
+		return nil
+	}
+
+	page := &bitbucketServerPage{}
+	q := url.Values{
+		"permission": []string{"PROJECT_ADMIN"},
+		"limit":      []string{"1000"},
+	}
+	if status, err := b.api.do(ctx, http.MethodGet, "/projects?"+q.Encode(), nil, page); err != nil {
+		return accessErr(status, err)
+	}
+
+	for _, p := range page.Values {
+		if p.Key == opts.Owner {
+			return nil
+		}
+	}
+
+	return accessDenied("project %s does not exist, or the git token does not have admin permission to create repositories in it", opts.Owner)
+}
+
+// AddDeployKey adds an access key to the repository. Cloud access keys are
+// always read-only.
+func (b *bitbucket) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	if b.server {
+		if !strings.HasPrefix(owner, "~") {
+			owner = strings.ToUpper(owner)
+		}
+
+		permission := "REPO_WRITE"
+		if opts.ReadOnly {
+			permission = "REPO_READ"
+		}
+
+		path := fmt.Sprintf("/projects/%s/repos/%s/ssh", owner, strings.ToLower(name))
+		_, err = b.keysAPI.do(ctx, http.MethodPost, path, map[string]interface{}{
+			"key": map[string]string{
+				"text":  opts.PublicKey,
+				"label": opts.Title,
+			},
+			"permission": permission,
+		}, nil)
+	} else {
+		if !opts.ReadOnly {
+			return fmt.Errorf("bitbucket cloud deploy keys are read-only")
+		}
+
+		_, err = b.api.do(ctx, http.MethodPost, b.repoPath(owner, name)+"/deploy-keys", map[string]string{
+			"key":   opts.PublicKey,
+			"label": opts.Title,
+		}, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add deploy key to repository %s/%s: %w", owner, name, err)
+	}
+
+	return nil
+}
+
+func (pr *bitbucketPullRequest) toPullRequest() *PullRequest {
+	res := &PullRequest{
+		ID:     pr.ID,
+		URL:    pr.Links.HTML.Href,
+		Merged: pr.State == "MERGED",
+		Closed: pr.State == "DECLINED" || pr.State == "SUPERSEDED",
+	}
+
+	if res.URL == "" && len(pr.Links.Self) > 0 {
+		res.URL = pr.Links.Self[0].Href
+	}
+
+	if pr.MergeCommit != nil {
+		res.MergeCommit = pr.MergeCommit.Hash
+	} else if pr.Properties.MergeCommit != nil {
+		res.MergeCommit = pr.Properties.MergeCommit.ID
+	}
+
+	return res
+}
+
+func bitbucketErrMsg(data []byte) string {
+	e := &bitbucketError{}
+	if json.Unmarshal(data, e) != nil {
+		return ""
+	}
+
+	if e.Error != nil {
+		return e.Error.Message
+	}
+	if len(e.Errors) > 0 {
+		return e.Errors[0].Message
+	}
+
+	return ""
+}
+
+// splitBitbucketOwner splits a cloud owner into the workspace and the optional
+// project key
+func splitBitbucketOwner(owner string) (string, string) {
+	parts := strings.SplitN(owner, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,480 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBitbucketTestServer(t *testing.T, server bool, handlers map[string]http.HandlerFunc) (*httptest.Server, Provider) {
+	srv := newTestServer(handlers)
+	opts := &Options{
+		Auth: &Auth{Password: "token"},
+		Host: srv.URL,
+	}
+
+	var p Provider
+	var err error
+	if server {
+		opts.Type = "bitbucket-server"
+		p, err = newBitbucketServer(opts)
+	} else {
+		opts.Type = "bitbucket"
+		p, err = newBitbucket(opts)
+	}
+	assert.NoError(t, err)
+
+	return srv, p
+}
+
+func bitbucketRepoResponse(href string) map[string]interface{} {
+	return map[string]interface{}{
+		"links": map[string]interface{}{
+			"clone": []map[string]string{
+				{"name": "ssh", "href": "git@example.com:foo/bar.git"},
+				{"name": "https", "href": href},
+			},
+		},
+	}
+}
+
+func Test_bitbucket_GetRepository(t *testing.T) {
+	tests := map[string]struct {
+		server      bool
+		owner       string
+		handlers    map[string]http.HandlerFunc
+		expectedURL string
+		expectedErr string
+	}{
+		"Cloud": {
+			owner: "foo",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repositories/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+					writeJSON(t, w, http.StatusOK, bitbucketRepoResponse("https://bitbucket.org/foo/bar.git"))
+				},
+			},
+			expectedURL: "https://bitbucket.org/foo/bar.git",
+		},
+		"Cloud with project": {
+			owner: "foo/PROJ",
+			handlers: map[string]http.HandlerFunc{
+				"GET /repositories/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, bitbucketRepoResponse("https://bitbucket.org/foo/bar.git"))
+				},
+			},
+			expectedURL: "https://bitbucket.org/foo/bar.git",
+		},
+		"Server": {
+			server: true,
+			owner:  "PROJ",
+			handlers: map[string]http.HandlerFunc{
+				"GET /rest/api/1.0/projects/PROJ/repos/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, bitbucketRepoResponse("https://bitbucket.example.com/scm/proj/bar.git"))
+				},
+			},
+			expectedURL: "https://bitbucket.example.com/scm/proj/bar.git",
+		},
+		"Not found": {
+			owner:       "foo",
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: ErrRepoNotFound.Error(),
+		},
+		"Forbidden": {
+			server: true,
+			owner:  "PROJ",
+			handlers: map[string]http.HandlerFunc{
+				"GET /rest/api/1.0/projects/PROJ/repos/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusForbidden, map[string]interface{}{
+						"errors": []map[string]string{{"message": "no permission"}},
+					})
+				},
+			},
+			expectedErr: "403 no permission",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, test.handlers)
+			defer srv.Close()
+
+			url, err := p.GetRepository(utils.MockLoggerContext(), &GetRepoOptions{
+				Owner: test.owner,
+				Name:  "bar",
+			})
+			if test.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_bitbucket_CreateRepository(t *testing.T) {
+	tests := map[string]struct {
+		server       bool
+		owner        string
+		route        string
+		expectedBody map[string]interface{}
+	}{
+		"Cloud": {
+			owner: "foo",
+			route: "POST /repositories/foo/bar",
+			expectedBody: map[string]interface{}{
+				"scm":        "git",
+				"is_private": true,
+			},
+		},
+		"Cloud with project": {
+			owner: "foo/PROJ",
+			route: "POST /repositories/foo/bar",
+			expectedBody: map[string]interface{}{
+				"scm":        "git",
+				"is_private": true,
+				"project":    map[string]interface{}{"key": "PROJ"},
+			},
+		},
+		"Server": {
+			server: true,
+			owner:  "PROJ",
+			route:  "POST /rest/api/1.0/projects/PROJ/repos",
+			expectedBody: map[string]interface{}{
+				"name":   "bar",
+				"scmId":  "git",
+				"public": false,
+			},
+		},
+		"Server personal project": {
+			server: true,
+			owner:  "~user",
+			route:  "POST /rest/api/1.0/projects/~user/repos",
+			expectedBody: map[string]interface{}{
+				"name":   "bar",
+				"scmId":  "git",
+				"public": false,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, map[string]http.HandlerFunc{
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, test.expectedBody, body)
+					writeJSON(t, w, http.StatusCreated, bitbucketRepoResponse("https://example.com/bar.git"))
+				},
+			})
+			defer srv.Close()
+
+			url, err := p.CreateRepository(utils.MockLoggerContext(), &CreateRepoOptions{
+				Owner:   test.owner,
+				Name:    "bar",
+				Private: true,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "https://example.com/bar.git", url)
+		})
+	}
+}
+
+func Test_bitbucket_CreatePullRequest(t *testing.T) {
+	tests := map[string]struct {
+		server       bool
+		repoURL      string
+		path         string
+		expectedBody map[string]interface{}
+		response     map[string]interface{}
+		expectedPR   *PullRequest
+	}{
+		"Cloud": {
+			repoURL: "https://bitbucket.org/foo/bar.git",
+			path:    "POST /repositories/foo/bar/pullrequests",
+			expectedBody: map[string]interface{}{
+				"title":       "title",
+				"description": "description",
+				"source":      map[string]interface{}{"branch": map[string]interface{}{"name": "feature"}},
+				"destination": map[string]interface{}{"branch": map[string]interface{}{"name": "main"}},
+			},
+			response: map[string]interface{}{
+				"id":    1,
+				"state": "OPEN",
+				"links": map[string]interface{}{"html": map[string]string{"href": "https://bitbucket.org/foo/bar/pull-requests/1"}},
+			},
+			expectedPR: &PullRequest{ID: 1, URL: "https://bitbucket.org/foo/bar/pull-requests/1"},
+		},
+		"Server": {
+			server:  true,
+			repoURL: "ssh://git@bitbucket.example.com:7999/proj/bar.git",
+			path:    "POST /rest/api/1.0/projects/PROJ/repos/bar/pull-requests",
+			expectedBody: map[string]interface{}{
+				"title":       "title",
+				"description": "description",
+				"fromRef":     map[string]interface{}{"id": "refs/heads/feature"},
+				"toRef":       map[string]interface{}{"id": "refs/heads/main"},
+			},
+			response: map[string]interface{}{
+				"id":    1,
+				"state": "OPEN",
+				"links": map[string]interface{}{"self": []map[string]string{{"href": "https://bitbucket.example.com/projects/PROJ/repos/bar/pull-requests/1"}}},
+			},
+			expectedPR: &PullRequest{ID: 1, URL: "https://bitbucket.example.com/projects/PROJ/repos/bar/pull-requests/1"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, map[string]http.HandlerFunc{
+				test.path: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, test.expectedBody, body)
+					writeJSON(t, w, http.StatusCreated, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.CreatePullRequest(utils.MockLoggerContext(), &PullRequestOptions{
+				RepoURL:     test.repoURL,
+				Head:        "feature",
+				Base:        "main",
+				Title:       "title",
+				Description: "description",
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_bitbucket_GetPullRequest(t *testing.T) {
+	tests := map[string]struct {
+		server     bool
+		repoURL    string
+		path       string
+		response   map[string]interface{}
+		expectedPR *PullRequest
+	}{
+		"Cloud merged": {
+			repoURL:    "https://bitbucket.org/foo/bar.git",
+			path:       "GET /repositories/foo/bar/pullrequests/1",
+			response:   map[string]interface{}{"id": 1, "state": "MERGED", "merge_commit": map[string]string{"hash": "sha"}},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Cloud declined": {
+			repoURL:    "https://bitbucket.org/foo/bar.git",
+			path:       "GET /repositories/foo/bar/pullrequests/1",
+			response:   map[string]interface{}{"id": 1, "state": "DECLINED"},
+			expectedPR: &PullRequest{ID: 1, Closed: true},
+		},
+		"Server merged": {
+			server:     true,
+			repoURL:    "https://bitbucket.example.com/scm/proj/bar.git",
+			path:       "GET /rest/api/1.0/projects/PROJ/repos/bar/pull-requests/1",
+			response:   map[string]interface{}{"id": 1, "state": "MERGED", "properties": map[string]interface{}{"mergeCommit": map[string]string{"id": "sha"}}},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Server personal repo": {
+			server:     true,
+			repoURL:    "https://bitbucket.example.com/scm/~user/bar.git",
+			path:       "GET /rest/api/1.0/projects/~user/repos/bar/pull-requests/1",
+			response:   map[string]interface{}{"id": 1, "state": "OPEN"},
+			expectedPR: &PullRequest{ID: 1},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, map[string]http.HandlerFunc{
+				test.path: func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.GetPullRequest(utils.MockLoggerContext(), &GetPullRequestOptions{
+				RepoURL: test.repoURL,
+				ID:      1,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_bitbucket_ValidateAccess(t *testing.T) {
+	tests := map[string]struct {
+		server      bool
+		username    string
+		opts        *ValidateAccessOptions
+		handlers    map[string]http.HandlerFunc
+		expectedErr string
+	}{
+		"Push": {
+			username: "user",
+			opts:     &ValidateAccessOptions{RepoURL: "https://bitbucket.org/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /user/permissions/repositories": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, `repository.full_name="foo/bar"`, r.URL.Query().Get("q"))
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"values": []map[string]string{{"permission": "write"}},
+					})
+				},
+			},
+		},
+		"Push with read permission": {
+			username: "user",
+			opts:     &ValidateAccessOptions{RepoURL: "https://bitbucket.org/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /user/permissions/repositories": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"values": []map[string]string{{"permission": "read"}},
+					})
+				},
+			},
+			expectedErr: "git access denied: user user can not push to repository foo/bar, it requires write permission",
+		},
+		"Push with access token": {
+			opts: &ValidateAccessOptions{RepoURL: "https://bitbucket.org/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /repositories/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, bitbucketRepoResponse("https://bitbucket.org/foo/bar.git"))
+				},
+			},
+		},
+		"Create outside of workspace": {
+			username: "user",
+			opts:     &ValidateAccessOptions{Owner: "foo", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /user/permissions/workspaces": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, `workspace.slug="foo"`, r.URL.Query().Get("q"))
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"values": []interface{}{}})
+				},
+			},
+			expectedErr: "git access denied: user user is not a member of the workspace foo",
+		},
+		"Server push": {
+			server: true,
+			opts:   &ValidateAccessOptions{RepoURL: "https://bitbucket.example.com/scm/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /rest/api/1.0/repos": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "FOO", r.URL.Query().Get("projectkey"))
+					assert.Equal(t, "REPO_WRITE", r.URL.Query().Get("permission"))
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"values": []map[string]string{{"slug": "bar"}},
+					})
+				},
+			},
+		},
+		"Server create without admin": {
+			server: true,
+			opts:   &ValidateAccessOptions{Owner: "FOO", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /rest/api/1.0/projects": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"values": []map[string]string{{"key": "BAR"}},
+					})
+				},
+			},
+			expectedErr: "git access denied: project FOO does not exist, or the git token does not have admin permission to create repositories in it",
+		},
+		"Server create personal": {
+			server:   true,
+			opts:     &ValidateAccessOptions{Owner: "~user", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, test.handlers)
+			defer srv.Close()
+			p.(*bitbucket).opts.Auth.Username = test.username
+
+			err := p.ValidateAccess(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.True(t, errors.Is(err, ErrAccessDenied))
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_bitbucket_AddDeployKey(t *testing.T) {
+	tests := map[string]struct {
+		server       bool
+		repoURL      string
+		readOnly     bool
+		route        string
+		expectedBody map[string]interface{}
+		expectedErr  string
+	}{
+		"Cloud": {
+			repoURL:  "https://bitbucket.org/foo/bar.git",
+			readOnly: true,
+			route:    "POST /repositories/foo/bar/deploy-keys",
+			expectedBody: map[string]interface{}{
+				"key":   "ssh-ed25519 AAAA",
+				"label": "argo-cd",
+			},
+		},
+		"Cloud read-write": {
+			repoURL:     "https://bitbucket.org/foo/bar.git",
+			expectedErr: "bitbucket cloud deploy keys are read-only",
+		},
+		"Server": {
+			server:   true,
+			repoURL:  "https://bitbucket.example.com/scm/proj/bar.git",
+			readOnly: true,
+			route:    "POST /rest/keys/1.0/projects/PROJ/repos/bar/ssh",
+			expectedBody: map[string]interface{}{
+				"key": map[string]interface{}{
+					"text":  "ssh-ed25519 AAAA",
+					"label": "argo-cd",
+				},
+				"permission": "REPO_READ",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newBitbucketTestServer(t, test.server, map[string]http.HandlerFunc{
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, test.expectedBody, body)
+					writeJSON(t, w, http.StatusCreated, map[string]interface{}{"id": 1})
+				},
+			})
+			defer srv.Close()
+
+			err := p.AddDeployKey(utils.MockLoggerContext(), &DeployKeyOptions{
+				RepoURL:   test.repoURL,
+				Title:     "argo-cd",
+				PublicKey: "ssh-ed25519 AAAA",
+				ReadOnly:  test.readOnly,
+			})
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+)
+
+// gitCredentialFill runs "git credential fill" with the input, without
+// prompting the user when no helper has the credentials
+var gitCredentialFill = func(ctx context.Context, input string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	return cmd.Output()
+}
+
+// LookupCredentials returns the credentials of the repository host from the git
+// credential helpers, or from the netrc file ($NETRC, or ~/.netrc by default).
+// It returns nil if there are no credentials for the host, or if the url is not
+// an http(s) url.
+func LookupCredentials(ctx context.Context, repoURL string) (*Auth, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, nil
+	}
+
+	if auth := credentialHelperLookup(ctx, u); auth != nil {
+		log.G(ctx).WithField("host", u.Host).Debug("using credentials from git credential helper")
+		return auth, nil
+	}
+
+	auth, err := netrcLookup(netrcPath(), u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	if auth != nil {
+		log.G(ctx).WithField("host", u.Host).Debug("using credentials from netrc")
+	}
+
+	return auth, nil
+}
+
+// credentialHelperLookup asks the configured git credential helpers for the
+// credentials of the host, using the git credential protocol
+func credentialHelperLookup(ctx context.Context, u *url.URL) *Auth {
+	input := fmt.Sprintf("protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if u.User != nil && u.User.Username() != "" {
+		input += fmt.Sprintf("username=%s\n", u.User.Username())
+	}
+
+	out, err := gitCredentialFill(ctx, input+"\n")
+	if err != nil {
+		// git is not installed, or no helper has credentials for the host
+		log.G(ctx).WithError(err).Debug("git credential fill failed")
+		return nil
+	}
+
+	auth := &Auth{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "username":
+			auth.Username = parts[1]
+		case "password":
+			auth.Password = parts[1]
+		}
+	}
+
+	if auth.Password == "" {
+		return nil
+	}
+
+	return auth
+}
+
+func netrcPath() string {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+
+	return filepath.Join(home, ".netrc")
+}
+
+// netrcLookup returns the login and password of the machine entry of the host
+// in the netrc file, or of the default entry. It returns nil if the file does
+// not exist, or has no entry for the host.
+func netrcLookup(path, host string) (*Auth, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to read netrc file: %w", err)
+	}
+
+	// macro definitions are skipped, they end with an empty line
+	var tokens []string
+	inMacro := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if f == "macdef" {
+				inMacro = true
+				fields = fields[:i]
+				break
+			}
+		}
+
+		tokens = append(tokens, fields...)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read netrc file: %w", err)
+	}
+
+	var cur, match, def *Auth
+	for i := 0; i < len(tokens); i++ {
+		key := tokens[i]
+		if key == "default" {
+			cur = &Auth{}
+			if def == nil {
+				def = cur
+			}
+			continue
+		}
+
+		// every other token is followed by a value
+		if i+1 == len(tokens) {
+			break
+		}
+		i++
+		value := tokens[i]
+
+		switch key {
+		case "machine":
+			cur = &Auth{}
+			if value == host && match == nil {
+				match = cur
+			}
+		case "login":
+			if cur != nil {
+				cur.Username = value
+			}
+		case "password":
+			if cur != nil {
+				cur.Password = value
+			}
+		}
+	}
+
+	if match == nil {
+		match = def
+	}
+
+	if match == nil || match.Password == "" {
+		return nil, nil
+	}
+
+	return match, nil
+}
@@ -0,0 +1,147 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LookupCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netrc-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	netrc := filepath.Join(dir, ".netrc")
+	assert.NoError(t, ioutil.WriteFile(netrc, []byte("machine gitlab.com login foo password netrc-token\n"), 0600))
+	origNetrc, hadNetrc := os.LookupEnv("NETRC")
+	defer func() {
+		if hadNetrc {
+			os.Setenv("NETRC", origNetrc)
+		} else {
+			os.Unsetenv("NETRC")
+		}
+	}()
+	os.Setenv("NETRC", netrc)
+
+	origFill := gitCredentialFill
+	defer func() { gitCredentialFill = origFill }()
+
+	tests := map[string]struct {
+		url       string
+		helper    string
+		helperErr error
+		wantInput string
+		want      *Auth
+	}{
+		"Credential helper": {
+			url:       "https://github.com/foo/bar.git",
+			helper:    "protocol=https\nhost=github.com\nusername=foo\npassword=helper-token\n",
+			wantInput: "protocol=https\nhost=github.com\n\n",
+			want:      &Auth{Username: "foo", Password: "helper-token"},
+		},
+		"Credential helper with username and port": {
+			url:       "http://bar@git.example.com:3000/foo/bar.git",
+			helper:    "protocol=http\nhost=git.example.com:3000\nusername=bar\npassword=helper-token\n",
+			wantInput: "protocol=http\nhost=git.example.com:3000\nusername=bar\n\n",
+			want:      &Auth{Username: "bar", Password: "helper-token"},
+		},
+		"Netrc fallback": {
+			url:       "https://gitlab.com/foo/bar.git",
+			helperErr: errors.New("exit status 128"),
+			wantInput: "protocol=https\nhost=gitlab.com\n\n",
+			want:      &Auth{Username: "foo", Password: "netrc-token"},
+		},
+		"Not found": {
+			url:       "https://bitbucket.org/foo/bar.git",
+			helperErr: errors.New("exit status 128"),
+			wantInput: "protocol=https\nhost=bitbucket.org\n\n",
+		},
+		"Ssh url": {
+			url: "git@github.com:foo/bar.git",
+		},
+		"File url": {
+			url: "file:///tmp/foo/bar",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			gitCredentialFill = func(_ context.Context, input string) ([]byte, error) {
+				called = true
+				assert.Equal(t, test.wantInput, input)
+				return []byte(test.helper), test.helperErr
+			}
+
+			got, err := LookupCredentials(utils.MockLoggerContext(), test.url)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.wantInput != "", called)
+		})
+	}
+}
+
+func Test_netrcLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netrc-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]struct {
+		data string
+		host string
+		want *Auth
+	}{
+		"Machine": {
+			data: "machine github.com login foo password token\n",
+			host: "github.com",
+			want: &Auth{Username: "foo", Password: "token"},
+		},
+		"Multi line": {
+			data: "machine gitlab.com\n  login bar\n  password other\nmachine github.com\n  login foo\n  password token\n",
+			host: "github.com",
+			want: &Auth{Username: "foo", Password: "token"},
+		},
+		"Default": {
+			data: "machine gitlab.com login bar password other\ndefault login foo password token\n",
+			host: "github.com",
+			want: &Auth{Username: "foo", Password: "token"},
+		},
+		"Machine before default": {
+			data: "default login bar password other\nmachine github.com login foo password token\n",
+			host: "github.com",
+			want: &Auth{Username: "foo", Password: "token"},
+		},
+		"Macro": {
+			data: "macdef init\n  machine github.com login bar password other\n\nmachine github.com login foo password token\n",
+			host: "github.com",
+			want: &Auth{Username: "foo", Password: "token"},
+		},
+		"No password": {
+			data: "machine github.com login foo\n",
+			host: "github.com",
+		},
+		"Not found": {
+			data: "machine gitlab.com login foo password token\n",
+			host: "github.com",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			assert.NoError(t, ioutil.WriteFile(path, []byte(test.data), 0600))
+
+			got, err := netrcLookup(path, test.host)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	got, err := netrcLookup(filepath.Join(dir, "missing"), "github.com")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
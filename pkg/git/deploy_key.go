@@ -0,0 +1,149 @@
+package git
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// DeployKey an ssh keypair, used to give access to a single repository
+type DeployKey struct {
+	// PrivateKey PEM encoded in the OpenSSH format
+	PrivateKey []byte
+	// PublicKey in the authorized_keys format
+	PublicKey string
+}
+
+// openSSHKeyMagic the header of the keys in the OpenSSH format, see
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+const openSSHKeyMagic = "openssh-key-v1\x00"
+
+// openSSHKey the unencrypted envelope of a key in the OpenSSH format
+type openSSHKey struct {
+	CipherName   string
+	KdfName      string
+	KdfOpts      string
+	NumKeys      uint32
+	PubKey       []byte
+	PrivKeyBlock []byte
+}
+
+// openSSHEd25519PrivKey the private section of an ed25519 key in the OpenSSH
+// format, Pad aligns it to the cipher block size
+type openSSHEd25519PrivKey struct {
+	Check1  uint32
+	Check2  uint32
+	Keytype string
+	Pub     []byte
+	Priv    []byte
+	Comment string
+	Pad     []byte `ssh:"rest"`
+}
+
+// GenerateDeployKey generates a new ed25519 keypair
+func GenerateDeployKey() (*DeployKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	privPEM, err := marshalOpenSSHEd25519(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeployKey{
+		PrivateKey: privPEM,
+		PublicKey:  strings.TrimSpace(string(gossh.MarshalAuthorizedKey(sshPub))),
+	}, nil
+}
+
+// DecryptPrivateKey decrypts a passphrase protected ssh private key, and returns
+// it PEM encoded in a format that OpenSSH loads
+func DecryptPrivateKey(key, passphrase []byte) ([]byte, error) {
+	priv, err := gossh.ParseRawPrivateKeyWithPassphrase(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalPrivateKey(priv)
+}
+
+// marshalPrivateKey PEM encodes the key in the format OpenSSH writes it:
+// PKCS1 for rsa, SEC1 for ecdsa, and the OpenSSH format for ed25519
+func marshalPrivateKey(priv interface{}) ([]byte, error) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case ed25519.PrivateKey:
+		return marshalOpenSSHEd25519(k)
+	case *ed25519.PrivateKey:
+		// openssh ed25519 keys are parsed to a pointer
+		return marshalOpenSSHEd25519(*k)
+	default:
+		return nil, fmt.Errorf("unsupported ssh private key type: %T", priv)
+	}
+}
+
+// marshalOpenSSHEd25519 PEM encodes the ed25519 key in the unencrypted OpenSSH
+// format, OpenSSH does not load ed25519 keys from PKCS8
+func marshalOpenSSHEd25519(priv ed25519.PrivateKey) ([]byte, error) {
+	sshPub, err := gossh.NewPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// the check ints are equal random numbers, used to verify the decryption
+	check := make([]byte, 4)
+	if _, err = rand.Read(check); err != nil {
+		return nil, err
+	}
+
+	pk := openSSHEd25519PrivKey{
+		Check1:  binary.BigEndian.Uint32(check),
+		Check2:  binary.BigEndian.Uint32(check),
+		Keytype: gossh.KeyAlgoED25519,
+		Pub:     priv.Public().(ed25519.PublicKey),
+		Priv:    priv,
+	}
+
+	// pad to the block size of the "none" cipher with the bytes 1, 2, 3...
+	blockLen := len(gossh.Marshal(pk))
+	for i := 0; blockLen%8 != 0; i++ {
+		pk.Pad = append(pk.Pad, byte(i+1))
+		blockLen++
+	}
+
+	w := openSSHKey{
+		CipherName:   "none",
+		KdfName:      "none",
+		NumKeys:      1,
+		PubKey:       sshPub.Marshal(),
+		PrivKeyBlock: gossh.Marshal(pk),
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte(openSSHKeyMagic), gossh.Marshal(w)...),
+	}), nil
+}
@@ -0,0 +1,212 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+
+	gg "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// file works with bare repositories on the local filesystem (or a mounted
+// path), for installs that do not have access to a remote git provider.
+// Options.Host is the base directory of the repositories (defaults to the
+// working directory), and the owner is a sub directory of it.
+type file struct {
+	opts *Options
+}
+
+// fileTransport serves file:// urls in-process, instead of running the
+// git-upload-pack and git-receive-pack binaries, which might not be installed.
+// It is installed once a file provider is created, before that file:// urls
+// are served by the go-git default, which runs the binaries.
+//
+// A fetch into a branch with commits that were not pushed (e.g. a reset after a
+// rejected push) sends those commits as "have" lines. git-upload-pack ignores
+// the haves it does not know, since they only tell the server which objects the
+// client already has, but the go-git server fails on them. So the upload-pack
+// session drops them before the request is served, which is what
+// git-upload-pack would do.
+type fileTransport struct {
+	transport.Transport
+}
+
+type fileUploadPackSession struct {
+	transport.UploadPackSession
+	s storer.EncodedObjectStorer
+}
+
+var installFileTransport sync.Once
+
+func (t *fileTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	sess, err := t.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := server.DefaultLoader.Load(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileUploadPackSession{sess, s}, nil
+}
+
+func (sess *fileUploadPackSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	haves := make([]plumbing.Hash, 0, len(req.Haves))
+	for _, h := range req.Haves {
+		if sess.s.HasEncodedObject(h) == nil {
+			haves = append(haves, h)
+		}
+	}
+
+	req.Haves = haves
+	return sess.UploadPackSession.UploadPack(ctx, req)
+}
+
+func newFile(opts *Options) (Provider, error) {
+	installFileTransport.Do(func() {
+		client.InstallProtocol("file", &fileTransport{server.DefaultServer})
+	})
+
+	return &file{opts}, nil
+}
+
+func fileRepoURL(host, owner, name string) (string, error) {
+	p, err := fileRepoPath(host, owner, name)
+	if err != nil {
+		return "", err
+	}
+
+	return "file://" + filepath.ToSlash(p), nil
+}
+
+// fileRepoPath returns the absolute path of the repository
+func fileRepoPath(host, owner, name string) (string, error) {
+	return filepath.Abs(filepath.Join(host, owner, name))
+}
+
+func (f *file) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
+	p, err := fileRepoPath(f.opts.Host, opts.Owner, opts.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = gg.PlainOpen(p); err != nil {
+		if err == gg.ErrRepositoryNotExists {
+			return "", ErrRepoNotFound
+		}
+		return "", err
+	}
+
+	return fileRepoURL(f.opts.Host, opts.Owner, opts.Name)
+}
+
+// CreateRepository creates a bare repository, the visibility, description and
+// teams options are ignored
+func (f *file) CreateRepository(ctx context.Context, opts *CreateRepoOptions) (string, error) {
+	p, err := fileRepoPath(f.opts.Host, opts.Owner, opts.Name)
+	if err != nil {
+		return "", err
+	}
+
+	l := log.G(ctx).WithFields(log.Fields{
+		"owner": opts.Owner,
+		"repo":  opts.Name,
+		"path":  p,
+	})
+
+	l.Debug("creating repository")
+
+	if _, err = os.Stat(p); err == nil {
+		return "", fmt.Errorf("repository path already exists: %s", p)
+	}
+
+	if err = os.MkdirAll(p, 0755); err != nil {
+		return "", err
+	}
+
+	r, err := plainInit(p, true)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.DefaultBranch != "" {
+		head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(opts.DefaultBranch))
+		if err = r.Storer.SetReference(head); err != nil {
+			return "", err
+		}
+	}
+
+	l.Debug("repository created")
+
+	return fileRepoURL(f.opts.Host, opts.Owner, opts.Name)
+}
+
+// ValidateAccess checks that the repository directory, or the nearest existing
+// directory it will be created in, is writable
+func (f *file) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	var (
+		p   string
+		err error
+	)
+
+	if opts.RepoURL != "" {
+		u, err := url.Parse(opts.RepoURL)
+		if err != nil {
+			return err
+		}
+
+		p = filepath.FromSlash(u.Path)
+		if _, err = gg.PlainOpen(p); err != nil {
+			return accessDenied("repository %s does not exist: %s", p, err)
+		}
+	} else {
+		if p, err = fileRepoPath(f.opts.Host, opts.Owner, opts.Name); err != nil {
+			return err
+		}
+
+		for {
+			if _, err = os.Stat(p); err == nil || filepath.Dir(p) == p {
+				break
+			}
+			p = filepath.Dir(p)
+		}
+	}
+
+	tmp, err := ioutil.TempFile(p, ".cf-argo-access-")
+	if err != nil {
+		return accessDenied("directory %s is not writable: %s", p, err)
+	}
+
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+func (f *file) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
+	return cloneRepository(ctx, &CloneOptions{URL: cloneURL}, f.opts.InMemory)
+}
+
+func (f *file) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	return nil, ErrPullRequestsNotSupported
+}
+
+func (f *file) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	return nil, ErrPullRequestsNotSupported
+}
+
+func (f *file) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	return ErrDeployKeysNotSupported
+}
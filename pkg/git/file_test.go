@@ -0,0 +1,201 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_fileRepoURL(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+
+	tests := map[string]struct {
+		host        string
+		owner       string
+		expectedURL string
+	}{
+		"With host": {
+			host:        "/mnt/gitops",
+			owner:       "foo",
+			expectedURL: "file:///mnt/gitops/foo/bar",
+		},
+		"Absolute owner": {
+			owner:       "/mnt/gitops",
+			expectedURL: "file:///mnt/gitops/bar",
+		},
+		"Relative owner": {
+			owner:       "foo",
+			expectedURL: "file://" + filepath.ToSlash(filepath.Join(wd, "foo", "bar")),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			url, err := fileRepoURL(test.host, test.owner, "bar")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_file(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+
+	_, err = p.GetRepository(ctx, &GetRepoOptions{Owner: "foo", Name: "bar"})
+	assert.Equal(t, ErrRepoNotFound, err)
+
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, "file://"+filepath.ToSlash(filepath.Join(host, "foo", "bar")), cloneURL)
+
+	_, err = p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.Error(t, err)
+
+	url, err := p.GetRepository(ctx, &GetRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, cloneURL, url)
+
+	pushFile(ctx, t, cloneURL, "README.md")
+
+	r, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err := r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "README.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "README.md", string(data))
+}
+
+// pushFile pushes a commit with a new file to the remote repository
+func pushFile(ctx context.Context, t *testing.T, cloneURL, fileName string) {
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := Init(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, fileName), []byte(fileName), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "init"})
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddRemote(ctx, "origin", cloneURL))
+	assert.NoError(t, r.Push(ctx, &PushOptions{}))
+}
+
+func Test_file_ValidateAccess(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+
+	assert.NoError(t, p.ValidateAccess(ctx, &ValidateAccessOptions{Owner: "foo", Name: "bar"}))
+
+	cloneURL, err := fileRepoURL(host, "foo", "bar")
+	assert.NoError(t, err)
+	err = p.ValidateAccess(ctx, &ValidateAccessOptions{RepoURL: cloneURL})
+	assert.True(t, errors.Is(err, ErrAccessDenied))
+
+	cloneURL, err = p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	assert.NoError(t, p.ValidateAccess(ctx, &ValidateAccessOptions{RepoURL: cloneURL}))
+
+	files, err := ioutil.ReadDir(filepath.Join(host, "foo", "bar"))
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.NotContains(t, f.Name(), ".cf-argo-access-")
+	}
+}
+
+func Test_file_DefaultBranch(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar", DefaultBranch: "main"})
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := InitBranch(ctx, dir, "main")
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	commitFile(ctx, t, r, dir, "README.md")
+	assert.NoError(t, r.AddRemote(ctx, "origin", cloneURL))
+	assert.NoError(t, r.Push(ctx, &PushOptions{}))
+
+	clone, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err := clone.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	branch, err := clone.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+	_, err = os.Stat(filepath.Join(root, "README.md"))
+	assert.NoError(t, err)
+}
+
+type fakeUploadPackSession struct {
+	transport.UploadPackSession
+	req *packp.UploadPackRequest
+}
+
+func (s *fakeUploadPackSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	s.req = req
+	return nil, nil
+}
+
+func Test_fileUploadPackSession_UploadPack(t *testing.T) {
+	s := memory.NewStorage()
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	known, err := s.SetEncodedObject(obj)
+	assert.NoError(t, err)
+	unknown := plumbing.NewHash("7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a")
+
+	fake := &fakeUploadPackSession{}
+	sess := &fileUploadPackSession{fake, s}
+	req := packp.NewUploadPackRequest()
+	req.Haves = []plumbing.Hash{unknown, known}
+
+	_, err = sess.UploadPack(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, []plumbing.Hash{known}, fake.req.Haves)
+}
+
+func Test_newFile(t *testing.T) {
+	_, err := newFile(&Options{Type: "file"})
+	assert.NoError(t, err)
+	assert.IsType(t, &fileTransport{}, client.Protocols["file"])
+}
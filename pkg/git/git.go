@@ -6,18 +6,30 @@ package git
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
 	"github.com/codefresh-io/cf-argo/pkg/log"
 
-	"github.com/go-git/go-git/plumbing/transport"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
 	gg "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type (
@@ -28,13 +40,45 @@ type (
 		AddRemote(ctx context.Context, name, url string) error
 
 		// Commits all files and returns the commit sha
-		Commit(ctx context.Context, msg string) (string, error)
+		Commit(ctx context.Context, opts *CommitOptions) (string, error)
 
+		// Push pushes to the remote, returns ErrPushRejected if the remote branch
+		// has commits that are not in the local branch
 		Push(context.Context, *PushOptions) error
 
+		// ResetToRemote fetches the remote branch and hard resets the local branch
+		// to it, local commits and changes are discarded
+		ResetToRemote(ctx context.Context, opts *FetchOptions) error
+
 		IsNewRepo() (bool, error)
 
+		// Root returns the path of the worktree, the root of Filesystem
 		Root() (string, error)
+
+		// Filesystem returns the worktree filesystem
+		Filesystem() (billy.Filesystem, error)
+
+		// CreateBranch creates a new branch from HEAD and checks it out, keeping
+		// the changes in the worktree
+		CreateBranch(ctx context.Context, name string) error
+
+		// Checkout checks out an existing local or remote branch
+		Checkout(ctx context.Context, branch string) error
+
+		// CurrentBranch returns the name of the checked out branch
+		CurrentBranch() (string, error)
+
+		// Status returns the changed files in the index and the worktree, sorted
+		// by path
+		Status() ([]*FileStatus, error)
+
+		// Diff returns the changed files between two revisions, sorted by path.
+		// An empty from diffs against an empty tree, an empty to defaults to HEAD
+		Diff(from, to string) ([]*FileDiff, error)
+
+		// Log returns the commits reachable from HEAD that changed the path (a
+		// file or a directory), newest first. An empty path returns all commits
+		Log(path string) ([]*CommitInfo, error)
 	}
 
 	// Provider represents a git provider
@@ -48,19 +92,84 @@ type (
 		// CloneRepository tries to clone the repository and return it if it exists or
 		// ErrRepoNotFound if the repo does not exist
 		CloneRepository(ctx context.Context, cloneURL string) (Repository, error)
+
+		// CreatePullRequest opens a pull request (merge request) from the head branch
+		// into the base branch, returns ErrPullRequestsNotSupported if the provider
+		// does not support pull requests
+		CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error)
+
+		// GetPullRequest returns the current state of the pull request
+		GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error)
+
+		// ValidateAccess checks that the credentials can push to the existing
+		// repository, or create the new repository, before anything is changed.
+		// It returns an ErrAccessDenied error that describes what is missing
+		ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error
+
+		// AddDeployKey registers the public key with access to the repository,
+		// returns ErrDeployKeysNotSupported if the provider does not support
+		// deploy keys
+		AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error
+	}
+
+	// BranchProtector is implemented by providers that can protect a branch of
+	// the repository, once it was pushed
+	BranchProtector interface {
+		ProtectBranch(ctx context.Context, opts *ProtectBranchOptions) error
+	}
+
+	// WebhookCreator is implemented by providers that can notify a url of
+	// pushes to the repository
+	WebhookCreator interface {
+		// CreateWebhook creates a push webhook, or updates the existing webhook
+		// of the same url
+		CreateWebhook(ctx context.Context, opts *WebhookOptions) error
 	}
 
 	// Options for a new git provider
 	Options struct {
 		Type string
 		Auth *Auth
+		// Host the url of a self hosted server (e.g. GitHub Enterprise), or the
+		// base directory of the file provider
 		Host string
+		// InMemory when true, CloneRepository clones into memory instead of a
+		// temp dir, and nothing is written to disk
+		InMemory bool
 	}
 
 	// Auth for git provider
 	Auth struct {
 		Username string
 		Password string
+		// SSH when set, git operations authenticate with ssh instead of the
+		// username and password, which are still used by the provider api
+		SSH *SSHAuth
+		// GithubApp when set, the provider api and git operations authenticate
+		// with installation tokens of the app, instead of the username and password
+		GithubApp *GithubAppAuth
+	}
+
+	// GithubAppAuth for a GitHub App installation
+	GithubAppAuth struct {
+		AppID          int64
+		InstallationID int64
+		// PrivateKeyPath path to the PEM encoded private key of the app
+		PrivateKeyPath string
+		// Host the url of the GitHub Enterprise server, empty for github.com
+		Host string
+	}
+
+	// SSHAuth for git operations over ssh
+	SSHAuth struct {
+		// User defaults to "git"
+		User string
+		// PrivateKeyPath path to a private key file, when empty the ssh agent is used
+		PrivateKeyPath     string
+		PrivateKeyPassword string
+		// KnownHostsPaths files used to verify the host key, when empty the
+		// SSH_KNOWN_HOSTS env var or the default known_hosts files are used
+		KnownHostsPaths []string
 	}
 
 	CloneOptions struct {
@@ -68,18 +177,110 @@ type (
 		URL string
 		// Path where to clone to
 		Path string
+		// FS when set, the repository is cloned into memory with the worktree on
+		// FS (e.g. memfs.New()), and Path is ignored
+		FS   billy.Filesystem
 		Auth *Auth
+		// MultiAck requests the multi_ack capability over http(s), which azure
+		// devops requires. It is always requested from azure devops services
+		// urls.
+		MultiAck bool
+	}
+
+	CommitOptions struct {
+		Message string
+		// Author defaults to user.name and user.email from the git config
+		Author *Signature
+		// Committer defaults to Author
+		Committer *Signature
+		// Sign when set, the commit is signed with the key
+		Sign *SignOptions
+	}
+
+	// Signature identifies the author or committer of a commit
+	Signature struct {
+		Name  string
+		Email string
+	}
+
+	SignOptions struct {
+		// Format is one of SignFormatOpenPGP (default) or SignFormatSSH
+		Format string
+		// KeyPath path to an armored openpgp private key, or an ssh private key
+		KeyPath    string
+		Passphrase string
 	}
 
 	PushOptions struct {
 		RemoteName string
 		Auth       *Auth
+		// Branch the local branch to push to the remote branch with the same
+		// name, defaults to the checked out branch
+		Branch string
+		// RefSpecs when set, are pushed instead of Branch
+		RefSpecs []string
+	}
+
+	FetchOptions struct {
+		RemoteName string
+		Auth       *Auth
+		// Branch the remote branch to reset to, defaults to the checked out branch
+		Branch string
+		// MultiAck see CloneOptions.MultiAck, defaults to the value the
+		// repository was cloned with
+		MultiAck bool
+	}
+
+	PushRetryOptions struct {
+		Push *PushOptions
+		// Commit used to commit the changes again after they are replayed
+		Commit *CommitOptions
+		// Replay applies the changes again on top of the remote branch, after the
+		// push was rejected
+		Replay func(ctx context.Context) error
+		// Retries defaults to DefaultPushRetries
+		Retries int
+		// Backoff the wait before the first retry, doubled on each retry up to
+		// maxPushBackoff, defaults to DefaultPushBackoff
+		Backoff time.Duration
 	}
 
 	CreateRepoOptions struct {
-		Owner   string
-		Name    string
-		Private bool
+		Owner       string
+		Name        string
+		Private     bool
+		Description string
+		// DefaultBranch the branch that the repository HEAD points to, the first
+		// pushed branch is the default with most providers
+		DefaultBranch string
+		// Visibility overrides Private when set
+		Visibility RepoVisibility
+		// Teams are granted access to the repository, the owner must be an
+		// organization
+		Teams []*TeamAccess
+	}
+
+	RepoVisibility string
+
+	TeamAccess struct {
+		// Slug of the team in the organization
+		Slug string
+		// Permission one of "pull", "triage", "push", "maintain" or "admin"
+		Permission string
+	}
+
+	ProtectBranchOptions struct {
+		// RepoURL clone url of the repository
+		RepoURL string
+		Branch  string
+		// RequiredApprovals the number of approving reviews required to merge a
+		// pull request, when 0 pull requests are not required
+		RequiredApprovals       int
+		RequireCodeOwnerReviews bool
+		// RequiredStatusChecks contexts that must pass before merging
+		RequiredStatusChecks []string
+		// EnforceAdmins applies the rules to administrators too
+		EnforceAdmins bool
 	}
 
 	GetRepoOptions struct {
@@ -87,21 +288,156 @@ type (
 		Name  string
 	}
 
+	ValidateAccessOptions struct {
+		// RepoURL when set, push access to the existing repository is checked
+		RepoURL string
+		// Owner and Name of the repository to be created, when RepoURL is empty
+		Owner   string
+		Name    string
+		Private bool
+	}
+
+	WebhookOptions struct {
+		// RepoURL clone url of the repository
+		RepoURL string
+		// URL the payload url
+		URL string
+		// Secret used to sign the payload, or sent as a token by providers that
+		// do not sign it
+		Secret string
+	}
+
+	DeployKeyOptions struct {
+		// RepoURL clone url of the repository
+		RepoURL string
+		Title   string
+		// PublicKey in the authorized_keys format
+		PublicKey string
+		ReadOnly  bool
+	}
+
+	PullRequestOptions struct {
+		// RepoURL clone url of the repository
+		RepoURL string
+		// Head the branch with the changes
+		Head string
+		// Base the branch the changes are merged into
+		Base        string
+		Title       string
+		Description string
+	}
+
+	GetPullRequestOptions struct {
+		// RepoURL clone url of the repository
+		RepoURL string
+		ID      int
+	}
+
+	PullRequest struct {
+		ID  int
+		URL string
+		// Merged is true when the pull request is merged into the base branch
+		Merged bool
+		// Closed is true when the pull request was closed without being merged
+		Closed bool
+		// MergeCommit the sha of the commit added to the base branch, only set
+		// once the pull request is merged
+		MergeCommit string
+	}
+
+	// StatusCode the state of a file in the index or the worktree
+	StatusCode string
+
+	FileStatus struct {
+		Path     string
+		Staging  StatusCode
+		Worktree StatusCode
+	}
+
+	FileDiff struct {
+		// Path the path in the to revision, or in the from revision when the file
+		// was deleted
+		Path string
+		// Action one of StatusAdded, StatusModified or StatusDeleted
+		Action StatusCode
+		// Patch the unified diff of the file
+		Patch string
+	}
+
+	CommitInfo struct {
+		SHA       string
+		Message   string
+		Author    Signature
+		Committer Signature
+		When      time.Time
+	}
+
 	repo struct {
 		r *gg.Repository
+		// multiAck the repository was cloned with CloneOptions.MultiAck
+		multiAck bool
 	}
 )
 
+// Repository visibility
+const (
+	RepoVisibilityPublic   RepoVisibility = "public"
+	RepoVisibilityPrivate  RepoVisibility = "private"
+	RepoVisibilityInternal RepoVisibility = "internal"
+)
+
+// File status codes
+const (
+	StatusUnmodified         StatusCode = "unmodified"
+	StatusUntracked          StatusCode = "untracked"
+	StatusModified           StatusCode = "modified"
+	StatusAdded              StatusCode = "added"
+	StatusDeleted            StatusCode = "deleted"
+	StatusRenamed            StatusCode = "renamed"
+	StatusCopied             StatusCode = "copied"
+	StatusUpdatedButUnmerged StatusCode = "updated-but-unmerged"
+)
+
+var statusCodes = map[gg.StatusCode]StatusCode{
+	gg.Unmodified:         StatusUnmodified,
+	gg.Untracked:          StatusUntracked,
+	gg.Modified:           StatusModified,
+	gg.Added:              StatusAdded,
+	gg.Deleted:            StatusDeleted,
+	gg.Renamed:            StatusRenamed,
+	gg.Copied:             StatusCopied,
+	gg.UpdatedButUnmerged: StatusUpdatedButUnmerged,
+}
+
 // Errors
 var (
 	ErrProviderNotSupported = errors.New("git provider not supported")
 	ErrRepoNotFound         = errors.New("git repository not found")
+	ErrHostRequired         = errors.New("git provider requires a host")
+
+	ErrPullRequestsNotSupported = errors.New("git provider does not support pull requests")
+	ErrPushRejected             = errors.New("push rejected, the remote branch has new commits")
+	ErrDeployKeysNotSupported   = errors.New("git provider does not support deploy keys")
+	ErrAccessDenied             = errors.New("git access denied")
+)
+
+// rejectedRefStatuses the ref statuses of git-receive-pack when the remote
+// branch has new commits. "non-fast-forward" when the server checks it, and
+// "failed to update ref" when the branch was updated after it was advertised.
+var rejectedRefStatuses = []string{"non-fast-forward", "failed to update ref"}
+
+const (
+	DefaultPushRetries = 5
+	DefaultPushBackoff = time.Second
+	maxPushBackoff     = time.Second * 30
 )
 
 // go-git functions (we mock those in tests)
 var (
-	plainClone = gg.PlainCloneContext
-	plainInit  = gg.PlainInit
+	plainClone            = gg.PlainCloneContext
+	plainInit             = gg.PlainInit
+	newSSHAgentAuth       = ssh.NewSSHAgentAuth
+	newKnownHostsCallback = ssh.NewKnownHostsCallback
 )
 
 // New creates a new git provider
@@ -109,18 +445,65 @@ func NewProvider(opts *Options) (Provider, error) {
 	switch opts.Type {
 	case "github":
 		return newGithub(opts)
+	case "gitlab":
+		return newGitlab(opts)
+	case "bitbucket":
+		return newBitbucket(opts)
+	case "bitbucket-server":
+		return newBitbucketServer(opts)
+	case "gitea":
+		return newGitea(opts)
+	case "azure":
+		return newAzure(opts)
+	case "file":
+		return newFile(opts)
 	default:
 		return nil, ErrProviderNotSupported
 	}
 }
 
-func getRef(cloneURL string) string {
-	u, err := url.Parse(cloneURL)
-	if err != nil {
-		return ""
+// SSHRepoURL returns the ssh url of the repository owned by owner with the
+// specified name, as it would be hosted by the git provider described by opts
+func SSHRepoURL(opts *Options, owner, name string) (string, error) {
+	switch opts.Type {
+	case "github":
+		return githubSSHRepoURL(opts.Host, owner, name)
+	case "gitlab":
+		return gitlabSSHRepoURL(opts.Host, owner, name)
+	case "bitbucket":
+		return bitbucketSSHRepoURL(owner, name), nil
+	case "bitbucket-server":
+		return bitbucketServerSSHRepoURL(opts.Host, owner, name)
+	case "gitea":
+		return giteaSSHRepoURL(opts.Host, owner, name)
+	case "azure":
+		return azureSSHRepoURL(opts.Host, owner, name)
+	default:
+		return "", ErrProviderNotSupported
 	}
+}
 
-	return u.Fragment
+// RepoURL returns the url of the repository owned by owner with the specified
+// name, as it would be hosted by the git provider described by opts
+func RepoURL(opts *Options, owner, name string) (string, error) {
+	switch opts.Type {
+	case "github":
+		return githubRepoURL(opts.Host, owner, name), nil
+	case "gitlab":
+		return gitlabRepoURL(opts.Host, owner, name), nil
+	case "bitbucket":
+		return bitbucketRepoURL(owner, name), nil
+	case "bitbucket-server":
+		return bitbucketServerRepoURL(opts.Host, owner, name)
+	case "gitea":
+		return giteaRepoURL(opts.Host, owner, name)
+	case "azure":
+		return azureRepoURL(opts.Host, owner, name)
+	case "file":
+		return fileRepoURL(opts.Host, owner, name)
+	default:
+		return "", ErrProviderNotSupported
+	}
 }
 
 func Clone(ctx context.Context, opts *CloneOptions) (Repository, error) {
@@ -128,21 +511,39 @@ func Clone(ctx context.Context, opts *CloneOptions) (Repository, error) {
 		return nil, cferrors.ErrNilOpts
 	}
 
-	auth := getAuth(opts.Auth)
+	ref, err := ParseRef(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := getAuth(ctx, opts.Auth, ref.URL)
+	if err != nil {
+		return nil, err
+	}
 
 	cloneOpts := &gg.CloneOptions{
 		Depth:    1,
-		URL:      opts.URL,
+		URL:      ref.URL,
 		Auth:     auth,
 		Progress: os.Stderr,
 	}
 
-	if ref := getRef(opts.URL); ref != "" {
-		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(ref)
-		cloneOpts.URL = opts.URL[:strings.LastIndex(opts.URL, ref)-1]
-	} else if i := strings.LastIndex(opts.URL, "@"); i > -1 {
-		cloneOpts.ReferenceName = plumbing.NewTagReferenceName(opts.URL[i+1:])
-		cloneOpts.URL = opts.URL[:i]
+	switch ref.Type {
+	case RefTypeBranch:
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(ref.Name)
+	case RefTypeTag:
+		cloneOpts.ReferenceName = plumbing.NewTagReferenceName(ref.Name)
+	case RefTypeSHA:
+		// a commit can not be cloned directly, fetch the full history and
+		// checkout the commit after the clone
+		cloneOpts.Depth = 0
+		cloneOpts.NoCheckout = true
+	}
+
+	// the in-process file transport (see fileTransport) does not support
+	// shallow clones
+	if ep, err := transport.NewEndpoint(cloneOpts.URL); err == nil && ep.Protocol == "file" {
+		cloneOpts.Depth = 0
 	}
 
 	log.G(ctx).WithFields(log.Fields{
@@ -151,20 +552,265 @@ func Clone(ctx context.Context, opts *CloneOptions) (Repository, error) {
 		"ref":  cloneOpts.ReferenceName,
 	}).Debug("cloning repo")
 
-	err := cloneOpts.Validate()
+	err = cloneOpts.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	multiAck := opts.MultiAck || isAzureURL(ref.URL)
+	if multiAck {
+		ctx = multiAckContext(ctx)
+	}
+
+	var r *gg.Repository
+	if opts.FS != nil {
+		r, err = gg.CloneContext(ctx, memory.NewStorage(), opts.FS, cloneOpts)
+	} else {
+		r, err = plainClone(ctx, opts.Path, false, cloneOpts)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := plainClone(ctx, opts.Path, false, cloneOpts)
+	if ref.Type == RefTypeSHA {
+		if err = checkoutSHA(ctx, r, ref.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &repo{r: r, multiAck: multiAck}, nil
+}
+
+// checkoutSHA checks out a detached HEAD at the commit, the sha may be
+// abbreviated. When there is no such commit, the remote branch with that name
+// is checked out, since a branch name can look like a sha.
+func checkoutSHA(ctx context.Context, r *gg.Repository, sha string) error {
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	h, err := r.ResolveRevision(plumbing.Revision(sha))
+	if err != nil {
+		remoteRef, rerr := r.Reference(plumbing.NewRemoteReferenceName(gg.DefaultRemoteName, sha), true)
+		if rerr != nil {
+			return fmt.Errorf("commit or branch not found: %s: %w", sha, err)
+		}
+
+		branch := plumbing.NewBranchReferenceName(sha)
+		if err = r.Storer.SetReference(plumbing.NewHashReference(branch, remoteRef.Hash())); err != nil {
+			return err
+		}
+
+		log.G(ctx).WithField("branch", sha).Debug("no such commit, checking out branch")
+
+		return wt.Checkout(&gg.CheckoutOptions{Branch: branch})
+	}
+
+	if err = wt.Checkout(&gg.CheckoutOptions{Hash: *h}); err != nil {
+		return err
+	}
+
+	log.G(ctx).WithField("sha", h.String()).Debug("checked out commit")
+
+	return nil
+}
+
+// accessDenied returns an ErrAccessDenied error with the reason
+func accessDenied(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrAccessDenied, fmt.Sprintf(format, a...))
+}
+
+// WaitForMerge polls the provider until the pull request is merged, and returns
+// the merged pull request. It fails if the pull request is closed without being
+// merged.
+func WaitForMerge(ctx context.Context, p Provider, opts *GetPullRequestOptions, interval time.Duration) (*PullRequest, error) {
+	for {
+		pr, err := p.GetPullRequest(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if pr.Merged {
+			return pr, nil
+		}
+
+		if pr.Closed {
+			return nil, fmt.Errorf("pull request closed without being merged: %s", pr.URL)
+		}
+
+		log.G(ctx).WithField("url", pr.URL).Debug("pull request not merged yet")
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// PushWithRetry pushes the checked out branch. When the push is rejected since
+// the remote branch has new commits, the local branch is reset to the remote
+// branch, the changes are replayed and committed again, and the push is retried
+// with a bounded backoff. It returns the sha of the last replayed commit, or
+// an empty sha when the changes were pushed without a replay.
+func PushWithRetry(ctx context.Context, r Repository, opts *PushRetryOptions) (string, error) {
+	if opts == nil || opts.Push == nil {
+		return "", cferrors.ErrNilOpts
+	}
+
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultPushRetries
+	}
+
+	backoff := opts.Backoff
+	if backoff == 0 {
+		backoff = DefaultPushBackoff
+	}
+
+	sha := ""
+	for i := 0; ; i++ {
+		err := r.Push(ctx, opts.Push)
+		if err == nil || !errors.Is(err, ErrPushRejected) {
+			return sha, err
+		}
+
+		if i == retries {
+			return "", fmt.Errorf("failed to push after %d retries: %w", retries, err)
+		}
+
+		log.G(ctx).WithField("retry", i+1).Warnf("push rejected, retrying in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > maxPushBackoff {
+			backoff = maxPushBackoff
+		}
+
+		if sha, err = replay(ctx, r, opts); err != nil {
+			return "", err
+		}
+	}
+}
+
+func replay(ctx context.Context, r Repository, opts *PushRetryOptions) (string, error) {
+	err := r.ResetToRemote(ctx, &FetchOptions{
+		RemoteName: opts.Push.RemoteName,
+		Auth:       opts.Push.Auth,
+		Branch:     opts.Push.Branch,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err = opts.Replay(ctx); err != nil {
+		return "", fmt.Errorf("failed to replay changes: %w", err)
+	}
+
+	if err = r.Add(ctx, "."); err != nil {
+		return "", err
+	}
+
+	return r.Commit(ctx, opts.Commit)
+}
+
+// repoPathOf returns the path of the repository in the clone url, without the
+// leading "/" and the ".git" suffix, e.g. "owner/name"
+func repoPathOf(cloneURL string) (string, error) {
+	ref, err := ParseRef(cloneURL)
+	if err != nil {
+		return "", err
+	}
+
+	cloneURL = ref.URL
+
+	var p string
+	if !strings.Contains(cloneURL, "://") && strings.Contains(cloneURL, ":") {
+		// scp-like ssh url: git@host:owner/name.git
+		p = cloneURL[strings.Index(cloneURL, ":")+1:]
+	} else {
+		u, err := url.Parse(cloneURL)
+		if err != nil {
+			return "", err
+		}
+		p = u.Path
+	}
+
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if p == "" {
+		return "", fmt.Errorf("failed to get repository path from url: %s", cloneURL)
+	}
+
+	return p, nil
+}
+
+// splitRepoPath returns the owner and name of the repository in the clone url,
+// from the last two elements of the path
+func splitRepoPath(cloneURL string) (string, string, error) {
+	p, err := repoPathOf(cloneURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.Split(p, "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("failed to get repository owner and name from url: %s", cloneURL)
+	}
+
+	return parts[len(parts)-2], parts[len(parts)-1], nil
+}
+
+// hostnameOf returns the hostname of a provider host url
+func hostnameOf(host string) (string, error) {
+	if host == "" {
+		return "", ErrHostRequired
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Hostname(), nil
+}
+
+// cloneRepository clones the repository of opts.URL into a new temp dir, or
+// into memory, opts.Path and opts.FS are ignored
+func cloneRepository(ctx context.Context, opts *CloneOptions, inMemory bool) (Repository, error) {
+	cloneOpts := *opts
+	if inMemory {
+		log.G(ctx).Printf("cloning existing gitops repository...")
+
+		cloneOpts.FS = memfs.New()
+		return Clone(ctx, &cloneOpts)
+	}
+
+	log.G(ctx).Debug("creating temp dir for gitops repo")
+	clonePath, err := ioutil.TempDir("", "repo-")
 	if err != nil {
 		return nil, err
 	}
+	log.G(ctx).WithField("location", clonePath).Debug("temp dir created")
+
+	log.G(ctx).Printf("cloning existing gitops repository...")
 
-	return &repo{r}, nil
+	cloneOpts.FS = nil
+	cloneOpts.Path = clonePath
+	return Clone(ctx, &cloneOpts)
 }
 
 func Init(ctx context.Context, path string) (Repository, error) {
+	return InitBranch(ctx, path, "")
+}
+
+// InitBranch initializes a new local repository, with the initial branch (the
+// unborn HEAD) named branch, the go-git default when empty
+func InitBranch(ctx context.Context, path, branch string) (Repository, error) {
 	if path == "" {
 		path = "."
 	}
@@ -178,9 +824,40 @@ func Init(ctx context.Context, path string) (Repository, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if err = setInitialBranch(r, branch); err != nil {
+		return nil, err
+	}
 	l.Debug("local repository initiallized")
 
-	return &repo{r}, err
+	return &repo{r: r}, err
+}
+
+// InitMemory initializes a new repository in memory, with the worktree on fs
+// (e.g. memfs.New()), and the initial branch named branch, the go-git default
+// when empty
+func InitMemory(ctx context.Context, fs billy.Filesystem, branch string) (Repository, error) {
+	r, err := gg.Init(memory.NewStorage(), fs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = setInitialBranch(r, branch); err != nil {
+		return nil, err
+	}
+	log.G(ctx).Debug("in-memory repository initiallized")
+
+	return &repo{r: r}, nil
+}
+
+// setInitialBranch points the unborn HEAD to branch, when not empty
+func setInitialBranch(r *gg.Repository, branch string) error {
+	if branch == "" {
+		return nil
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+	return r.Storer.SetReference(head)
 }
 
 func (r *repo) Add(ctx context.Context, pattern string) error {
@@ -214,21 +891,59 @@ func (r *repo) AddRemote(ctx context.Context, name, url string) error {
 	return nil
 }
 
-func (r *repo) Commit(ctx context.Context, msg string) (string, error) {
+func (r *repo) Commit(ctx context.Context, opts *CommitOptions) (string, error) {
+	if opts == nil {
+		return "", cferrors.ErrNilOpts
+	}
+
 	wt, err := r.r.Worktree()
 	if err != nil {
 		return "", err
 	}
 
-	h, err := wt.Commit(msg, &gg.CommitOptions{
+	commitOpts := &gg.CommitOptions{
 		All: true,
-	})
+	}
+
+	now := time.Now()
+	if opts.Author != nil {
+		commitOpts.Author = &object.Signature{Name: opts.Author.Name, Email: opts.Author.Email, When: now}
+	}
+	if opts.Committer != nil {
+		commitOpts.Committer = &object.Signature{Name: opts.Committer.Name, Email: opts.Committer.Email, When: now}
+	}
+
+	var sshSigner gossh.Signer
+	if opts.Sign != nil {
+		switch opts.Sign.Format {
+		case "", SignFormatOpenPGP:
+			commitOpts.SignKey, err = loadOpenPGPKey(opts.Sign.KeyPath, opts.Sign.Passphrase)
+		case SignFormatSSH:
+			sshSigner, err = loadSSHSigner(opts.Sign.KeyPath, opts.Sign.Passphrase)
+		default:
+			err = fmt.Errorf("unknown signing format: %s", opts.Sign.Format)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	h, err := wt.Commit(opts.Message, commitOpts)
 	if err != nil {
 		return "", err
 	}
+
+	if sshSigner != nil {
+		// go-git only signs with openpgp keys
+		if h, err = r.sshSignCommit(h, sshSigner); err != nil {
+			return "", err
+		}
+	}
+
 	log.G(ctx).WithFields(log.Fields{
-		"sha": h.String(),
-		"msg": msg,
+		"sha":    h.String(),
+		"msg":    opts.Message,
+		"signed": opts.Sign != nil,
 	}).Debug("created new commit")
 
 	return h.String(), err
@@ -239,24 +954,52 @@ func (r *repo) Push(ctx context.Context, opts *PushOptions) error {
 		return cferrors.ErrNilOpts
 	}
 
-	auth := getAuth(opts.Auth)
+	auth, err := getAuth(ctx, opts.Auth, r.remoteURL(opts.RemoteName))
+	if err != nil {
+		return err
+	}
+
 	pushOpts := &gg.PushOptions{
 		RemoteName: opts.RemoteName,
 		Auth:       auth,
 		Progress:   os.Stdout,
 	}
-	err := pushOpts.Validate()
+
+	// push only a single branch by default, other local branches might be
+	// behind the remote
+	for _, rs := range opts.RefSpecs {
+		pushOpts.RefSpecs = append(pushOpts.RefSpecs, config.RefSpec(rs))
+	}
+
+	if len(pushOpts.RefSpecs) == 0 {
+		branch := opts.Branch
+		if branch == "" {
+			branch, err = r.CurrentBranch()
+			if err != nil {
+				return err
+			}
+		}
+
+		ref := plumbing.NewBranchReferenceName(branch)
+		pushOpts.RefSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", ref, ref))}
+	}
+
+	err = pushOpts.Validate()
 	if err != nil {
 		return err
 	}
 
 	l := log.G(ctx).WithFields(log.Fields{
-		"remote": pushOpts.RemoteName,
+		"remote":   pushOpts.RemoteName,
+		"refspecs": pushOpts.RefSpecs,
 	})
 	l.Debug("pushing to repo")
 
 	err = r.r.PushContext(ctx, pushOpts)
 	if err != nil {
+		if isPushRejected(err) {
+			return fmt.Errorf("%w: %s", ErrPushRejected, err)
+		}
 		return err
 	}
 
@@ -264,6 +1007,99 @@ func (r *repo) Push(ctx context.Context, opts *PushOptions) error {
 	return nil
 }
 
+// isPushRejected returns true if the push failed since the remote branch is
+// ahead of the local branch, either checked by go-git before pushing or
+// reported by the server in the status of the ref. go-git reports both as
+// formatted errors, ref names cannot contain ':'.
+func isPushRejected(err error) bool {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "non-fast-forward update: ") {
+		return true
+	}
+
+	if !strings.HasPrefix(msg, "command error on ") {
+		return false
+	}
+
+	status := msg[strings.Index(msg, ": ")+2:]
+	for _, s := range rejectedRefStatuses {
+		if status == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *repo) ResetToRemote(ctx context.Context, opts *FetchOptions) error {
+	if opts == nil {
+		return cferrors.ErrNilOpts
+	}
+
+	auth, err := getAuth(ctx, opts.Auth, r.remoteURL(opts.RemoteName))
+	if err != nil {
+		return err
+	}
+
+	remoteName := opts.RemoteName
+	if remoteName == "" {
+		remoteName = gg.DefaultRemoteName
+	}
+
+	branch := opts.Branch
+	if branch == "" {
+		if branch, err = r.CurrentBranch(); err != nil {
+			return err
+		}
+	}
+
+	remoteRef := plumbing.NewRemoteReferenceName(remoteName, branch)
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remoteRef))
+
+	l := log.G(ctx).WithFields(log.Fields{
+		"remote": remoteName,
+		"branch": branch,
+	})
+	l.Debug("fetching from repo")
+
+	if opts.MultiAck || r.multiAck || isAzureURL(r.remoteURL(remoteName)) {
+		ctx = multiAckContext(ctx)
+	}
+
+	err = r.r.FetchContext(ctx, &gg.FetchOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       auth,
+		Force:      true,
+	})
+	if err != nil && err != gg.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	ref, err := r.r.Reference(remoteRef, true)
+	if err != nil {
+		return err
+	}
+
+	wt, err := r.r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	if err = wt.Reset(&gg.ResetOptions{Commit: ref.Hash(), Mode: gg.HardReset}); err != nil {
+		return err
+	}
+
+	// remove the files that were added by the discarded commits
+	if err = wt.Clean(&gg.CleanOptions{Dir: true}); err != nil {
+		return err
+	}
+
+	l.WithField("sha", ref.Hash().String()).Debug("reset to remote branch")
+
+	return nil
+}
+
 func (r *repo) IsNewRepo() (bool, error) {
 	remotes, err := r.r.Remotes()
 	if err != nil {
@@ -282,17 +1118,299 @@ func (r *repo) Root() (string, error) {
 	return wt.Filesystem.Root(), nil
 }
 
-func getAuth(auth *Auth) transport.AuthMethod {
-	if auth != nil {
-		username := auth.Username
-		if username == "" {
-			username = "codefresh"
+func (r *repo) Filesystem() (billy.Filesystem, error) {
+	wt, err := r.r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	return wt.Filesystem, nil
+}
+
+func (r *repo) CreateBranch(ctx context.Context, name string) error {
+	wt, err := r.r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	err = wt.Checkout(&gg.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(name),
+		Create: true,
+		Keep:   true,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.G(ctx).WithField("branch", name).Debug("created new branch")
+
+	return nil
+}
+
+// Checkout checks out an existing branch, a local branch is created from the
+// remote branch if needed
+func (r *repo) Checkout(ctx context.Context, branch string) error {
+	wt, err := r.r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	checkoutOpts := &gg.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+	}
+
+	_, err = r.r.Reference(checkoutOpts.Branch, false)
+	if err == plumbing.ErrReferenceNotFound {
+		remoteRef, err := r.r.Reference(plumbing.NewRemoteReferenceName(gg.DefaultRemoteName, branch), true)
+		if err != nil {
+			return fmt.Errorf("branch not found: %s: %w", branch, err)
+		}
+
+		checkoutOpts.Hash = remoteRef.Hash()
+		checkoutOpts.Create = true
+	} else if err != nil {
+		return err
+	}
+
+	if err = wt.Checkout(checkoutOpts); err != nil {
+		return err
+	}
+
+	log.G(ctx).WithField("branch", branch).Debug("checked out branch")
+
+	return nil
+}
+
+func (r *repo) CurrentBranch() (string, error) {
+	head, err := r.r.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if !head.Name().IsBranch() {
+		return "", fmt.Errorf("HEAD is not a branch: %s", head.Hash())
+	}
+
+	return head.Name().Short(), nil
+}
+
+func (r *repo) Status() ([]*FileStatus, error) {
+	wt, err := r.r.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*FileStatus, 0, len(status))
+	for path, s := range status {
+		res = append(res, &FileStatus{
+			Path:     path,
+			Staging:  statusCodes[s.Staging],
+			Worktree: statusCodes[s.Worktree],
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+
+	return res, nil
+}
+
+func (r *repo) Diff(from, to string) ([]*FileDiff, error) {
+	fromTree, err := r.revisionTree(from)
+	if err != nil {
+		return nil, err
+	}
+
+	if to == "" {
+		to = plumbing.HEAD.String()
+	}
+
+	toTree, err := r.revisionTree(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*FileDiff, 0, len(changes))
+	for _, c := range changes {
+		action, err := c.Action()
+		if err != nil {
+			return nil, err
+		}
+
+		patch, err := c.Patch()
+		if err != nil {
+			return nil, err
+		}
+
+		d := &FileDiff{
+			Path:   c.To.Name,
+			Action: StatusModified,
+			Patch:  patch.String(),
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			d.Action = StatusAdded
+		case merkletrie.Delete:
+			d.Path = c.From.Name
+			d.Action = StatusDeleted
+		}
+
+		res = append(res, d)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Path < res[j].Path })
+
+	return res, nil
+}
+
+// revisionTree returns the tree of the revision, or an empty tree if the
+// revision is empty
+func (r *repo) revisionTree(rev string) (*object.Tree, error) {
+	if rev == "" {
+		return &object.Tree{}, nil
+	}
+
+	h, err := r.r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve revision %s: %w", rev, err)
+	}
+
+	c, err := r.r.CommitObject(*h)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Tree()
+}
+
+func (r *repo) Log(path string) ([]*CommitInfo, error) {
+	logOpts := &gg.LogOptions{}
+	if path = strings.Trim(filepath.ToSlash(path), "/"); path != "" {
+		logOpts.PathFilter = func(p string) bool {
+			return p == path || strings.HasPrefix(p, path+"/")
+		}
+	}
+
+	iter, err := r.r.Log(logOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	res := []*CommitInfo{}
+	err = iter.ForEach(func(c *object.Commit) error {
+		res = append(res, &CommitInfo{
+			SHA:       c.Hash.String(),
+			Message:   c.Message,
+			Author:    Signature{Name: c.Author.Name, Email: c.Author.Email},
+			Committer: Signature{Name: c.Committer.Name, Email: c.Committer.Email},
+			When:      c.Author.When,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// remoteURL returns the first url of the remote, or "" if there is no such
+// remote. An empty name defaults to "origin"
+func (r *repo) remoteURL(name string) string {
+	if name == "" {
+		name = gg.DefaultRemoteName
+	}
+
+	remote, err := r.r.Remote(name)
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+
+	return remote.Config().URLs[0]
+}
+
+// getAuth returns the auth method of git operations on the repository url.
+// Without an explicit token, ssh or github app auth, the credentials are looked
+// up by the host of the url (see LookupCredentials)
+func getAuth(ctx context.Context, auth *Auth, repoURL string) (transport.AuthMethod, error) {
+	if auth == nil || (auth.SSH == nil && auth.GithubApp == nil && auth.Password == "") {
+		creds, err := LookupCredentials(ctx, repoURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if creds != nil {
+			auth = creds
+		}
+	}
+
+	if auth == nil {
+		return nil, nil
+	}
+
+	if auth.SSH != nil {
+		return getSSHAuth(auth.SSH)
+	}
+
+	if auth.GithubApp != nil {
+		token, err := GithubAppInstallationToken(ctx, auth.GithubApp)
+		if err != nil {
+			return nil, err
 		}
+
 		return &http.BasicAuth{
-			Username: username,
-			Password: auth.Password,
+			Username: githubAppTokenUsername,
+			Password: token,
+		}, nil
+	}
+
+	username := auth.Username
+	if username == "" {
+		username = "codefresh"
+	}
+	return &http.BasicAuth{
+		Username: username,
+		Password: auth.Password,
+	}, nil
+}
+
+func getSSHAuth(auth *SSHAuth) (transport.AuthMethod, error) {
+	user := auth.User
+	if user == "" {
+		user = ssh.DefaultUsername
+	}
+
+	hostKeyCallback, err := newKnownHostsCallback(auth.KnownHostsPaths...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+
+	if auth.PrivateKeyPath == "" {
+		agentAuth, err := newSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
 		}
+
+		agentAuth.HostKeyCallback = hostKeyCallback
+		return agentAuth, nil
 	}
 
-	return nil
+	keysAuth, err := ssh.NewPublicKeysFromFile(user, auth.PrivateKeyPath, auth.PrivateKeyPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	keysAuth.HostKeyCallback = hostKeyCallback
+	return keysAuth, nil
 }
@@ -2,12 +2,31 @@ package git
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	gg "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,6 +43,57 @@ func Test_NewProvider(t *testing.T) {
 			&github{},
 			"",
 		},
+		"Gitlab": {
+			&Options{
+				Type: "gitlab",
+			},
+			&gitlab{},
+			"",
+		},
+		"Bitbucket": {
+			&Options{
+				Type: "bitbucket",
+			},
+			&bitbucket{},
+			"",
+		},
+		"Bitbucket Server": {
+			&Options{
+				Type: "bitbucket-server",
+				Host: "https://bitbucket.example.com",
+			},
+			&bitbucket{},
+			"",
+		},
+		"Bitbucket Server without host": {
+			&Options{
+				Type: "bitbucket-server",
+			},
+			nil,
+			ErrHostRequired.Error(),
+		},
+		"Gitea": {
+			&Options{
+				Type: "gitea",
+				Host: "https://gitea.example.com",
+			},
+			&gitea{},
+			"",
+		},
+		"Gitea without host": {
+			&Options{
+				Type: "gitea",
+			},
+			nil,
+			ErrHostRequired.Error(),
+		},
+		"Azure": {
+			&Options{
+				Type: "azure",
+			},
+			&azure{},
+			"",
+		},
 		"No Type": {
 			&Options{},
 			nil,
@@ -48,6 +118,153 @@ func Test_NewProvider(t *testing.T) {
 	}
 }
 
+func Test_RepoURL(t *testing.T) {
+	tests := map[string]struct {
+		opts          *Options
+		owner         string
+		expectedURL   string
+		expectedError string
+	}{
+		"Github": {
+			opts:        &Options{Type: "github"},
+			expectedURL: "https://github.com/foo/bar",
+		},
+		"Github Enterprise": {
+			opts:        &Options{Type: "github", Host: "https://github.example.com/"},
+			expectedURL: "https://github.example.com/foo/bar",
+		},
+		"Github Enterprise api url": {
+			opts:        &Options{Type: "github", Host: "https://github.example.com/api/v3"},
+			expectedURL: "https://github.example.com/foo/bar",
+		},
+		"Gitlab": {
+			opts:        &Options{Type: "gitlab"},
+			expectedURL: "https://gitlab.com/foo/bar",
+		},
+		"Gitlab self managed": {
+			opts:        &Options{Type: "gitlab", Host: "https://gitlab.example.com/api/v4/"},
+			expectedURL: "https://gitlab.example.com/foo/bar",
+		},
+		"Bitbucket": {
+			opts:        &Options{Type: "bitbucket"},
+			expectedURL: "https://bitbucket.org/foo/bar.git",
+		},
+		"Bitbucket Server": {
+			opts:        &Options{Type: "bitbucket-server", Host: "https://bitbucket.example.com/"},
+			expectedURL: "https://bitbucket.example.com/scm/foo/bar.git",
+		},
+		"Bitbucket Server without host": {
+			opts:          &Options{Type: "bitbucket-server"},
+			expectedError: ErrHostRequired.Error(),
+		},
+		"Gitea": {
+			opts:        &Options{Type: "gitea", Host: "https://gitea.example.com"},
+			expectedURL: "https://gitea.example.com/foo/bar.git",
+		},
+		"Azure": {
+			opts:        &Options{Type: "azure"},
+			owner:       "foo/proj",
+			expectedURL: "https://dev.azure.com/foo/proj/_git/bar",
+		},
+		"Azure bad owner": {
+			opts:          &Options{Type: "azure"},
+			expectedError: "azure owner must be in the form of \"organization/project\", got: \"foo\"",
+		},
+		"Bad Type": {
+			opts:          &Options{Type: "foo"},
+			expectedError: ErrProviderNotSupported.Error(),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			owner := test.owner
+			if owner == "" {
+				owner = "foo"
+			}
+			url, err := RepoURL(test.opts, owner, "bar")
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_SSHRepoURL(t *testing.T) {
+	tests := map[string]struct {
+		opts          *Options
+		owner         string
+		expectedURL   string
+		expectedError string
+	}{
+		"Github": {
+			opts:        &Options{Type: "github"},
+			expectedURL: "git@github.com:foo/bar.git",
+		},
+		"Github Enterprise": {
+			opts:        &Options{Type: "github", Host: "https://github.example.com/api/v3/"},
+			expectedURL: "git@github.example.com:foo/bar.git",
+		},
+		"Gitlab": {
+			opts:        &Options{Type: "gitlab"},
+			expectedURL: "git@gitlab.com:foo/bar.git",
+		},
+		"Gitlab self managed": {
+			opts:        &Options{Type: "gitlab", Host: "https://gitlab.example.com:8443"},
+			expectedURL: "git@gitlab.example.com:foo/bar.git",
+		},
+		"Bitbucket": {
+			opts:        &Options{Type: "bitbucket"},
+			owner:       "foo/PROJ",
+			expectedURL: "git@bitbucket.org:foo/bar.git",
+		},
+		"Bitbucket Server": {
+			opts:        &Options{Type: "bitbucket-server", Host: "https://bitbucket.example.com"},
+			owner:       "PROJ",
+			expectedURL: "ssh://git@bitbucket.example.com:7999/proj/bar.git",
+		},
+		"Gitea": {
+			opts:        &Options{Type: "gitea", Host: "https://gitea.example.com:3000"},
+			expectedURL: "git@gitea.example.com:foo/bar.git",
+		},
+		"Gitea without host": {
+			opts:          &Options{Type: "gitea"},
+			expectedError: ErrHostRequired.Error(),
+		},
+		"Azure": {
+			opts:        &Options{Type: "azure"},
+			owner:       "foo/proj",
+			expectedURL: "git@ssh.dev.azure.com:v3/foo/proj/bar",
+		},
+		"Azure Server": {
+			opts:        &Options{Type: "azure", Host: "https://azure.example.com"},
+			owner:       "foo/proj",
+			expectedURL: "ssh://azure.example.com:22/foo/proj/_git/bar",
+		},
+		"Bad Type": {
+			opts:          &Options{Type: "foo"},
+			expectedError: ErrProviderNotSupported.Error(),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			owner := test.owner
+			if owner == "" {
+				owner = "foo"
+			}
+			url, err := SSHRepoURL(test.opts, owner, "bar")
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
 func Test_Clone(t *testing.T) {
 	tests := map[string]struct {
 		opts             *CloneOptions
@@ -86,6 +303,36 @@ func Test_Clone(t *testing.T) {
 			expectedURL:     "https://github.com/foo/bar",
 			expectedRefName: plumbing.NewBranchReferenceName("branch"),
 		},
+		"With ssh url": {
+			opts: &CloneOptions{
+				Path: "/foo/bar",
+				URL:  "git@github.com:foo/bar.git",
+				Auth: nil,
+			},
+			expectedPath:    "/foo/bar",
+			expectedURL:     "git@github.com:foo/bar.git",
+			expectedRefName: plumbing.HEAD,
+		},
+		"With ssh url and tag": {
+			opts: &CloneOptions{
+				Path: "/foo/bar",
+				URL:  "git@github.com:foo/bar.git@tag",
+				Auth: nil,
+			},
+			expectedPath:    "/foo/bar",
+			expectedURL:     "git@github.com:foo/bar.git",
+			expectedRefName: plumbing.NewTagReferenceName("tag"),
+		},
+		"With ssh url and branch": {
+			opts: &CloneOptions{
+				Path: "/foo/bar",
+				URL:  "git@github.com:foo/bar.git#branch",
+				Auth: nil,
+			},
+			expectedPath:    "/foo/bar",
+			expectedURL:     "git@github.com:foo/bar.git",
+			expectedRefName: plumbing.NewBranchReferenceName("branch"),
+		},
 		"With token": {
 			opts: &CloneOptions{
 				Path: "/foo/bar",
@@ -103,6 +350,11 @@ func Test_Clone(t *testing.T) {
 
 	orig := plainClone
 	defer func() { plainClone = orig }()
+	origFill := gitCredentialFill
+	defer func() { gitCredentialFill = origFill }()
+	gitCredentialFill = func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("no credential helper")
+	}
 
 	for name, test := range tests {
 		plainClone = func(ctx context.Context, path string, isBare bool, o *gg.CloneOptions) (*gg.Repository, error) {
@@ -123,3 +375,784 @@ func Test_Clone(t *testing.T) {
 		})
 	}
 }
+
+func Test_Clone_sha(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	r, err := Init(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	sha, err := r.Commit(ctx, &CommitOptions{Message: "a"})
+	assert.NoError(t, err)
+	commitFile(ctx, t, r, dir, "b")
+	assert.NoError(t, r.AddRemote(ctx, "origin", cloneURL))
+	assert.NoError(t, r.Push(ctx, &PushOptions{}))
+
+	for _, ref := range []string{sha, sha[:7]} {
+		clonePath, err := ioutil.TempDir("", "clone-")
+		assert.NoError(t, err)
+		defer os.RemoveAll(clonePath)
+
+		_, err = Clone(ctx, &CloneOptions{URL: cloneURL + "#" + ref, Path: clonePath})
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(clonePath, "a"))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(clonePath, "b"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	clonePath, err := ioutil.TempDir("", "clone-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(clonePath)
+	_, err = Clone(ctx, &CloneOptions{URL: cloneURL + "#0000000", Path: clonePath})
+	assert.EqualError(t, err, "commit or branch not found: 0000000: reference not found")
+
+	// a branch that looks like a sha, without such a commit
+	assert.NoError(t, r.CreateBranch(ctx, "deadbeef"))
+	commitFile(ctx, t, r, dir, "c")
+	assert.NoError(t, r.Push(ctx, &PushOptions{}))
+
+	clonePath, err = ioutil.TempDir("", "clone-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(clonePath)
+	cloned, err := Clone(ctx, &CloneOptions{URL: cloneURL + "#deadbeef", Path: clonePath})
+	assert.NoError(t, err)
+	branch, err := cloned.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "deadbeef", branch)
+	_, err = os.Stat(filepath.Join(clonePath, "c"))
+	assert.NoError(t, err)
+}
+
+func Test_Clone_inMemory(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host, InMemory: true})
+	assert.NoError(t, err)
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	pushFile(ctx, t, cloneURL, "README.md")
+
+	r, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	fs, err := r.Filesystem()
+	assert.NoError(t, err)
+	data, err := util.ReadFile(fs, "README.md")
+	assert.NoError(t, err)
+	assert.Equal(t, "README.md", string(data))
+	_, err = fs.Stat(".git")
+	assert.True(t, os.IsNotExist(err))
+
+	// commit and push from memory
+	assert.NoError(t, util.WriteFile(fs, "a", []byte("a"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "a", Author: &Signature{Name: "foo", Email: "foo@example.com"}})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Push(ctx, &PushOptions{}))
+
+	fs = memfs.New()
+	r, err = InitMemory(ctx, fs, "main")
+	assert.NoError(t, err)
+	assert.NoError(t, util.WriteFile(fs, "b", []byte("b"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "b", Author: &Signature{Name: "foo", Email: "foo@example.com"}})
+	assert.NoError(t, err)
+	branch, err := r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+
+	clonePath, err := ioutil.TempDir("", "clone-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(clonePath)
+	_, err = Clone(ctx, &CloneOptions{URL: cloneURL, Path: clonePath})
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(clonePath, "a"))
+	assert.NoError(t, err)
+}
+
+func Test_getAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_rsa")
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, ioutil.WriteFile(knownHostsPath, []byte{}, 0600))
+
+	origAgent := newSSHAgentAuth
+	defer func() { newSSHAgentAuth = origAgent }()
+	newSSHAgentAuth = func(user string) (*ssh.PublicKeysCallback, error) {
+		return &ssh.PublicKeysCallback{User: user}, nil
+	}
+
+	tests := map[string]struct {
+		auth        *Auth
+		assertFn    func(t *testing.T, am transport.AuthMethod)
+		expectedErr string
+	}{
+		"Nil": {
+			auth: nil,
+			assertFn: func(t *testing.T, am transport.AuthMethod) {
+				assert.Nil(t, am)
+			},
+		},
+		"Basic": {
+			auth: &Auth{Password: "token"},
+			assertFn: func(t *testing.T, am transport.AuthMethod) {
+				assert.Equal(t, &http.BasicAuth{Username: "codefresh", Password: "token"}, am)
+			},
+		},
+		"SSH private key": {
+			auth: &Auth{
+				Password: "token",
+				SSH: &SSHAuth{
+					PrivateKeyPath:  keyPath,
+					KnownHostsPaths: []string{knownHostsPath},
+				},
+			},
+			assertFn: func(t *testing.T, am transport.AuthMethod) {
+				keys, ok := am.(*ssh.PublicKeys)
+				assert.True(t, ok)
+				assert.Equal(t, "git", keys.User)
+				assert.NotNil(t, keys.HostKeyCallback)
+			},
+		},
+		"SSH agent": {
+			auth: &Auth{
+				SSH: &SSHAuth{
+					User:            "foo",
+					KnownHostsPaths: []string{knownHostsPath},
+				},
+			},
+			assertFn: func(t *testing.T, am transport.AuthMethod) {
+				cb, ok := am.(*ssh.PublicKeysCallback)
+				assert.True(t, ok)
+				assert.Equal(t, "foo", cb.User)
+				assert.NotNil(t, cb.HostKeyCallback)
+			},
+		},
+		"SSH missing known hosts": {
+			auth: &Auth{
+				SSH: &SSHAuth{
+					PrivateKeyPath:  keyPath,
+					KnownHostsPaths: []string{filepath.Join(dir, "missing")},
+				},
+			},
+			expectedErr: "failed to load known hosts",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			am, err := getAuth(context.Background(), test.auth, "")
+			if test.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			test.assertFn(t, am)
+		})
+	}
+}
+
+func Test_repoPathOf(t *testing.T) {
+	tests := map[string]struct {
+		url          string
+		expectedPath string
+		expectedErr  string
+	}{
+		"Https": {
+			url:          "https://github.com/foo/bar.git",
+			expectedPath: "foo/bar",
+		},
+		"Https with ref": {
+			url:          "https://github.com/foo/bar#branch",
+			expectedPath: "foo/bar",
+		},
+		"Https with tag": {
+			url:          "https://github.com/foo/bar@v1.0.0",
+			expectedPath: "foo/bar",
+		},
+		"Scp-like ssh": {
+			url:          "git@gitlab.com:group/sub/bar.git",
+			expectedPath: "group/sub/bar",
+		},
+		"Ssh": {
+			url:          "ssh://git@bitbucket.example.com:7999/proj/bar.git",
+			expectedPath: "proj/bar",
+		},
+		"No path": {
+			url:         "https://github.com",
+			expectedErr: "failed to get repository path from url: https://github.com",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, err := repoPathOf(test.url)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPath, p)
+		})
+	}
+}
+
+// prProvider returns the pull requests in order, one for each call to
+// GetPullRequest
+type prProvider struct {
+	Provider
+	prs []*PullRequest
+}
+
+func (p *prProvider) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	pr := p.prs[0]
+	p.prs = p.prs[1:]
+	return pr, nil
+}
+
+func Test_WaitForMerge(t *testing.T) {
+	tests := map[string]struct {
+		prs         []*PullRequest
+		expectedPR  *PullRequest
+		expectedErr string
+	}{
+		"Merged": {
+			prs:        []*PullRequest{{ID: 1}, {ID: 1}, {ID: 1, Merged: true, MergeCommit: "sha"}},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Closed": {
+			prs:         []*PullRequest{{ID: 1}, {ID: 1, URL: "url", Closed: true}},
+			expectedErr: "pull request closed without being merged: url",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			pr, err := WaitForMerge(utils.MockLoggerContext(), &prProvider{prs: test.prs}, &GetPullRequestOptions{ID: 1}, time.Millisecond)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_repo_CreateBranch(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r, err := Init(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "init"})
+	assert.NoError(t, err)
+
+	branch, err := r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "master", branch)
+
+	// uncommitted changes are kept in the new branch
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("b"), 0644))
+	assert.NoError(t, r.CreateBranch(ctx, "feature"))
+
+	branch, err = r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "feature", branch)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(data))
+}
+
+func Test_repo_Checkout(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	pushFile(ctx, t, cloneURL, "README.md")
+
+	// push a new branch, without pushing master
+	r, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err := r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	assert.NoError(t, utils.SetGitAuthor(root))
+	assert.NoError(t, r.CreateBranch(ctx, "staging"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "staging"), []byte("staging"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "staging"})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Checkout(ctx, "master"))
+	assert.NoError(t, r.Push(ctx, &PushOptions{Branch: "staging"}))
+
+	// checkout the remote branch
+	r, err = p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err = r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	_, err = os.Stat(filepath.Join(root, "staging"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.NoError(t, r.Checkout(ctx, "staging"))
+	branch, err := r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", branch)
+	_, err = os.Stat(filepath.Join(root, "staging"))
+	assert.NoError(t, err)
+
+	assert.Error(t, r.Checkout(ctx, "production"))
+
+	// clone the branch directly
+	r, err = p.CloneRepository(ctx, cloneURL+"#staging")
+	assert.NoError(t, err)
+	root, err = r.Root()
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	branch, err = r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", branch)
+}
+
+func Test_PushWithRetry(t *testing.T) {
+	tests := map[string]struct {
+		retries   int
+		replayErr error
+		// concurrent when true, another commit is pushed on each replay
+		concurrent  bool
+		wantErr     string
+		wantReplays int
+		wantFiles   []string
+	}{
+		"Replayed": {
+			wantReplays: 1,
+			wantFiles:   []string{"README.md", "a", "b"},
+		},
+		"Replay error": {
+			replayErr:   errors.New("some error"),
+			wantErr:     "failed to replay changes: some error",
+			wantReplays: 1,
+			wantFiles:   []string{"README.md", "a"},
+		},
+		"Retries exhausted": {
+			retries:     2,
+			concurrent:  true,
+			wantErr:     "failed to push after 2 retries: push rejected",
+			wantReplays: 2,
+			wantFiles:   []string{"README.md", "a", "c0", "c1"},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			host, err := ioutil.TempDir("", "host-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(host)
+
+			p, err := NewProvider(&Options{Type: "file", Host: host})
+			assert.NoError(t, err)
+			cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+			assert.NoError(t, err)
+			pushFile(ctx, t, cloneURL, "README.md")
+
+			r, root := cloneWithAuthor(ctx, t, p, cloneURL)
+			defer os.RemoveAll(root)
+			commitFile(ctx, t, r, root, "b")
+
+			// another commit is pushed after r was cloned
+			other, otherRoot := cloneWithAuthor(ctx, t, p, cloneURL)
+			defer os.RemoveAll(otherRoot)
+			commitFile(ctx, t, other, otherRoot, "a")
+			assert.NoError(t, other.Push(ctx, &PushOptions{}))
+
+			err = r.Push(ctx, &PushOptions{})
+			assert.True(t, errors.Is(err, ErrPushRejected))
+
+			replays := 0
+			sha, err := PushWithRetry(ctx, r, &PushRetryOptions{
+				Push:    &PushOptions{},
+				Commit:  &CommitOptions{Message: "b"},
+				Retries: test.retries,
+				Backoff: time.Millisecond,
+				Replay: func(ctx context.Context) error {
+					if test.concurrent {
+						assert.NoError(t, other.ResetToRemote(ctx, &FetchOptions{}))
+						commitFile(ctx, t, other, otherRoot, fmt.Sprintf("c%d", replays))
+						assert.NoError(t, other.Push(ctx, &PushOptions{}))
+					}
+
+					replays++
+					if test.replayErr != nil {
+						return test.replayErr
+					}
+
+					return ioutil.WriteFile(filepath.Join(root, "b"), []byte("b"), 0644)
+				},
+			})
+			if test.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				assert.Empty(t, sha)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, sha)
+			}
+			assert.Equal(t, test.wantReplays, replays)
+
+			_, remoteRoot := cloneWithAuthor(ctx, t, p, cloneURL)
+			defer os.RemoveAll(remoteRoot)
+			files, err := ioutil.ReadDir(remoteRoot)
+			assert.NoError(t, err)
+			names := []string{}
+			for _, f := range files {
+				if f.Name() != ".git" {
+					names = append(names, f.Name())
+				}
+			}
+			assert.Equal(t, test.wantFiles, names)
+		})
+	}
+}
+
+// Test_repo_Push_rejected pushes to a repository served by git-http-backend,
+// to pin the errors of the rejected pushes
+func Test_repo_Push_rejected(t *testing.T) {
+	tests := map[string]struct {
+		// denyNonFastForwards when true, the server checks the push is a fast
+		// forward, and the local branch is force pushed
+		denyNonFastForwards bool
+		// meanwhile when true, the other commit is pushed after the branch was
+		// advertised to the local repository, and before it pushes
+		meanwhile bool
+		wantErr   string
+	}{
+		"Checked by go-git": {
+			wantErr: "non-fast-forward update: refs/heads/main",
+		},
+		"Non fast forward": {
+			denyNonFastForwards: true,
+			wantErr:             "command error on refs/heads/main: non-fast-forward",
+		},
+		"Pushed meanwhile": {
+			meanwhile: true,
+			wantErr:   "command error on refs/heads/main: failed to update ref",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			host, err := ioutil.TempDir("", "host-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(host)
+
+			bare := filepath.Join(host, "bar.git")
+			runGit(t, host, "init", "--bare", "-b", "main", bare)
+			runGit(t, bare, "config", "http.receivepack", "true")
+			if test.denyNonFastForwards {
+				runGit(t, bare, "config", "receive.denyNonFastForwards", "true")
+			}
+
+			other := filepath.Join(host, "other")
+			runGit(t, host, "clone", bare, other)
+			runGit(t, other, "commit", "--allow-empty", "-m", "first")
+			runGit(t, other, "push", "origin", "HEAD:main")
+
+			pushOther := func() {
+				runGit(t, other, "commit", "--allow-empty", "-m", "other")
+				runGit(t, other, "push", "origin", "HEAD:main")
+			}
+
+			execPath, err := exec.Command("git", "--exec-path").Output()
+			assert.NoError(t, err)
+			backend := &cgi.Handler{
+				Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+				Env:  []string{"GIT_PROJECT_ROOT=" + host, "GIT_HTTP_EXPORT_ALL=1"},
+			}
+			srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, req *nethttp.Request) {
+				if test.meanwhile && req.Method == nethttp.MethodPost && strings.HasSuffix(req.URL.Path, "/git-receive-pack") {
+					pushOther()
+				}
+				backend.ServeHTTP(w, req)
+			}))
+			defer srv.Close()
+
+			auth := &Auth{Password: "token"}
+			r, err := cloneRepository(ctx, &CloneOptions{URL: srv.URL + "/bar.git", Auth: auth}, false)
+			assert.NoError(t, err)
+			root, err := r.Root()
+			assert.NoError(t, err)
+			defer os.RemoveAll(root)
+			assert.NoError(t, utils.SetGitAuthor(root))
+			commitFile(ctx, t, r, root, "local")
+
+			pushOpts := &PushOptions{Auth: auth}
+			if !test.meanwhile {
+				pushOther()
+			}
+			if test.denyNonFastForwards {
+				pushOpts.RefSpecs = []string{"+refs/heads/main:refs/heads/main"}
+			}
+
+			err = r.Push(ctx, pushOpts)
+			assert.True(t, errors.Is(err, ErrPushRejected))
+			assert.EqualError(t, err, fmt.Sprintf("%s: %s", ErrPushRejected, test.wantErr))
+		})
+	}
+}
+
+func Test_repo_ResetToRemote(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(host)
+
+	p, err := NewProvider(&Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+	cloneURL, err := p.CreateRepository(ctx, &CreateRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	pushFile(ctx, t, cloneURL, "README.md")
+
+	r, root := cloneWithAuthor(ctx, t, p, cloneURL)
+	defer os.RemoveAll(root)
+	commitFile(ctx, t, r, root, "local")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "README.md"), []byte("changed"), 0644))
+
+	other, otherRoot := cloneWithAuthor(ctx, t, p, cloneURL)
+	defer os.RemoveAll(otherRoot)
+	commitFile(ctx, t, other, otherRoot, "remote")
+	assert.NoError(t, other.Push(ctx, &PushOptions{}))
+
+	assert.NoError(t, r.ResetToRemote(ctx, &FetchOptions{}))
+
+	// local commits and changes are discarded
+	_, err = os.Stat(filepath.Join(root, "local"))
+	assert.True(t, os.IsNotExist(err))
+	data, err := ioutil.ReadFile(filepath.Join(root, "README.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "README.md", string(data))
+	_, err = os.Stat(filepath.Join(root, "remote"))
+	assert.NoError(t, err)
+
+	branch, err := r.CurrentBranch()
+	assert.NoError(t, err)
+	assert.Equal(t, "master", branch)
+
+	assert.Error(t, r.ResetToRemote(ctx, &FetchOptions{Branch: "production"}))
+}
+
+func cloneWithAuthor(ctx context.Context, t *testing.T, p Provider, cloneURL string) (Repository, string) {
+	r, err := p.CloneRepository(ctx, cloneURL)
+	assert.NoError(t, err)
+	root, err := r.Root()
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(root))
+
+	return r, root
+}
+
+// runGit runs the git cli in dir, with a test author
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+func commitFile(ctx context.Context, t *testing.T, r Repository, root, fileName string) {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, fileName), []byte(fileName), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err := r.Commit(ctx, &CommitOptions{Message: fileName})
+	assert.NoError(t, err)
+}
+
+// initHistory creates a repository with two commits: "first" adds "a" and
+// "env/x", "second" modifies "a", adds "env/y" and deletes "env/x"
+func initHistory(ctx context.Context, t *testing.T) (Repository, string) {
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+
+	r, err := Init(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "env"), 0755))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "env", "x"), []byte("x\n"), 0644))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "first"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("b\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "env", "y"), []byte("y\n"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "env", "x")))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &CommitOptions{Message: "second"})
+	assert.NoError(t, err)
+
+	return r, dir
+}
+
+func Test_repo_Status(t *testing.T) {
+	ctx := utils.MockLoggerContext()
+	r, dir := initHistory(ctx, t)
+	defer os.RemoveAll(dir)
+
+	status, err := r.Status()
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("c\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b"), []byte("b\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "c"), []byte("c\n"), 0644))
+	assert.NoError(t, r.Add(ctx, "c"))
+
+	status, err = r.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, []*FileStatus{
+		{Path: "a", Staging: StatusUnmodified, Worktree: StatusModified},
+		{Path: "b", Staging: StatusUntracked, Worktree: StatusUntracked},
+		{Path: "c", Staging: StatusAdded, Worktree: StatusUnmodified},
+	}, status)
+}
+
+func Test_repo_Diff(t *testing.T) {
+	tests := map[string]struct {
+		from    string
+		to      string
+		want    []*FileDiff
+		wantErr string
+	}{
+		"Between commits": {
+			from: "HEAD~1",
+			want: []*FileDiff{
+				{Path: "a", Action: StatusModified},
+				{Path: "env/x", Action: StatusDeleted},
+				{Path: "env/y", Action: StatusAdded},
+			},
+		},
+		"Empty from": {
+			to: "HEAD~1",
+			want: []*FileDiff{
+				{Path: "a", Action: StatusAdded},
+				{Path: "env/x", Action: StatusAdded},
+			},
+		},
+		"Same commit": {
+			from: "master",
+			to:   "HEAD",
+			want: []*FileDiff{},
+		},
+		"Unknown revision": {
+			from:    "foo",
+			wantErr: "failed to resolve revision foo",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			r, dir := initHistory(ctx, t)
+			defer os.RemoveAll(dir)
+
+			diffs, err := r.Diff(test.from, test.to)
+			if test.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, diffs, len(test.want))
+			for i, d := range diffs {
+				assert.Equal(t, test.want[i].Path, d.Path)
+				assert.Equal(t, test.want[i].Action, d.Action)
+				assert.Contains(t, d.Patch, "diff --git")
+			}
+		})
+	}
+}
+
+func Test_repo_Log(t *testing.T) {
+	tests := map[string]struct {
+		path string
+		want []string
+	}{
+		"All": {
+			want: []string{"second", "first"},
+		},
+		"Directory": {
+			path: "env",
+			want: []string{"second", "first"},
+		},
+		"Deleted file": {
+			path: "env/x",
+			want: []string{"second", "first"},
+		},
+		"Added file": {
+			path: "env/y/",
+			want: []string{"second"},
+		},
+		"Unknown path": {
+			path: "foo",
+			want: []string{},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			r, dir := initHistory(ctx, t)
+			defer os.RemoveAll(dir)
+
+			commits, err := r.Log(test.path)
+			assert.NoError(t, err)
+
+			msgs := []string{}
+			for _, c := range commits {
+				assert.Len(t, c.SHA, 40)
+				assert.NotEmpty(t, c.Author.Name)
+				msgs = append(msgs, c.Message)
+			}
+			assert.Equal(t, test.want, msgs)
+		})
+	}
+}
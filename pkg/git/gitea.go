@@ -0,0 +1,333 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+)
+
+const giteaAPIPath = "/api/v1"
+
+type (
+	// gitea works with self-hosted gitea servers, Options.Host is the base url
+	// of the server
+	gitea struct {
+		opts *Options
+		api  *apiClient
+	}
+
+	giteaUser struct {
+		Login string `json:"login"`
+	}
+
+	giteaRepo struct {
+		CloneURL    string `json:"clone_url"`
+		Permissions *struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+
+	giteaCreateKey struct {
+		Title    string `json:"title"`
+		Key      string `json:"key"`
+		ReadOnly bool   `json:"read_only"`
+	}
+
+	giteaHook struct {
+		ID     int64             `json:"id,omitempty"`
+		Type   string            `json:"type,omitempty"`
+		Config map[string]string `json:"config"`
+		Events []string          `json:"events,omitempty"`
+		Active bool              `json:"active"`
+	}
+
+	giteaOrgPermissions struct {
+		CanCreateRepository bool `json:"can_create_repository"`
+	}
+
+	giteaCreateRepo struct {
+		Name    string `json:"name"`
+		Private bool   `json:"private"`
+	}
+
+	giteaPullRequest struct {
+		Number         int    `json:"number"`
+		HTMLURL        string `json:"html_url"`
+		State          string `json:"state"`
+		Merged         bool   `json:"merged"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+	}
+
+	giteaCreatePullRequest struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+	}
+)
+
+func newGitea(opts *Options) (Provider, error) {
+	if opts.Host == "" {
+		return nil, ErrHostRequired
+	}
+
+	return &gitea{
+		opts: opts,
+		api:  newAPIClient(strings.TrimSuffix(opts.Host, "/")+giteaAPIPath, giteaAuth(opts.Auth), giteaErrMsg),
+	}, nil
+}
+
+func giteaRepoURL(host, owner, name string) (string, error) {
+	if host == "" {
+		return "", ErrHostRequired
+	}
+
+	return fmt.Sprintf("%s/%s/%s.git", strings.TrimSuffix(host, "/"), owner, name), nil
+}
+
+func giteaSSHRepoURL(host, owner, name string) (string, error) {
+	hostname, err := hostnameOf(host)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("git@%s:%s/%s.git", hostname, owner, name), nil
+}
+
+// giteaAuth uses basic auth when a username is provided, and token auth otherwise
+func giteaAuth(auth *Auth) func(*http.Request) {
+	return func(req *http.Request) {
+		if auth == nil {
+			return
+		}
+
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		} else {
+			req.Header.Set("Authorization", "token "+auth.Password)
+		}
+	}
+}
+
+func giteaErrMsg(data []byte) string {
+	e := &struct {
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(data, e) != nil {
+		return ""
+	}
+
+	return e.Message
+}
+
+func (g *gitea) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
+	r := &giteaRepo{}
+	status, err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", opts.Owner, opts.Name), nil, r)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return "", ErrRepoNotFound
+		}
+		return "", err
+	}
+
+	return r.CloneURL, nil
+}
+
+func (g *gitea) CreateRepository(ctx context.Context, opts *CreateRepoOptions) (string, error) {
+	l := log.G(ctx).WithFields(log.Fields{
+		"owner": opts.Owner,
+		"repo":  opts.Name,
+	})
+
+	l.Debug("creating repository")
+
+	authUser := &giteaUser{}
+	if _, err := g.api.do(ctx, http.MethodGet, "/user", nil, authUser); err != nil {
+		return "", err
+	}
+
+	path := "/user/repos"
+	if authUser.Login != opts.Owner {
+		path = fmt.Sprintf("/orgs/%s/repos", opts.Owner)
+	}
+
+	r := &giteaRepo{}
+	_, err := g.api.do(ctx, http.MethodPost, path, &giteaCreateRepo{
+		Name:    opts.Name,
+		Private: opts.Private,
+	}, r)
+	if err != nil {
+		return "", err
+	}
+
+	if r.CloneURL == "" {
+		return "", fmt.Errorf("repo clone url is empty")
+	}
+
+	l.Debug("repository created")
+
+	return r.CloneURL, nil
+}
+
+func (g *gitea) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
+	return cloneRepository(ctx, &CloneOptions{URL: cloneURL, Auth: g.opts.Auth}, g.opts.InMemory)
+}
+
+func (g *gitea) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &giteaPullRequest{}
+	_, err = g.api.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/%s/pulls", owner, name), &giteaCreatePullRequest{
+		Title: opts.Title,
+		Body:  opts.Description,
+		Head:  opts.Head,
+		Base:  opts.Base,
+	}, pr)
+	if err != nil {
+		return nil, err
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (g *gitea) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &giteaPullRequest{}
+	if _, err = g.api.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s/pulls/%d", owner, name, opts.ID), nil, pr); err != nil {
+		return nil, err
+	}
+
+	return pr.toPullRequest(), nil
+}
+
+func (g *gitea) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if opts.RepoURL != "" {
+		owner, name, err := splitRepoPath(opts.RepoURL)
+		if err != nil {
+			return err
+		}
+
+		r := &giteaRepo{}
+		status, err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/%s", owner, name), nil, r)
+		if err != nil {
+			if status == http.StatusNotFound {
+				return accessDenied("repository %s/%s does not exist, or the git token can not access it", owner, name)
+			}
+			return accessErr(status, err)
+		}
+
+		if r.Permissions != nil && !r.Permissions.Push {
+			return accessDenied("the git token can not push to repository %s/%s", owner, name)
+		}
+
+		return nil
+	}
+
+	authUser := &giteaUser{}
+	if status, err := g.api.do(ctx, http.MethodGet, "/user", nil, authUser); err != nil {
+		return accessErr(status, err)
+	}
+
+	if authUser.Login == opts.Owner {
+		return nil
+	}
+
+	perms := &giteaOrgPermissions{}
+	status, err := g.api.do(ctx, http.MethodGet, fmt.Sprintf("/users/%s/orgs/%s/permissions", authUser.Login, opts.Owner), nil, perms)
+	if err != nil {
+		if status == http.StatusNotFound || status == http.StatusForbidden {
+			return accessDenied("user %s is not a member of the organization %s", authUser.Login, opts.Owner)
+		}
+		return accessErr(status, err)
+	}
+
+	if !perms.CanCreateRepository {
+		return accessDenied("user %s can not create repositories in the organization %s", authUser.Login, opts.Owner)
+	}
+
+	return nil
+}
+
+func (g *gitea) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.api.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/%s/keys", owner, name), &giteaCreateKey{
+		Title:    opts.Title,
+		Key:      opts.PublicKey,
+		ReadOnly: opts.ReadOnly,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add deploy key to repository %s/%s: %w", owner, name, err)
+	}
+
+	return nil
+}
+
+func (g *gitea) CreateWebhook(ctx context.Context, opts *WebhookOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/repos/%s/%s/hooks", owner, name)
+	hooks := []*giteaHook{}
+	if _, err = g.api.do(ctx, http.MethodGet, path+"?limit=50", nil, &hooks); err != nil {
+		return fmt.Errorf("failed to list webhooks of repository %s/%s: %w", owner, name, err)
+	}
+
+	hook := &giteaHook{
+		Type: "gitea",
+		Config: map[string]string{
+			"url":          opts.URL,
+			"content_type": "json",
+			"secret":       opts.Secret,
+		},
+		Events: []string{"push"},
+		Active: true,
+	}
+
+	for _, h := range hooks {
+		if h.Config["url"] == opts.URL {
+			_, err = g.api.do(ctx, http.MethodPatch, fmt.Sprintf("%s/%d", path, h.ID), hook, nil)
+			if err != nil {
+				return fmt.Errorf("failed to update webhook of repository %s/%s: %w", owner, name, err)
+			}
+			return nil
+		}
+	}
+
+	if _, err = g.api.do(ctx, http.MethodPost, path, hook, nil); err != nil {
+		return fmt.Errorf("failed to create webhook of repository %s/%s: %w", owner, name, err)
+	}
+
+	return nil
+}
+
+func (pr *giteaPullRequest) toPullRequest() *PullRequest {
+	res := &PullRequest{
+		ID:     pr.Number,
+		URL:    pr.HTMLURL,
+		Merged: pr.Merged,
+		Closed: !pr.Merged && pr.State == "closed",
+	}
+
+	if res.Merged {
+		res.MergeCommit = pr.MergeCommitSHA
+	}
+
+	return res
+}
@@ -0,0 +1,328 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGiteaTestServer(t *testing.T, handlers map[string]http.HandlerFunc) (*httptest.Server, Provider) {
+	srv := newTestServer(handlers)
+	p, err := newGitea(&Options{
+		Type: "gitea",
+		Auth: &Auth{Password: "token"},
+		Host: srv.URL,
+	})
+	assert.NoError(t, err)
+
+	return srv, p
+}
+
+func Test_gitea_GetRepository(t *testing.T) {
+	tests := map[string]struct {
+		handlers    map[string]http.HandlerFunc
+		expectedURL string
+		expectedErr string
+	}{
+		"Exists": {
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "token token", r.Header.Get("Authorization"))
+					writeJSON(t, w, http.StatusOK, map[string]string{"clone_url": "https://gitea.example.com/foo/bar.git"})
+				},
+			},
+			expectedURL: "https://gitea.example.com/foo/bar.git",
+		},
+		"Not found": {
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: ErrRepoNotFound.Error(),
+		},
+		"Forbidden": {
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusForbidden, map[string]string{"message": "token does not have required scope"})
+				},
+			},
+			expectedErr: "403 token does not have required scope",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGiteaTestServer(t, test.handlers)
+			defer srv.Close()
+
+			url, err := p.GetRepository(utils.MockLoggerContext(), &GetRepoOptions{
+				Owner: "foo",
+				Name:  "bar",
+			})
+			if test.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_gitea_CreateRepository(t *testing.T) {
+	tests := map[string]struct {
+		owner string
+		route string
+	}{
+		"User": {
+			owner: "user",
+			route: "POST /api/v1/user/repos",
+		},
+		"Organization": {
+			owner: "org",
+			route: "POST /api/v1/orgs/org/repos",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGiteaTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "user"})
+				},
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, map[string]interface{}{"name": "bar", "private": true}, body)
+					writeJSON(t, w, http.StatusCreated, map[string]string{"clone_url": "https://gitea.example.com/" + test.owner + "/bar.git"})
+				},
+			})
+			defer srv.Close()
+
+			url, err := p.CreateRepository(utils.MockLoggerContext(), &CreateRepoOptions{
+				Owner:   test.owner,
+				Name:    "bar",
+				Private: true,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "https://gitea.example.com/"+test.owner+"/bar.git", url)
+		})
+	}
+}
+
+func Test_gitea_CreatePullRequest(t *testing.T) {
+	srv, p := newGiteaTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v1/repos/foo/bar/pulls": func(w http.ResponseWriter, r *http.Request) {
+			body := &giteaCreatePullRequest{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(body))
+			assert.Equal(t, &giteaCreatePullRequest{Title: "title", Body: "description", Head: "feature", Base: "main"}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+				"number":   1,
+				"state":    "open",
+				"html_url": "https://gitea.example.com/foo/bar/pulls/1",
+			})
+		},
+	})
+	defer srv.Close()
+
+	pr, err := p.CreatePullRequest(utils.MockLoggerContext(), &PullRequestOptions{
+		RepoURL:     "https://gitea.example.com/foo/bar.git",
+		Head:        "feature",
+		Base:        "main",
+		Title:       "title",
+		Description: "description",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &PullRequest{ID: 1, URL: "https://gitea.example.com/foo/bar/pulls/1"}, pr)
+}
+
+func Test_gitea_GetPullRequest(t *testing.T) {
+	tests := map[string]struct {
+		response   map[string]interface{}
+		expectedPR *PullRequest
+	}{
+		"Open": {
+			response:   map[string]interface{}{"number": 1, "state": "open"},
+			expectedPR: &PullRequest{ID: 1},
+		},
+		"Merged": {
+			response:   map[string]interface{}{"number": 1, "state": "closed", "merged": true, "merge_commit_sha": "sha"},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Closed": {
+			response:   map[string]interface{}{"number": 1, "state": "closed"},
+			expectedPR: &PullRequest{ID: 1, Closed: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGiteaTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar/pulls/1": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.GetPullRequest(utils.MockLoggerContext(), &GetPullRequestOptions{
+				RepoURL: "git@gitea.example.com:foo/bar.git",
+				ID:      1,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_gitea_ValidateAccess(t *testing.T) {
+	tests := map[string]struct {
+		opts        *ValidateAccessOptions
+		handlers    map[string]http.HandlerFunc
+		expectedErr string
+	}{
+		"Push": {
+			opts: &ValidateAccessOptions{RepoURL: "https://gitea.example.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"permissions": map[string]bool{"push": true}})
+				},
+			},
+		},
+		"Push without permission": {
+			opts: &ValidateAccessOptions{RepoURL: "https://gitea.example.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"permissions": map[string]bool{"push": false}})
+				},
+			},
+			expectedErr: "git access denied: the git token can not push to repository foo/bar",
+		},
+		"Create user repo": {
+			opts: &ValidateAccessOptions{Owner: "foo", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+			},
+		},
+		"Create org repo": {
+			opts: &ValidateAccessOptions{Owner: "org", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+				"GET /api/v1/users/foo/orgs/org/permissions": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]bool{"can_create_repository": false})
+				},
+			},
+			expectedErr: "git access denied: user foo can not create repositories in the organization org",
+		},
+		"Invalid token": {
+			opts: &ValidateAccessOptions{Owner: "org", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusUnauthorized, map[string]string{"message": "token is required"})
+				},
+			},
+			expectedErr: "git access denied: the git token is invalid or expired",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGiteaTestServer(t, test.handlers)
+			defer srv.Close()
+
+			err := p.ValidateAccess(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.True(t, errors.Is(err, ErrAccessDenied))
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_gitea_AddDeployKey(t *testing.T) {
+	srv, p := newGiteaTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v1/repos/foo/bar/keys": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{
+				"title":     "argo-cd",
+				"key":       "ssh-ed25519 AAAA",
+				"read_only": true,
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{"id": 1})
+		},
+	})
+	defer srv.Close()
+
+	err := p.AddDeployKey(utils.MockLoggerContext(), &DeployKeyOptions{
+		RepoURL:   "https://gitea.example.com/foo/bar.git",
+		Title:     "argo-cd",
+		PublicKey: "ssh-ed25519 AAAA",
+		ReadOnly:  true,
+	})
+	assert.NoError(t, err)
+}
+
+func Test_gitea_CreateWebhook(t *testing.T) {
+	tests := map[string]struct {
+		hooks []map[string]interface{}
+		route string
+	}{
+		"New": {
+			hooks: []map[string]interface{}{},
+			route: "POST /api/v1/repos/foo/bar/hooks",
+		},
+		"Existing": {
+			hooks: []map[string]interface{}{
+				{"id": 2, "config": map[string]string{"url": "https://argocd.example.com/api/webhook"}},
+			},
+			route: "PATCH /api/v1/repos/foo/bar/hooks/2",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			srv, p := newGiteaTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v1/repos/foo/bar/hooks": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.hooks)
+				},
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, map[string]interface{}{
+						"type": "gitea",
+						"config": map[string]interface{}{
+							"url":          "https://argocd.example.com/api/webhook",
+							"content_type": "json",
+							"secret":       "secret",
+						},
+						"events": []interface{}{"push"},
+						"active": true,
+					}, body)
+					called = true
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": 2})
+				},
+			})
+			defer srv.Close()
+
+			err := p.(WebhookCreator).CreateWebhook(utils.MockLoggerContext(), &WebhookOptions{
+				RepoURL: "https://gitea.example.com/foo/bar.git",
+				URL:     "https://argocd.example.com/api/webhook",
+				Secret:  "secret",
+			})
+			assert.NoError(t, err)
+			assert.True(t, called)
+		})
+	}
+}
@@ -3,11 +3,9 @@ package git
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-
 	"net/http"
+	"strings"
 
-	cferrors "github.com/codefresh-io/cf-argo/pkg/errors"
 	"github.com/codefresh-io/cf-argo/pkg/log"
 
 	gh "github.com/google/go-github/v32/github"
@@ -18,25 +16,24 @@ type github struct {
 	client *gh.Client
 }
 
+const githubHost = "https://github.com"
+
 func newGithub(opts *Options) (Provider, error) {
-	var c *gh.Client
-	var err error
 	hc := &http.Client{}
 
-	if opts.Auth != nil {
+	switch {
+	case opts.Auth != nil && opts.Auth.GithubApp != nil:
+		hc.Transport = &githubAppTransport{opts.Auth.GithubApp}
+	case opts.Auth != nil:
 		hc.Transport = &gh.BasicAuthTransport{
 			Username: opts.Auth.Username,
 			Password: opts.Auth.Password,
 		}
 	}
 
-	if opts.Host != "" {
-		c, err = gh.NewEnterpriseClient(opts.Host, opts.Host, hc)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		c = gh.NewClient(hc)
+	c, err := newGithubClient(opts.Host, hc)
+	if err != nil {
+		return nil, err
 	}
 
 	g := &github{
@@ -46,6 +43,36 @@ func newGithub(opts *Options) (Provider, error) {
 	return g, nil
 }
 
+// githubRepoURL returns the repository url on github.com, or on the GitHub
+// Enterprise server when the host is set
+func githubRepoURL(host, owner, name string) string {
+	return fmt.Sprintf("%s/%s/%s", githubBaseURL(host), owner, name)
+}
+
+func githubSSHRepoURL(host, owner, name string) (string, error) {
+	hostname, err := hostnameOf(githubBaseURL(host))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("git@%s:%s/%s.git", hostname, owner, name), nil
+}
+
+// GithubEnterpriseAPIURL returns the api url of the GitHub Enterprise server
+func GithubEnterpriseAPIURL(host string) string {
+	return githubBaseURL(host) + "/api/v3"
+}
+
+// githubBaseURL returns the web url of the server, the GitHub Enterprise host
+// might be given as the api url
+func githubBaseURL(host string) string {
+	if host == "" {
+		return githubHost
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(host, "/"), "/api/v3")
+}
+
 func (g *github) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
 	r, res, err := g.client.Repositories.Get(ctx, opts.Owner, opts.Name)
 
@@ -77,10 +104,23 @@ func (g *github) CreateRepository(ctx context.Context, opts *CreateRepoOptions)
 		org = opts.Owner
 	}
 
-	r, _, err := g.client.Repositories.Create(ctx, org, &gh.Repository{
+	if org == "" && len(opts.Teams) > 0 {
+		return "", fmt.Errorf("teams can only be granted access to organization repositories, %s is a user", opts.Owner)
+	}
+
+	ghRepo := &gh.Repository{
 		Name:    gh.String(opts.Name),
 		Private: gh.Bool(opts.Private),
-	})
+	}
+	if opts.Description != "" {
+		ghRepo.Description = gh.String(opts.Description)
+	}
+	if opts.Visibility != "" {
+		ghRepo.Private = gh.Bool(opts.Visibility != RepoVisibilityPublic)
+		ghRepo.Visibility = gh.String(string(opts.Visibility))
+	}
+
+	r, _, err := g.client.Repositories.Create(ctx, org, ghRepo)
 	if err != nil {
 		return "", err
 	}
@@ -89,37 +129,282 @@ func (g *github) CreateRepository(ctx context.Context, opts *CreateRepoOptions)
 		return "", fmt.Errorf("repo clone url is nil")
 	}
 
+	for _, t := range opts.Teams {
+		_, err = g.client.Teams.AddTeamRepoBySlug(ctx, org, t.Slug, org, opts.Name, &gh.TeamAddTeamRepoOptions{
+			Permission: t.Permission,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to grant team %s access to repository %s/%s: %w", t.Slug, org, opts.Name, err)
+		}
+
+		l.WithField("team", t.Slug).Debug("granted team access")
+	}
+
 	l.Debug("repository created")
 
 	return *r.CloneURL, err
 }
 
 func (g *github) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
-	log.G(ctx).Debug("creating temp dir for gitops repo")
-	clonePath, err := ioutil.TempDir("", "repo-")
-	cferrors.CheckErr(err)
-	log.G(ctx).WithField("location", clonePath).Debug("temp dir created")
+	return cloneRepository(ctx, &CloneOptions{URL: cloneURL, Auth: g.opts.Auth}, g.opts.InMemory)
+}
 
-	log.G(ctx).Printf("cloning existing gitops repository...")
+func (g *github) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
 
-	return g.clone(ctx, &CloneOptions{
-		URL:  cloneURL,
-		Path: clonePath,
+	pr, _, err := g.client.PullRequests.Create(ctx, owner, name, &gh.NewPullRequest{
+		Title: gh.String(opts.Title),
+		Head:  gh.String(opts.Head),
+		Base:  gh.String(opts.Base),
+		Body:  gh.String(opts.Description),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return githubPullRequest(pr), nil
+}
+
+func (g *github) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, _, err := g.client.PullRequests.Get(ctx, owner, name, opts.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return githubPullRequest(pr), nil
+}
+
+// ProtectBranch replaces the protection rules of the branch, the branch must
+// already exist in the repository
+func (g *github) ProtectBranch(ctx context.Context, opts *ProtectBranchOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	req := &gh.ProtectionRequest{
+		EnforceAdmins: opts.EnforceAdmins,
+	}
+	if opts.RequiredApprovals > 0 {
+		req.RequiredPullRequestReviews = &gh.PullRequestReviewsEnforcementRequest{
+			RequiredApprovingReviewCount: opts.RequiredApprovals,
+			RequireCodeOwnerReviews:      opts.RequireCodeOwnerReviews,
+		}
+	}
+	if len(opts.RequiredStatusChecks) > 0 {
+		req.RequiredStatusChecks = &gh.RequiredStatusChecks{
+			Strict:   true,
+			Contexts: opts.RequiredStatusChecks,
+		}
+	}
+
+	if _, _, err = g.client.Repositories.UpdateBranchProtection(ctx, owner, name, opts.Branch, req); err != nil {
+		return fmt.Errorf("failed to protect branch %s of repository %s/%s: %w", opts.Branch, owner, name, err)
+	}
+
+	log.G(ctx).WithFields(log.Fields{
+		"repo":   fmt.Sprintf("%s/%s", owner, name),
+		"branch": opts.Branch,
+	}).Debug("protected branch")
+
+	return nil
 }
 
-func (g *github) clone(ctx context.Context, opts *CloneOptions) (Repository, error) {
-	if opts == nil {
-		return nil, cferrors.ErrNilOpts
+func (g *github) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if opts.RepoURL != "" {
+		return g.validatePushAccess(ctx, opts.RepoURL)
+	}
+
+	if g.opts.Auth != nil && g.opts.Auth.GithubApp != nil {
+		// the installation permissions can not be queried with an installation
+		// token, a missing permission is reported when creating the repository
+		return nil
+	}
+
+	user, res, err := g.client.Users.Get(ctx, "")
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusUnauthorized {
+			return accessDenied("the git token is invalid or expired")
+		}
+		return err
+	}
+
+	scope := "public_repo"
+	if opts.Private {
+		scope = "repo"
+	}
+	if !githubHasScope(res, scope) {
+		return accessDenied("the git token is missing the %q scope, required to create the repository", scope)
 	}
 
-	auth := g.opts.Auth
-	if opts.Auth != nil {
-		auth = opts.Auth
+	if user.GetLogin() == opts.Owner {
+		return nil
 	}
-	return Clone(ctx, &CloneOptions{
-		URL:  opts.URL,
-		Path: opts.Path,
-		Auth: auth,
+
+	m, res, err := g.client.Organizations.GetOrgMembership(ctx, "", opts.Owner)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return accessDenied("user %s is not a member of the organization %s", user.GetLogin(), opts.Owner)
+		}
+		if res != nil && res.StatusCode == http.StatusForbidden {
+			// the membership requires the "read:org" scope, so we can not tell
+			return nil
+		}
+		return err
+	}
+
+	if m.GetState() != "active" {
+		return accessDenied("user %s membership in the organization %s is %s", user.GetLogin(), opts.Owner, m.GetState())
+	}
+
+	if m.GetRole() == "admin" {
+		return nil
+	}
+
+	org, _, err := g.client.Organizations.Get(ctx, opts.Owner)
+	if err != nil {
+		return err
+	}
+
+	canCreate := org.MembersCanCreatePublicRepos
+	if opts.Private {
+		canCreate = org.MembersCanCreatePrivateRepos
+	}
+	if canCreate != nil && !*canCreate {
+		return accessDenied("members of the organization %s can not create repositories, user %s must be an owner", opts.Owner, user.GetLogin())
+	}
+
+	return nil
+}
+
+func (g *github) validatePushAccess(ctx context.Context, repoURL string) error {
+	owner, name, err := splitRepoPath(repoURL)
+	if err != nil {
+		return err
+	}
+
+	r, res, err := g.client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusUnauthorized {
+			return accessDenied("the git token is invalid or expired")
+		}
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return accessDenied("repository %s/%s does not exist, or the git token can not access it", owner, name)
+		}
+		return err
+	}
+
+	// installations do not get the permissions of the repository
+	if r.Permissions != nil && !r.GetPermissions()["push"] {
+		return accessDenied("the git token can not push to repository %s/%s", owner, name)
+	}
+
+	scope := "public_repo"
+	if r.GetPrivate() {
+		scope = "repo"
+	}
+	if !githubHasScope(res, scope) {
+		return accessDenied("the git token is missing the %q scope, required to push to repository %s/%s", scope, owner, name)
+	}
+
+	return nil
+}
+
+// githubHasScope returns true if the oauth token has the scope, or if the token
+// has no scopes (fine-grained and installation tokens)
+func githubHasScope(res *gh.Response, scope string) bool {
+	header := res.Header.Get("X-OAuth-Scopes")
+	if header == "" {
+		return true
+	}
+
+	for _, s := range strings.Split(header, ",") {
+		s = strings.TrimSpace(s)
+		// "repo" includes "public_repo"
+		if s == scope || (s == "repo" && scope == "public_repo") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *github) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = g.client.Repositories.CreateKey(ctx, owner, name, &gh.Key{
+		Title:    gh.String(opts.Title),
+		Key:      gh.String(opts.PublicKey),
+		ReadOnly: gh.Bool(opts.ReadOnly),
 	})
+	if err != nil {
+		return fmt.Errorf("failed to add deploy key to repository %s/%s: %w", owner, name, err)
+	}
+
+	return nil
+}
+
+func (g *github) CreateWebhook(ctx context.Context, opts *WebhookOptions) error {
+	owner, name, err := splitRepoPath(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	hooks, _, err := g.client.Repositories.ListHooks(ctx, owner, name, &gh.ListOptions{PerPage: 100})
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks of repository %s/%s: %w", owner, name, err)
+	}
+
+	hook := &gh.Hook{
+		Config: map[string]interface{}{
+			"url":          opts.URL,
+			"content_type": "json",
+			"secret":       opts.Secret,
+		},
+		Events: []string{"push"},
+		Active: gh.Bool(true),
+	}
+
+	for _, h := range hooks {
+		if h.Config["url"] == opts.URL {
+			_, _, err = g.client.Repositories.EditHook(ctx, owner, name, h.GetID(), hook)
+			if err != nil {
+				return fmt.Errorf("failed to update webhook of repository %s/%s: %w", owner, name, err)
+			}
+			return nil
+		}
+	}
+
+	if _, _, err = g.client.Repositories.CreateHook(ctx, owner, name, hook); err != nil {
+		return fmt.Errorf("failed to create webhook of repository %s/%s: %w", owner, name, err)
+	}
+
+	return nil
+}
+
+func githubPullRequest(pr *gh.PullRequest) *PullRequest {
+	res := &PullRequest{
+		ID:     pr.GetNumber(),
+		URL:    pr.GetHTMLURL(),
+		Merged: pr.GetMerged(),
+	}
+
+	if res.Merged {
+		res.MergeCommit = pr.GetMergeCommitSHA()
+	} else {
+		res.Closed = pr.GetState() == "closed"
+	}
+
+	return res
 }
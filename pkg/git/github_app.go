@@ -0,0 +1,188 @@
+package git
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	gh "github.com/google/go-github/v32/github"
+)
+
+const (
+	// githubAppTokenUsername the username of git operations with an
+	// installation token
+	githubAppTokenUsername = "x-access-token"
+	// githubAppJWTExpiration github allows up to 10 minutes
+	githubAppJWTExpiration = time.Minute * 9
+	// githubAppTokenRefresh how long before the installation token expires to
+	// mint a new one
+	githubAppTokenRefresh = time.Minute * 5
+)
+
+type (
+	githubAppToken struct {
+		token     string
+		expiresAt time.Time
+	}
+
+	// githubAppTransport authenticates api requests with an installation token
+	githubAppTransport struct {
+		app *GithubAppAuth
+	}
+
+	// bearerTransport authenticates api requests as the app itself
+	bearerTransport struct {
+		token string
+	}
+)
+
+var (
+	githubAppTokensMu sync.Mutex
+	// githubAppTokens cached installation tokens, by app, installation and host
+	githubAppTokens = map[string]*githubAppToken{}
+)
+
+// GithubAppInstallationToken returns an installation token of the app, which can
+// be used by the api client and git operations. Tokens are cached until shortly
+// before they expire.
+func GithubAppInstallationToken(ctx context.Context, app *GithubAppAuth) (string, error) {
+	if app == nil {
+		return "", errors.New("github app auth is required")
+	}
+
+	githubAppTokensMu.Lock()
+	defer githubAppTokensMu.Unlock()
+
+	cacheKey := fmt.Sprintf("%d/%d/%s", app.AppID, app.InstallationID, app.Host)
+	if t, ok := githubAppTokens[cacheKey]; ok && time.Until(t.expiresAt) > githubAppTokenRefresh {
+		return t.token, nil
+	}
+
+	key, err := loadGithubAppKey(app.PrivateKeyPath)
+	if err != nil {
+		return "", err
+	}
+
+	jwt, err := githubAppJWT(app.AppID, key, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	c, err := newGithubClient(app.Host, &http.Client{Transport: &bearerTransport{jwt}})
+	if err != nil {
+		return "", err
+	}
+
+	t, _, err := c.Apps.CreateInstallationToken(ctx, app.InstallationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create github app installation token: %w", err)
+	}
+
+	githubAppTokens[cacheKey] = &githubAppToken{
+		token:     t.GetToken(),
+		expiresAt: t.GetExpiresAt(),
+	}
+
+	return t.GetToken(), nil
+}
+
+// githubAppJWT returns a json web token signed by the app private key, used to
+// create installation tokens
+func githubAppJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]int64{
+		// allow some clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(githubAppJWTExpiration).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// loadGithubAppKey loads the PEM encoded private key, as downloaded from the app
+// settings (PKCS1), or in PKCS8
+func loadGithubAppKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github app private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode github app private key: %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse github app private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github app private key must be an rsa key: %s", path)
+	}
+
+	return rsaKey, nil
+}
+
+func (t *githubAppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := GithubAppInstallationToken(req.Context(), t.app)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newGithubClient returns a client of github.com, or of the GitHub Enterprise
+// server when the host is set
+func newGithubClient(host string, hc *http.Client) (*gh.Client, error) {
+	if host == "" {
+		return gh.NewClient(hc), nil
+	}
+
+	return gh.NewEnterpriseClient(host, host, hc)
+}
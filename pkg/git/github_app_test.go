@@ -0,0 +1,197 @@
+package git
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeGithubAppKey writes a PKCS1 private key, as downloaded from the app
+// settings
+func writeGithubAppKey(t *testing.T, dir string) (string, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	p := filepath.Join(dir, "app.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.NoError(t, ioutil.WriteFile(p, data, 0600))
+
+	return p, key
+}
+
+// verifyGithubAppJWT verifies the token signature and returns its claims
+func verifyGithubAppJWT(t *testing.T, jwt string, pub *rsa.PublicKey) map[string]int64 {
+	parts := strings.Split(jwt, ".")
+	assert.Len(t, parts, 3)
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig))
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, err)
+	claims := map[string]int64{}
+	assert.NoError(t, json.Unmarshal(data, &claims))
+
+	return claims
+}
+
+func Test_githubAppJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+	jwt, err := githubAppJWT(123, key, now)
+	assert.NoError(t, err)
+
+	claims := verifyGithubAppJWT(t, jwt, &key.PublicKey)
+	assert.Equal(t, map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(githubAppJWTExpiration).Unix(),
+		"iss": 123,
+	}, claims)
+}
+
+func Test_GithubAppInstallationToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyPath, key := writeGithubAppKey(t, dir)
+
+	tests := map[string]struct {
+		keyPath      string
+		expiresIn    time.Duration
+		wantErr      string
+		wantMinted   int
+		wantToken    string
+		installation int64
+	}{
+		"Cached": {
+			keyPath:    keyPath,
+			expiresIn:  time.Hour,
+			wantMinted: 1,
+			wantToken:  "ghs_token",
+		},
+		"About to expire": {
+			keyPath:    keyPath,
+			expiresIn:  time.Minute,
+			wantMinted: 2,
+			wantToken:  "ghs_token",
+		},
+		"Missing key": {
+			keyPath: filepath.Join(dir, "missing.pem"),
+			wantErr: "failed to read github app private key",
+		},
+		"Unknown installation": {
+			keyPath:      keyPath,
+			installation: 3,
+			wantErr:      "failed to create github app installation token",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			minted := 0
+			srv := newTestServer(map[string]http.HandlerFunc{
+				"POST /api/v3/app/installations/2/access_tokens": func(w http.ResponseWriter, r *http.Request) {
+					minted++
+					jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+					assert.Equal(t, int64(1), verifyGithubAppJWT(t, jwt, &key.PublicKey)["iss"])
+					writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+						"token":      "ghs_token",
+						"expires_at": time.Now().Add(test.expiresIn).Format(time.RFC3339),
+					})
+				},
+			})
+			defer srv.Close()
+
+			installation := test.installation
+			if installation == 0 {
+				installation = 2
+			}
+
+			app := &GithubAppAuth{
+				AppID:          1,
+				InstallationID: installation,
+				PrivateKeyPath: test.keyPath,
+				Host:           srv.URL,
+			}
+
+			ctx := utils.MockLoggerContext()
+			token, err := GithubAppInstallationToken(ctx, app)
+			if test.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantToken, token)
+
+			// git operations use the same token
+			am, err := getAuth(ctx, &Auth{GithubApp: app}, "")
+			assert.NoError(t, err)
+			assert.Equal(t, &githttp.BasicAuth{Username: "x-access-token", Password: test.wantToken}, am)
+			assert.Equal(t, test.wantMinted, minted)
+		})
+	}
+}
+
+func Test_github_GithubAppAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	keyPath, _ := writeGithubAppKey(t, dir)
+
+	srv := newTestServer(map[string]http.HandlerFunc{
+		"POST /api/v3/app/installations/2/access_tokens": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+				"token":      "ghs_token",
+				"expires_at": time.Now().Add(time.Hour).Format(time.RFC3339),
+			})
+		},
+		"GET /api/v3/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "token ghs_token", r.Header.Get("Authorization"))
+			writeJSON(t, w, http.StatusOK, map[string]interface{}{
+				"clone_url": "https://github.example.com/foo/bar.git",
+			})
+		},
+	})
+	defer srv.Close()
+
+	p, err := newGithub(&Options{
+		Type: "github",
+		Host: srv.URL,
+		Auth: &Auth{
+			GithubApp: &GithubAppAuth{
+				AppID:          1,
+				InstallationID: 2,
+				PrivateKeyPath: keyPath,
+				Host:           srv.URL,
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	cloneURL, err := p.GetRepository(utils.MockLoggerContext(), &GetRepoOptions{Owner: "foo", Name: "bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://github.example.com/foo/bar.git", cloneURL)
+}
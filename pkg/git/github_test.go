@@ -0,0 +1,415 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGithubTestServer(t *testing.T, handlers map[string]http.HandlerFunc) (*httptest.Server, Provider) {
+	srv := newTestServer(handlers)
+	p, err := newGithub(&Options{
+		Type: "github",
+		Auth: &Auth{Password: "token"},
+		Host: srv.URL,
+	})
+	assert.NoError(t, err)
+
+	return srv, p
+}
+
+func Test_github_CreatePullRequest(t *testing.T) {
+	srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v3/repos/foo/bar/pulls": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]string{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]string{
+				"title": "title",
+				"head":  "feature",
+				"base":  "main",
+				"body":  "description",
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+				"number":   1,
+				"state":    "open",
+				"html_url": "https://github.com/foo/bar/pull/1",
+			})
+		},
+	})
+	defer srv.Close()
+
+	pr, err := p.CreatePullRequest(utils.MockLoggerContext(), &PullRequestOptions{
+		RepoURL:     "https://github.com/foo/bar.git",
+		Head:        "feature",
+		Base:        "main",
+		Title:       "title",
+		Description: "description",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &PullRequest{ID: 1, URL: "https://github.com/foo/bar/pull/1"}, pr)
+}
+
+func Test_github_GetPullRequest(t *testing.T) {
+	tests := map[string]struct {
+		response   map[string]interface{}
+		expectedPR *PullRequest
+	}{
+		"Open": {
+			response:   map[string]interface{}{"number": 1, "state": "open"},
+			expectedPR: &PullRequest{ID: 1},
+		},
+		"Merged": {
+			response:   map[string]interface{}{"number": 1, "state": "closed", "merged": true, "merge_commit_sha": "sha"},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Closed": {
+			response:   map[string]interface{}{"number": 1, "state": "closed", "merge_commit_sha": "sha"},
+			expectedPR: &PullRequest{ID: 1, Closed: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v3/repos/foo/bar/pulls/1": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.GetPullRequest(utils.MockLoggerContext(), &GetPullRequestOptions{
+				RepoURL: "git@github.com:foo/bar.git",
+				ID:      1,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_github_ValidateAccess(t *testing.T) {
+	tests := map[string]struct {
+		opts        *ValidateAccessOptions
+		handlers    map[string]http.HandlerFunc
+		expectedErr string
+	}{
+		"Push": {
+			opts: &ValidateAccessOptions{RepoURL: "https://github.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"private":     true,
+						"permissions": map[string]bool{"pull": true, "push": true},
+					})
+				},
+			},
+		},
+		"Push without permission": {
+			opts: &ValidateAccessOptions{RepoURL: "https://github.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"permissions": map[string]bool{"pull": true, "push": false},
+					})
+				},
+			},
+			expectedErr: "git access denied: the git token can not push to repository foo/bar",
+		},
+		"Push without scope": {
+			opts: &ValidateAccessOptions{RepoURL: "https://github.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/repos/foo/bar": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-OAuth-Scopes", "public_repo")
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"private":     true,
+						"permissions": map[string]bool{"push": true},
+					})
+				},
+			},
+			expectedErr: `git access denied: the git token is missing the "repo" scope, required to push to repository foo/bar`,
+		},
+		"Push to missing repo": {
+			opts:        &ValidateAccessOptions{RepoURL: "https://github.com/foo/bar.git"},
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: "git access denied: repository foo/bar does not exist, or the git token can not access it",
+		},
+		"Invalid token": {
+			opts: &ValidateAccessOptions{Owner: "foo", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusUnauthorized, map[string]string{"message": "Bad credentials"})
+				},
+			},
+			expectedErr: "git access denied: the git token is invalid or expired",
+		},
+		"Create user repo": {
+			opts: &ValidateAccessOptions{Owner: "foo", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-OAuth-Scopes", "repo")
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+			},
+		},
+		"Create without scope": {
+			opts: &ValidateAccessOptions{Owner: "foo", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("X-OAuth-Scopes", "public_repo, gist")
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+			},
+			expectedErr: `git access denied: the git token is missing the "repo" scope, required to create the repository`,
+		},
+		"Create in org as member": {
+			opts: &ValidateAccessOptions{Owner: "org", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+				"GET /api/v3/user/memberships/orgs/org": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"state": "active", "role": "member"})
+				},
+				"GET /api/v3/orgs/org": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]bool{"members_can_create_private_repositories": false})
+				},
+			},
+			expectedErr: "git access denied: members of the organization org can not create repositories, user foo must be an owner",
+		},
+		"Create in org as admin": {
+			opts: &ValidateAccessOptions{Owner: "org", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+				"GET /api/v3/user/memberships/orgs/org": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"state": "active", "role": "admin"})
+				},
+			},
+		},
+		"Create in other org": {
+			opts: &ValidateAccessOptions{Owner: "org", Name: "bar", Private: true},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+			},
+			expectedErr: "git access denied: user foo is not a member of the organization org",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGithubTestServer(t, test.handlers)
+			defer srv.Close()
+
+			err := p.ValidateAccess(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.True(t, errors.Is(err, ErrAccessDenied))
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_github_CreateRepository(t *testing.T) {
+	tests := map[string]struct {
+		opts         *CreateRepoOptions
+		expectedBody map[string]interface{}
+		expectedErr  string
+	}{
+		"User repo": {
+			opts: &CreateRepoOptions{Owner: "foo", Name: "bar", Private: true},
+			expectedBody: map[string]interface{}{
+				"name":    "bar",
+				"private": true,
+			},
+		},
+		"Org repo": {
+			opts: &CreateRepoOptions{
+				Owner:       "org",
+				Name:        "bar",
+				Description: "gitops",
+				Visibility:  RepoVisibilityInternal,
+				Teams:       []*TeamAccess{{Slug: "devops", Permission: "maintain"}},
+			},
+			expectedBody: map[string]interface{}{
+				"name":        "bar",
+				"description": "gitops",
+				"private":     true,
+				"visibility":  "internal",
+			},
+		},
+		"User repo with teams": {
+			opts:        &CreateRepoOptions{Owner: "foo", Name: "bar", Teams: []*TeamAccess{{Slug: "devops", Permission: "push"}}},
+			expectedErr: "teams can only be granted access to organization repositories, foo is a user",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			route := "POST /api/v3/user/repos"
+			if test.opts.Owner == "org" {
+				route = "POST /api/v3/orgs/org/repos"
+			}
+
+			teamGranted := false
+			srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v3/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]string{"login": "foo"})
+				},
+				route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, test.expectedBody, body)
+					writeJSON(t, w, http.StatusCreated, map[string]string{"clone_url": "https://github.com/foo/bar.git"})
+				},
+				"PUT /api/v3/orgs/org/teams/devops/repos/org/bar": func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]string{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, map[string]string{"permission": "maintain"}, body)
+					teamGranted = true
+					w.WriteHeader(http.StatusNoContent)
+				},
+			})
+			defer srv.Close()
+
+			url, err := p.CreateRepository(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "https://github.com/foo/bar.git", url)
+			assert.Equal(t, len(test.opts.Teams) > 0, teamGranted)
+		})
+	}
+}
+
+func Test_github_ProtectBranch(t *testing.T) {
+	srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+		"PUT /api/v3/repos/foo/bar/branches/main/protection": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{
+				"required_status_checks": map[string]interface{}{
+					"strict":   true,
+					"contexts": []interface{}{"ci"},
+				},
+				"required_pull_request_reviews": map[string]interface{}{
+					"dismiss_stale_reviews":           false,
+					"require_code_owner_reviews":      true,
+					"required_approving_review_count": float64(2),
+				},
+				"enforce_admins": true,
+				"restrictions":   nil,
+			}, body)
+			writeJSON(t, w, http.StatusOK, map[string]interface{}{})
+		},
+	})
+	defer srv.Close()
+
+	err := p.(BranchProtector).ProtectBranch(utils.MockLoggerContext(), &ProtectBranchOptions{
+		RepoURL:                 "https://github.com/foo/bar.git",
+		Branch:                  "main",
+		RequiredApprovals:       2,
+		RequireCodeOwnerReviews: true,
+		RequiredStatusChecks:    []string{"ci"},
+		EnforceAdmins:           true,
+	})
+	assert.NoError(t, err)
+}
+
+func Test_github_AddDeployKey(t *testing.T) {
+	srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v3/repos/foo/bar/keys": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{
+				"title":     "argo-cd",
+				"key":       "ssh-ed25519 AAAA",
+				"read_only": true,
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{"id": 1})
+		},
+	})
+	defer srv.Close()
+
+	err := p.AddDeployKey(utils.MockLoggerContext(), &DeployKeyOptions{
+		RepoURL:   "https://github.com/foo/bar.git",
+		Title:     "argo-cd",
+		PublicKey: "ssh-ed25519 AAAA",
+		ReadOnly:  true,
+	})
+	assert.NoError(t, err)
+}
+
+func Test_github_CreateWebhook(t *testing.T) {
+	expectedHook := map[string]interface{}{
+		"config": map[string]interface{}{
+			"url":          "https://argocd.example.com/api/webhook",
+			"content_type": "json",
+			"secret":       "secret",
+		},
+		"events": []interface{}{"push"},
+		"active": true,
+	}
+
+	tests := map[string]struct {
+		hooks []map[string]interface{}
+		route string
+	}{
+		"New": {
+			hooks: []map[string]interface{}{
+				{"id": 1, "config": map[string]string{"url": "https://ci.example.com"}},
+			},
+			route: "POST /api/v3/repos/foo/bar/hooks",
+		},
+		"Existing": {
+			hooks: []map[string]interface{}{
+				{"id": 1, "config": map[string]string{"url": "https://ci.example.com"}},
+				{"id": 2, "config": map[string]string{"url": "https://argocd.example.com/api/webhook"}},
+			},
+			route: "PATCH /api/v3/repos/foo/bar/hooks/2",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			srv, p := newGithubTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v3/repos/foo/bar/hooks": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.hooks)
+				},
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					// only sent when creating
+					delete(body, "name")
+					assert.Equal(t, expectedHook, body)
+					called = true
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": 2})
+				},
+			})
+			defer srv.Close()
+
+			err := p.(WebhookCreator).CreateWebhook(utils.MockLoggerContext(), &WebhookOptions{
+				RepoURL: "https://github.com/foo/bar.git",
+				URL:     "https://argocd.example.com/api/webhook",
+				Secret:  "secret",
+			})
+			assert.NoError(t, err)
+			assert.True(t, called)
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/codefresh-io/cf-argo/pkg/log"
+
+	gl "github.com/xanzy/go-gitlab"
+)
+
+type gitlab struct {
+	opts   *Options
+	client *gl.Client
+}
+
+const gitlabHost = "https://gitlab.com"
+
+func newGitlab(opts *Options) (Provider, error) {
+	token := ""
+	if opts.Auth != nil {
+		token = opts.Auth.Password
+	}
+
+	clientOpts := []gl.ClientOptionFunc{}
+	if opts.Host != "" {
+		clientOpts = append(clientOpts, gl.WithBaseURL(opts.Host))
+	}
+
+	c, err := gl.NewClient(token, clientOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gitlab{
+		opts:   opts,
+		client: c,
+	}
+	return g, nil
+}
+
+// gitlabRepoURL returns the repository url on gitlab.com, or on the self
+// managed server when the host is set
+func gitlabRepoURL(host, owner, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gitlabBaseURL(host), owner, name)
+}
+
+func gitlabSSHRepoURL(host, owner, name string) (string, error) {
+	hostname, err := hostnameOf(gitlabBaseURL(host))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("git@%s:%s/%s.git", hostname, owner, name), nil
+}
+
+// gitlabBaseURL returns the web url of the server, the host might be given as
+// the api url
+func gitlabBaseURL(host string) string {
+	if host == "" {
+		return gitlabHost
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(host, "/"), "/api/v4")
+}
+
+func (g *gitlab) GetRepository(ctx context.Context, opts *GetRepoOptions) (string, error) {
+	p, res, err := g.client.Projects.GetProject(fmt.Sprintf("%s/%s", opts.Owner, opts.Name), nil, gl.WithContext(ctx))
+	if err != nil {
+		if res != nil && res.StatusCode == 404 {
+			return "", ErrRepoNotFound
+		}
+		return "", err
+	}
+
+	return p.HTTPURLToRepo, nil
+}
+
+func (g *gitlab) CreateRepository(ctx context.Context, opts *CreateRepoOptions) (string, error) {
+	l := log.G(ctx).WithFields(log.Fields{
+		"owner": opts.Owner,
+		"repo":  opts.Name,
+	})
+
+	l.Debug("creating repository")
+
+	authUser, _, err := g.client.Users.CurrentUser(gl.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	visibility := gl.PublicVisibility
+	if opts.Private {
+		visibility = gl.PrivateVisibility
+	}
+
+	createOpts := &gl.CreateProjectOptions{
+		Name:       gl.String(opts.Name),
+		Visibility: gl.Visibility(visibility),
+	}
+
+	// repositories outside of the user namespace are created in a group namespace
+	if authUser.Username != opts.Owner {
+		ns, _, err := g.client.Namespaces.GetNamespace(opts.Owner, gl.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+
+		createOpts.NamespaceID = gl.Int(ns.ID)
+	}
+
+	p, _, err := g.client.Projects.CreateProject(createOpts, gl.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	if p.HTTPURLToRepo == "" {
+		return "", fmt.Errorf("repo clone url is empty")
+	}
+
+	l.Debug("repository created")
+
+	return p.HTTPURLToRepo, nil
+}
+
+func (g *gitlab) CloneRepository(ctx context.Context, cloneURL string) (Repository, error) {
+	return cloneRepository(ctx, &CloneOptions{URL: cloneURL, Auth: g.opts.Auth}, g.opts.InMemory)
+}
+
+func (g *gitlab) CreatePullRequest(ctx context.Context, opts *PullRequestOptions) (*PullRequest, error) {
+	pid, err := repoPathOf(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mr, _, err := g.client.MergeRequests.CreateMergeRequest(pid, &gl.CreateMergeRequestOptions{
+		Title:        gl.String(opts.Title),
+		Description:  gl.String(opts.Description),
+		SourceBranch: gl.String(opts.Head),
+		TargetBranch: gl.String(opts.Base),
+	}, gl.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return gitlabPullRequest(mr), nil
+}
+
+func (g *gitlab) GetPullRequest(ctx context.Context, opts *GetPullRequestOptions) (*PullRequest, error) {
+	pid, err := repoPathOf(opts.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	mr, _, err := g.client.MergeRequests.GetMergeRequest(pid, opts.ID, nil, gl.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return gitlabPullRequest(mr), nil
+}
+
+func (g *gitlab) ValidateAccess(ctx context.Context, opts *ValidateAccessOptions) error {
+	if opts.RepoURL != "" {
+		return g.validatePushAccess(ctx, opts.RepoURL)
+	}
+
+	user, res, err := g.client.Users.CurrentUser(gl.WithContext(ctx))
+	if err != nil {
+		return gitlabAccessErr(res, err)
+	}
+
+	if user.Username == opts.Owner {
+		if !user.CanCreateProject && !user.IsAdmin {
+			return accessDenied("user %s can not create projects", user.Username)
+		}
+		return nil
+	}
+
+	groups, res, err := g.client.Groups.ListGroups(&gl.ListGroupsOptions{
+		MinAccessLevel: gl.AccessLevel(gl.DeveloperPermissions),
+		Search:         gl.String(opts.Owner),
+	}, gl.WithContext(ctx))
+	if err != nil {
+		return gitlabAccessErr(res, err)
+	}
+
+	for _, group := range groups {
+		if group.FullPath == opts.Owner {
+			return nil
+		}
+	}
+
+	return accessDenied("user %s must have at least developer access to the group %s, to create a project in it", user.Username, opts.Owner)
+}
+
+func (g *gitlab) validatePushAccess(ctx context.Context, repoURL string) error {
+	pid, err := repoPathOf(repoURL)
+	if err != nil {
+		return err
+	}
+
+	p, res, err := g.client.Projects.GetProject(pid, nil, gl.WithContext(ctx))
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return accessDenied("project %s does not exist, or the git token can not access it", pid)
+		}
+		return gitlabAccessErr(res, err)
+	}
+
+	level := gl.NoPermissions
+	if p.Permissions != nil {
+		if p.Permissions.ProjectAccess != nil && p.Permissions.ProjectAccess.AccessLevel > level {
+			level = p.Permissions.ProjectAccess.AccessLevel
+		}
+		if p.Permissions.GroupAccess != nil && p.Permissions.GroupAccess.AccessLevel > level {
+			level = p.Permissions.GroupAccess.AccessLevel
+		}
+	}
+
+	if level < gl.DeveloperPermissions {
+		return accessDenied("the git token must have at least developer access to project %s, to push to it", pid)
+	}
+
+	return nil
+}
+
+// gitlabAccessErr reports authentication and scope errors as ErrAccessDenied
+func gitlabAccessErr(res *gl.Response, err error) error {
+	if res == nil {
+		return err
+	}
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return accessDenied("the git token is invalid or expired")
+	case http.StatusForbidden:
+		return accessDenied("the git token is missing the \"api\" scope: %s", err)
+	}
+
+	return err
+}
+
+func (g *gitlab) AddDeployKey(ctx context.Context, opts *DeployKeyOptions) error {
+	pid, err := repoPathOf(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = g.client.DeployKeys.AddDeployKey(pid, &gl.AddDeployKeyOptions{
+		Title:   gl.String(opts.Title),
+		Key:     gl.String(opts.PublicKey),
+		CanPush: gl.Bool(!opts.ReadOnly),
+	}, gl.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to add deploy key to project %s: %w", pid, err)
+	}
+
+	return nil
+}
+
+// CreateWebhook creates a push hook, gitlab sends the secret as a token instead
+// of signing the payload
+func (g *gitlab) CreateWebhook(ctx context.Context, opts *WebhookOptions) error {
+	pid, err := repoPathOf(opts.RepoURL)
+	if err != nil {
+		return err
+	}
+
+	hooks, _, err := g.client.Projects.ListProjectHooks(pid, &gl.ListProjectHooksOptions{PerPage: 100}, gl.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to list webhooks of project %s: %w", pid, err)
+	}
+
+	for _, h := range hooks {
+		if h.URL == opts.URL {
+			_, _, err = g.client.Projects.EditProjectHook(pid, h.ID, &gl.EditProjectHookOptions{
+				URL:        gl.String(opts.URL),
+				Token:      gl.String(opts.Secret),
+				PushEvents: gl.Bool(true),
+			}, gl.WithContext(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to update webhook of project %s: %w", pid, err)
+			}
+			return nil
+		}
+	}
+
+	_, _, err = g.client.Projects.AddProjectHook(pid, &gl.AddProjectHookOptions{
+		URL:        gl.String(opts.URL),
+		Token:      gl.String(opts.Secret),
+		PushEvents: gl.Bool(true),
+	}, gl.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook of project %s: %w", pid, err)
+	}
+
+	return nil
+}
+
+func gitlabPullRequest(mr *gl.MergeRequest) *PullRequest {
+	res := &PullRequest{
+		ID:     mr.IID,
+		URL:    mr.WebURL,
+		Merged: mr.State == "merged",
+		Closed: mr.State == "closed",
+	}
+
+	if res.Merged {
+		res.MergeCommit = mr.MergeCommitSHA
+		if res.MergeCommit == "" {
+			// fast-forward merges of squashed commits
+			res.MergeCommit = mr.SquashCommitSHA
+		}
+	}
+
+	return res
+}
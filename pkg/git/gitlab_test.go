@@ -0,0 +1,371 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGitlabTestServer(t *testing.T, handlers map[string]http.HandlerFunc) (*httptest.Server, Provider) {
+	srv := newTestServer(handlers)
+	p, err := newGitlab(&Options{
+		Type: "gitlab",
+		Auth: &Auth{Password: "token"},
+		Host: srv.URL,
+	})
+	assert.NoError(t, err)
+
+	return srv, p
+}
+
+func Test_gitlab_GetRepository(t *testing.T) {
+	tests := map[string]struct {
+		handlers    map[string]http.HandlerFunc
+		expectedURL string
+		expectedErr string
+	}{
+		"Exists": {
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "token", r.Header.Get("Private-Token"))
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"id":               1,
+						"http_url_to_repo": "https://gitlab.com/foo/bar.git",
+					})
+				},
+			},
+			expectedURL: "https://gitlab.com/foo/bar.git",
+		},
+		"Not found": {
+			handlers:    map[string]http.HandlerFunc{},
+			expectedErr: ErrRepoNotFound.Error(),
+		},
+		"Forbidden": {
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusForbidden, map[string]string{"message": "403 Forbidden"})
+				},
+			},
+			expectedErr: "403 Forbidden",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGitlabTestServer(t, test.handlers)
+			defer srv.Close()
+
+			url, err := p.GetRepository(utils.MockLoggerContext(), &GetRepoOptions{
+				Owner: "foo",
+				Name:  "bar",
+			})
+			if test.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedURL, url)
+		})
+	}
+}
+
+func Test_gitlab_CreateRepository(t *testing.T) {
+	tests := map[string]struct {
+		owner               string
+		private             bool
+		expectedNamespaceID interface{}
+		expectedVisibility  string
+	}{
+		"User namespace": {
+			owner:               "user",
+			private:             true,
+			expectedNamespaceID: nil,
+			expectedVisibility:  "private",
+		},
+		"Group namespace": {
+			owner:               "group",
+			private:             false,
+			expectedNamespaceID: float64(42),
+			expectedVisibility:  "public",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGitlabTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v4/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": 1, "username": "user"})
+				},
+				"GET /api/v4/namespaces/group": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": 42, "path": "group", "kind": "group"})
+				},
+				"POST /api/v4/projects": func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, "bar", body["name"])
+					assert.Equal(t, test.expectedVisibility, body["visibility"])
+					assert.Equal(t, test.expectedNamespaceID, body["namespace_id"])
+					writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+						"id":               2,
+						"http_url_to_repo": fmt.Sprintf("https://gitlab.com/%s/bar.git", test.owner),
+					})
+				},
+			})
+			defer srv.Close()
+
+			url, err := p.CreateRepository(utils.MockLoggerContext(), &CreateRepoOptions{
+				Owner:   test.owner,
+				Name:    "bar",
+				Private: test.private,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("https://gitlab.com/%s/bar.git", test.owner), url)
+		})
+	}
+}
+
+func Test_gitlab_CreatePullRequest(t *testing.T) {
+	srv, p := newGitlabTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v4/projects/group%2Fsub%2Fbar/merge_requests": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]string{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]string{
+				"title":         "title",
+				"description":   "description",
+				"source_branch": "feature",
+				"target_branch": "main",
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{
+				"iid":     1,
+				"state":   "opened",
+				"web_url": "https://gitlab.com/group/sub/bar/-/merge_requests/1",
+			})
+		},
+	})
+	defer srv.Close()
+
+	pr, err := p.CreatePullRequest(utils.MockLoggerContext(), &PullRequestOptions{
+		RepoURL:     "https://gitlab.com/group/sub/bar.git",
+		Head:        "feature",
+		Base:        "main",
+		Title:       "title",
+		Description: "description",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &PullRequest{ID: 1, URL: "https://gitlab.com/group/sub/bar/-/merge_requests/1"}, pr)
+}
+
+func Test_gitlab_GetPullRequest(t *testing.T) {
+	tests := map[string]struct {
+		response   map[string]interface{}
+		expectedPR *PullRequest
+	}{
+		"Open": {
+			response:   map[string]interface{}{"iid": 1, "state": "opened"},
+			expectedPR: &PullRequest{ID: 1},
+		},
+		"Merged": {
+			response:   map[string]interface{}{"iid": 1, "state": "merged", "merge_commit_sha": "sha"},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Squashed": {
+			response:   map[string]interface{}{"iid": 1, "state": "merged", "squash_commit_sha": "sha"},
+			expectedPR: &PullRequest{ID: 1, Merged: true, MergeCommit: "sha"},
+		},
+		"Closed": {
+			response:   map[string]interface{}{"iid": 1, "state": "closed"},
+			expectedPR: &PullRequest{ID: 1, Closed: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGitlabTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar/merge_requests/1": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.response)
+				},
+			})
+			defer srv.Close()
+
+			pr, err := p.GetPullRequest(utils.MockLoggerContext(), &GetPullRequestOptions{
+				RepoURL: "git@gitlab.com:foo/bar.git",
+				ID:      1,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedPR, pr)
+		})
+	}
+}
+
+func Test_gitlab_ValidateAccess(t *testing.T) {
+	tests := map[string]struct {
+		opts        *ValidateAccessOptions
+		handlers    map[string]http.HandlerFunc
+		expectedErr string
+	}{
+		"Push": {
+			opts: &ValidateAccessOptions{RepoURL: "https://gitlab.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"permissions": map[string]interface{}{
+							"group_access": map[string]int{"access_level": 30},
+						},
+					})
+				},
+			},
+		},
+		"Push as reporter": {
+			opts: &ValidateAccessOptions{RepoURL: "https://gitlab.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{
+						"permissions": map[string]interface{}{
+							"project_access": map[string]int{"access_level": 20},
+						},
+					})
+				},
+			},
+			expectedErr: "git access denied: the git token must have at least developer access to project foo/bar, to push to it",
+		},
+		"Missing scope": {
+			opts: &ValidateAccessOptions{RepoURL: "https://gitlab.com/foo/bar.git"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusForbidden, map[string]string{"error": "insufficient_scope"})
+				},
+			},
+			expectedErr: `git access denied: the git token is missing the "api" scope`,
+		},
+		"Create user project": {
+			opts: &ValidateAccessOptions{Owner: "foo", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"username": "foo", "can_create_project": false})
+				},
+			},
+			expectedErr: "git access denied: user foo can not create projects",
+		},
+		"Create group project": {
+			opts: &ValidateAccessOptions{Owner: "group/sub", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"username": "foo"})
+				},
+				"GET /api/v4/groups": func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, "30", r.URL.Query().Get("min_access_level"))
+					writeJSON(t, w, http.StatusOK, []map[string]string{{"full_path": "group/sub"}})
+				},
+			},
+		},
+		"Create in other group": {
+			opts: &ValidateAccessOptions{Owner: "group", Name: "bar"},
+			handlers: map[string]http.HandlerFunc{
+				"GET /api/v4/user": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"username": "foo"})
+				},
+				"GET /api/v4/groups": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, []map[string]string{{"full_path": "group/sub"}})
+				},
+			},
+			expectedErr: "git access denied: user foo must have at least developer access to the group group, to create a project in it",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newGitlabTestServer(t, test.handlers)
+			defer srv.Close()
+
+			err := p.ValidateAccess(utils.MockLoggerContext(), test.opts)
+			if test.expectedErr != "" {
+				assert.True(t, errors.Is(err, ErrAccessDenied))
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_gitlab_AddDeployKey(t *testing.T) {
+	srv, p := newGitlabTestServer(t, map[string]http.HandlerFunc{
+		"POST /api/v4/projects/foo%2Fbar/deploy_keys": func(w http.ResponseWriter, r *http.Request) {
+			body := map[string]interface{}{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]interface{}{
+				"title":    "argo-cd",
+				"key":      "ssh-ed25519 AAAA",
+				"can_push": false,
+			}, body)
+			writeJSON(t, w, http.StatusCreated, map[string]interface{}{"id": 1})
+		},
+	})
+	defer srv.Close()
+
+	err := p.AddDeployKey(utils.MockLoggerContext(), &DeployKeyOptions{
+		RepoURL:   "https://gitlab.com/foo/bar.git",
+		Title:     "argo-cd",
+		PublicKey: "ssh-ed25519 AAAA",
+		ReadOnly:  true,
+	})
+	assert.NoError(t, err)
+}
+
+func Test_gitlab_CreateWebhook(t *testing.T) {
+	tests := map[string]struct {
+		hooks []map[string]interface{}
+		route string
+	}{
+		"New": {
+			hooks: []map[string]interface{}{},
+			route: "POST /api/v4/projects/foo%2Fbar/hooks",
+		},
+		"Existing": {
+			hooks: []map[string]interface{}{
+				{"id": 2, "url": "https://argocd.example.com/api/webhook"},
+			},
+			route: "PUT /api/v4/projects/foo%2Fbar/hooks/2",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			called := false
+			srv, p := newGitlabTestServer(t, map[string]http.HandlerFunc{
+				"GET /api/v4/projects/foo%2Fbar/hooks": func(w http.ResponseWriter, r *http.Request) {
+					writeJSON(t, w, http.StatusOK, test.hooks)
+				},
+				test.route: func(w http.ResponseWriter, r *http.Request) {
+					body := map[string]interface{}{}
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, map[string]interface{}{
+						"url":         "https://argocd.example.com/api/webhook",
+						"token":       "secret",
+						"push_events": true,
+					}, body)
+					called = true
+					writeJSON(t, w, http.StatusOK, map[string]interface{}{"id": 2})
+				},
+			})
+			defer srv.Close()
+
+			err := p.(WebhookCreator).CreateWebhook(utils.MockLoggerContext(), &WebhookOptions{
+				RepoURL: "https://gitlab.com/foo/bar.git",
+				URL:     "https://argocd.example.com/api/webhook",
+				Secret:  "secret",
+			})
+			assert.NoError(t, err)
+			assert.True(t, called)
+		})
+	}
+}
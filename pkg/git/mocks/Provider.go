@@ -14,6 +14,20 @@ type Provider struct {
 	mock.Mock
 }
 
+// AddDeployKey provides a mock function with given fields: ctx, opts
+func (_m *Provider) AddDeployKey(ctx context.Context, opts *git.DeployKeyOptions) error {
+	ret := _m.Called(ctx, opts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *git.DeployKeyOptions) error); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CloneRepository provides a mock function with given fields: ctx, cloneURL
 func (_m *Provider) CloneRepository(ctx context.Context, cloneURL string) (git.Repository, error) {
 	ret := _m.Called(ctx, cloneURL)
@@ -37,6 +51,29 @@ func (_m *Provider) CloneRepository(ctx context.Context, cloneURL string) (git.R
 	return r0, r1
 }
 
+// CreatePullRequest provides a mock function with given fields: ctx, opts
+func (_m *Provider) CreatePullRequest(ctx context.Context, opts *git.PullRequestOptions) (*git.PullRequest, error) {
+	ret := _m.Called(ctx, opts)
+
+	var r0 *git.PullRequest
+	if rf, ok := ret.Get(0).(func(context.Context, *git.PullRequestOptions) *git.PullRequest); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*git.PullRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *git.PullRequestOptions) error); ok {
+		r1 = rf(ctx, opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // CreateRepository provides a mock function with given fields: ctx, opts
 func (_m *Provider) CreateRepository(ctx context.Context, opts *git.CreateRepoOptions) (string, error) {
 	ret := _m.Called(ctx, opts)
@@ -58,6 +95,29 @@ func (_m *Provider) CreateRepository(ctx context.Context, opts *git.CreateRepoOp
 	return r0, r1
 }
 
+// GetPullRequest provides a mock function with given fields: ctx, opts
+func (_m *Provider) GetPullRequest(ctx context.Context, opts *git.GetPullRequestOptions) (*git.PullRequest, error) {
+	ret := _m.Called(ctx, opts)
+
+	var r0 *git.PullRequest
+	if rf, ok := ret.Get(0).(func(context.Context, *git.GetPullRequestOptions) *git.PullRequest); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*git.PullRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *git.GetPullRequestOptions) error); ok {
+		r1 = rf(ctx, opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetRepository provides a mock function with given fields: ctx, opts
 func (_m *Provider) GetRepository(ctx context.Context, opts *git.GetRepoOptions) (string, error) {
 	ret := _m.Called(ctx, opts)
@@ -78,3 +138,17 @@ func (_m *Provider) GetRepository(ctx context.Context, opts *git.GetRepoOptions)
 
 	return r0, r1
 }
+
+// ValidateAccess provides a mock function with given fields: ctx, opts
+func (_m *Provider) ValidateAccess(ctx context.Context, opts *git.ValidateAccessOptions) error {
+	ret := _m.Called(ctx, opts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *git.ValidateAccessOptions) error); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
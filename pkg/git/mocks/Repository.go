@@ -6,6 +6,7 @@ import (
 	context "context"
 
 	git "github.com/codefresh-io/cf-argo/pkg/git"
+	billy "github.com/go-git/go-billy/v5"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -42,20 +43,115 @@ func (_m *Repository) AddRemote(ctx context.Context, name string, url string) er
 	return r0
 }
 
-// Commit provides a mock function with given fields: ctx, msg
-func (_m *Repository) Commit(ctx context.Context, msg string) (string, error) {
-	ret := _m.Called(ctx, msg)
+// Checkout provides a mock function with given fields: ctx, branch
+func (_m *Repository) Checkout(ctx context.Context, branch string) error {
+	ret := _m.Called(ctx, branch)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, branch)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Commit provides a mock function with given fields: ctx, opts
+func (_m *Repository) Commit(ctx context.Context, opts *git.CommitOptions) (string, error) {
+	ret := _m.Called(ctx, opts)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, *git.CommitOptions) string); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *git.CommitOptions) error); ok {
+		r1 = rf(ctx, opts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateBranch provides a mock function with given fields: ctx, name
+func (_m *Repository) CreateBranch(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CurrentBranch provides a mock function with given fields:
+func (_m *Repository) CurrentBranch() (string, error) {
+	ret := _m.Called()
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
-		r0 = rf(ctx, msg)
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, msg)
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Diff provides a mock function with given fields: from, to
+func (_m *Repository) Diff(from string, to string) ([]*git.FileDiff, error) {
+	ret := _m.Called(from, to)
+
+	var r0 []*git.FileDiff
+	if rf, ok := ret.Get(0).(func(string, string) []*git.FileDiff); ok {
+		r0 = rf(from, to)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*git.FileDiff)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(from, to)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Filesystem provides a mock function with given fields:
+func (_m *Repository) Filesystem() (billy.Filesystem, error) {
+	ret := _m.Called()
+
+	var r0 billy.Filesystem
+	if rf, ok := ret.Get(0).(func() billy.Filesystem); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(billy.Filesystem)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -84,6 +180,29 @@ func (_m *Repository) IsNewRepo() (bool, error) {
 	return r0, r1
 }
 
+// Log provides a mock function with given fields: path
+func (_m *Repository) Log(path string) ([]*git.CommitInfo, error) {
+	ret := _m.Called(path)
+
+	var r0 []*git.CommitInfo
+	if rf, ok := ret.Get(0).(func(string) []*git.CommitInfo); ok {
+		r0 = rf(path)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*git.CommitInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Push provides a mock function with given fields: _a0, _a1
 func (_m *Repository) Push(_a0 context.Context, _a1 *git.PushOptions) error {
 	ret := _m.Called(_a0, _a1)
@@ -98,6 +217,20 @@ func (_m *Repository) Push(_a0 context.Context, _a1 *git.PushOptions) error {
 	return r0
 }
 
+// ResetToRemote provides a mock function with given fields: ctx, opts
+func (_m *Repository) ResetToRemote(ctx context.Context, opts *git.FetchOptions) error {
+	ret := _m.Called(ctx, opts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *git.FetchOptions) error); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Root provides a mock function with given fields:
 func (_m *Repository) Root() (string, error) {
 	ret := _m.Called()
@@ -118,3 +251,26 @@ func (_m *Repository) Root() (string, error) {
 
 	return r0, r1
 }
+
+// Status provides a mock function with given fields:
+func (_m *Repository) Status() ([]*git.FileStatus, error) {
+	ret := _m.Called()
+
+	var r0 []*git.FileStatus
+	if rf, ok := ret.Get(0).(func() []*git.FileStatus); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*git.FileStatus)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
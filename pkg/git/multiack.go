@@ -0,0 +1,110 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+type multiAckKey struct{}
+
+// go-git does not implement the multi_ack negotiation, and filters the
+// capability out of every remote. Azure devops does not serve upload-pack
+// requests without it. go-git sends all of its haves in a single request, so
+// the response of a multi_ack request is a list of ACK lines, which go-git
+// reads the same as a single ack response. The http(s) transports add the
+// capability to the upload-pack requests made with multiAckContext, all other
+// requests are sent unchanged.
+func init() {
+	c := githttp.NewClient(&http.Client{
+		Transport: &multiAckTransport{http.DefaultTransport},
+	})
+	client.InstallProtocol("http", c)
+	client.InstallProtocol("https", c)
+}
+
+// multiAckContext returns a context that makes the upload-pack requests of
+// git operations with it request the multi_ack capability
+func multiAckContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, multiAckKey{}, true)
+}
+
+// isAzureURL returns true for the urls of the azure devops services, which
+// require multi_ack
+func isAzureURL(rawURL string) bool {
+	ep, err := transport.NewEndpoint(rawURL)
+	if err != nil {
+		return false
+	}
+
+	return ep.Host == "dev.azure.com" || ep.Host == azureSSHHost || strings.HasSuffix(ep.Host, ".visualstudio.com")
+}
+
+type multiAckTransport struct {
+	http.RoundTripper
+}
+
+func (t *multiAckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	multiAck, _ := req.Context().Value(multiAckKey{}).(bool)
+	if !multiAck || req.Body == nil || !strings.HasSuffix(req.URL.Path, "/"+transport.UploadPackServiceName) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = req.Body.Close(); err != nil {
+		return nil, err
+	}
+
+	if data, err = addMultiAck(data); err != nil {
+		return nil, err
+	}
+
+	// the request must not be modified by a round tripper
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// addMultiAck adds the multi_ack capability to the first "want" line of an
+// upload-pack request, which lists the capabilities of the request
+func addMultiAck(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("malformed upload-pack request")
+	}
+
+	n, err := strconv.ParseUint(string(data[:4]), 16, 16)
+	if err != nil || n < 4 || int(n) > len(data) {
+		return nil, fmt.Errorf("malformed upload-pack request")
+	}
+
+	line := strings.TrimSuffix(string(data[4:n]), "\n")
+	if !strings.HasPrefix(line, "want ") {
+		return nil, fmt.Errorf("malformed upload-pack request: %q", line)
+	}
+
+	for _, c := range strings.Fields(line)[2:] {
+		if c == capability.MultiACK.String() || c == capability.MultiACKDetailed.String() {
+			return data, nil
+		}
+	}
+
+	line = fmt.Sprintf("%s %s\n", line, capability.MultiACK)
+	res := []byte(fmt.Sprintf("%04x%s", len(line)+4, line))
+	return append(res, data[n:]...), nil
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_addMultiAck(t *testing.T) {
+	tests := map[string]struct {
+		data        string
+		expected    string
+		expectedErr string
+	}{
+		"Capabilities": {
+			data:     "004awant 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a side-band-64k ofs-delta\n000ddeepen 1\n00000009done\n",
+			expected: "0054want 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a side-band-64k ofs-delta multi_ack\n000ddeepen 1\n00000009done\n",
+		},
+		"No capabilities": {
+			data:     "0032want 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a\n00000009done\n",
+			expected: "003cwant 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a multi_ack\n00000009done\n",
+		},
+		"Already requested": {
+			data:     "0046want 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a ofs-delta multi_ack\n00000009done\n",
+			expected: "0046want 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a ofs-delta multi_ack\n00000009done\n",
+		},
+		"Malformed length": {
+			data:        "zzzzwant",
+			expectedErr: "malformed upload-pack request",
+		},
+		"Not a want line": {
+			data:        "0009done\n",
+			expectedErr: "malformed upload-pack request: \"done\"",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			got, err := addMultiAck([]byte(tt.data))
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(got))
+		})
+	}
+}
+
+func Test_multiAckTransport(t *testing.T) {
+	const body = "0032want 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a\n00000009done\n"
+
+	tests := map[string]struct {
+		path     string
+		multiAck bool
+		expected string
+	}{
+		"Upload-pack with multi_ack": {
+			path:     "/repo.git/git-upload-pack",
+			multiAck: true,
+			expected: "003cwant 7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a7f3a1a1a multi_ack\n00000009done\n",
+		},
+		"Upload-pack without multi_ack": {
+			path:     "/repo.git/git-upload-pack",
+			expected: body,
+		},
+		"Receive-pack": {
+			path:     "/repo.git/git-receive-pack",
+			multiAck: true,
+			expected: body,
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, err := ioutil.ReadAll(r.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, string(data))
+				assert.Equal(t, int64(len(tt.expected)), r.ContentLength)
+			}))
+			defer srv.Close()
+
+			ctx := context.Background()
+			if tt.multiAck {
+				ctx = multiAckContext(ctx)
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+tt.path, strings.NewReader(body))
+			assert.NoError(t, err)
+
+			c := &http.Client{Transport: &multiAckTransport{http.DefaultTransport}}
+			res, err := c.Do(req)
+			assert.NoError(t, err)
+			assert.NoError(t, res.Body.Close())
+		})
+	}
+}
+
+func Test_isAzureURL(t *testing.T) {
+	tests := map[string]struct {
+		url      string
+		expected bool
+	}{
+		"Azure devops": {
+			url:      "https://dev.azure.com/org/project/_git/repo",
+			expected: true,
+		},
+		"Visual studio": {
+			url:      "https://org.visualstudio.com/project/_git/repo",
+			expected: true,
+		},
+		"Azure devops ssh": {
+			url:      "git@ssh.dev.azure.com:v3/org/project/repo",
+			expected: true,
+		},
+		"Github": {
+			url: "https://github.com/owner/repo.git",
+		},
+	}
+
+	for tname, tt := range tests {
+		t.Run(tname, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isAzureURL(tt.url))
+		})
+	}
+}
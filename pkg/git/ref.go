@@ -0,0 +1,66 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RefType the kind of git reference in a repository url
+type RefType string
+
+const (
+	// RefTypeNone no reference, the default branch of the repository
+	RefTypeNone RefType = ""
+	// RefTypeBranch "<url>#<branch>"
+	RefTypeBranch RefType = "branch"
+	// RefTypeTag "<url>@<tag>"
+	RefTypeTag RefType = "tag"
+	// RefTypeSHA "<url>#<sha>", a full or abbreviated (7-40 hex characters)
+	// commit sha, Clone falls back to a branch with that name when there is no
+	// such commit
+	RefTypeSHA RefType = "sha"
+)
+
+var shaRe = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// Ref a repository url, split from the reference it points to
+type Ref struct {
+	// URL the repository url, without the reference
+	URL string
+	// Name the branch, tag or commit sha, empty when Type is RefTypeNone
+	Name string
+	Type RefType
+}
+
+// ParseRef parses a repository url in the form of "<url>", "<url>#<branch>",
+// "<url>#<sha>" or "<url>@<tag>". A fragment that looks like a commit sha is
+// treated as one, and not as a branch.
+func ParseRef(repoURL string) (*Ref, error) {
+	if i := strings.LastIndex(repoURL, "#"); i > -1 {
+		name := repoURL[i+1:]
+		if name == "" {
+			return nil, fmt.Errorf("missing branch or commit sha after \"#\" in url: %s", repoURL)
+		}
+
+		t := RefTypeBranch
+		if shaRe.MatchString(name) {
+			t = RefTypeSHA
+		}
+
+		return &Ref{URL: repoURL[:i], Name: name, Type: t}, nil
+	}
+
+	// only an "@" after the last path separator is a tag, so the user part of
+	// ssh urls (git@host:owner/repo) is ignored
+	if i := strings.LastIndex(repoURL, "@"); i > strings.LastIndex(repoURL, "/") {
+		name := repoURL[i+1:]
+		if name == "" {
+			return nil, fmt.Errorf("missing tag after \"@\" in url: %s", repoURL)
+		}
+
+		return &Ref{URL: repoURL[:i], Name: name, Type: RefTypeTag}, nil
+	}
+
+	return &Ref{URL: repoURL}, nil
+}
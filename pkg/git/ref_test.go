@@ -0,0 +1,72 @@
+package git
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseRef(t *testing.T) {
+	tests := map[string]struct {
+		url     string
+		want    *Ref
+		wantErr string
+	}{
+		"No ref": {
+			url:  "https://github.com/foo/bar",
+			want: &Ref{URL: "https://github.com/foo/bar"},
+		},
+		"Branch": {
+			url:  "https://github.com/foo/bar#staging",
+			want: &Ref{URL: "https://github.com/foo/bar", Name: "staging", Type: RefTypeBranch},
+		},
+		"Tag": {
+			url:  "https://github.com/foo/bar@v0.0.1",
+			want: &Ref{URL: "https://github.com/foo/bar", Name: "v0.0.1", Type: RefTypeTag},
+		},
+		"Short sha": {
+			url:  "https://github.com/foo/bar#f24fcad",
+			want: &Ref{URL: "https://github.com/foo/bar", Name: "f24fcad", Type: RefTypeSHA},
+		},
+		"Full sha": {
+			url:  "https://github.com/foo/bar#f24fcad0f1e3e3f5a1c6b2d7e8a9b0c1d2e3f4a5",
+			want: &Ref{URL: "https://github.com/foo/bar", Name: "f24fcad0f1e3e3f5a1c6b2d7e8a9b0c1d2e3f4a5", Type: RefTypeSHA},
+		},
+		"Hex branch too short for a sha": {
+			url:  "https://github.com/foo/bar#cafe",
+			want: &Ref{URL: "https://github.com/foo/bar", Name: "cafe", Type: RefTypeBranch},
+		},
+		"Ssh": {
+			url:  "git@github.com:foo/bar.git",
+			want: &Ref{URL: "git@github.com:foo/bar.git"},
+		},
+		"Ssh branch": {
+			url:  "git@github.com:foo/bar.git#envs/staging",
+			want: &Ref{URL: "git@github.com:foo/bar.git", Name: "envs/staging", Type: RefTypeBranch},
+		},
+		"Ssh tag": {
+			url:  "git@github.com:foo/bar.git@v0.0.1",
+			want: &Ref{URL: "git@github.com:foo/bar.git", Name: "v0.0.1", Type: RefTypeTag},
+		},
+		"Empty branch": {
+			url:     "https://github.com/foo/bar#",
+			wantErr: "missing branch or commit sha after \"#\" in url: https://github.com/foo/bar#",
+		},
+		"Empty tag": {
+			url:     "https://github.com/foo/bar@",
+			wantErr: "missing tag after \"@\" in url: https://github.com/foo/bar@",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseRef(test.url)
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Signing formats
+const (
+	SignFormatOpenPGP = "openpgp"
+	SignFormatSSH     = "ssh"
+)
+
+const (
+	sshSigNamespace     = "git"
+	sshSigHashAlgorithm = "sha512"
+	sshSigMagic         = "SSHSIG"
+	sshSigVersion       = 1
+)
+
+// loadOpenPGPKey reads the first entity of an armored private key ring, and
+// decrypts its private keys with the passphrase if needed
+func loadOpenPGPKey(path, passphrase string) (*openpgp.Entity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read openpgp key: %w", err)
+	}
+
+	e := entities[0]
+	if e.PrivateKey == nil {
+		return nil, fmt.Errorf("openpgp key has no private key: %s", path)
+	}
+
+	if e.PrivateKey.Encrypted {
+		if err = e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt openpgp key: %w", err)
+		}
+	}
+
+	for _, sk := range e.Subkeys {
+		if sk.PrivateKey != nil && sk.PrivateKey.Encrypted {
+			if err = sk.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to decrypt openpgp key: %w", err)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+func loadSSHSigner(path, passphrase string) (gossh.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase != "" {
+		return gossh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+
+	return gossh.ParsePrivateKey(data)
+}
+
+// sshSignCommit replaces the commit with a copy that is signed with the ssh
+// key, and moves HEAD to the new commit
+func (r *repo) sshSignCommit(h plumbing.Hash, signer gossh.Signer) (plumbing.Hash, error) {
+	c, err := r.r.CommitObject(h)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	unsigned := r.r.Storer.NewEncodedObject()
+	if err = c.EncodeWithoutSignature(unsigned); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	reader, err := unsigned.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	c.PGPSignature, err = sshSign(signer, data)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	signed := r.r.Storer.NewEncodedObject()
+	if err = c.Encode(signed); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	signedHash, err := r.r.Storer.SetEncodedObject(signed)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	head, err := r.r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	name := plumbing.HEAD
+	if head.Name().IsBranch() {
+		name = head.Name()
+	}
+
+	return signedHash, r.r.Storer.SetReference(plumbing.NewHashReference(name, signedHash))
+}
+
+// sshSign returns an armored ssh signature of the data, in the format used by
+// "ssh-keygen -Y sign" (PROTOCOL.sshsig)
+func sshSign(signer gossh.Signer, data []byte) (string, error) {
+	h := sha512.Sum512(data)
+	signedData := append([]byte(sshSigMagic), gossh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlgorithm,
+		Hash:          string(h[:]),
+	})...)
+
+	var sig *gossh.Signature
+	var err error
+	if as, ok := signer.(gossh.AlgorithmSigner); ok && signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+		// ssh-rsa (sha1) signatures are not accepted
+		sig, err = as.SignWithAlgorithm(nil, signedData, gossh.SigAlgoRSASHA2512)
+	} else {
+		sig, err = signer.Sign(nil, signedData)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	blob := append([]byte(sshSigMagic), gossh.Marshal(struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}{
+		Version:       sshSigVersion,
+		PublicKey:     string(signer.PublicKey().Marshal()),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlgorithm,
+		Signature:     string(gossh.Marshal(sig)),
+	})...)
+
+	return armorSSHSignature(blob), nil
+}
+
+func armorSSHSignature(blob []byte) string {
+	enc := base64.StdEncoding.EncodeToString(blob)
+
+	var b bytes.Buffer
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(enc) > 70 {
+		b.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	b.WriteString(enc + "\n")
+	b.WriteString("-----END SSH SIGNATURE-----")
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,193 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func Test_repo_Commit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pgpKeyPath, pgpPubKey := writeOpenPGPKey(t, dir)
+	sshKeyPath, sshPubKey := writeSSHKey(t, dir)
+
+	tests := map[string]struct {
+		opts     *CommitOptions
+		assertFn func(*testing.T, *object.Commit)
+	}{
+		"Author": {
+			opts: &CommitOptions{
+				Message: "msg",
+				Author:  &Signature{Name: "foo", Email: "foo@example.com"},
+			},
+			assertFn: func(t *testing.T, c *object.Commit) {
+				assert.Equal(t, "msg", c.Message)
+				assert.Equal(t, "foo", c.Author.Name)
+				assert.Equal(t, "foo@example.com", c.Author.Email)
+				assert.Equal(t, "foo", c.Committer.Name)
+				assert.Empty(t, c.PGPSignature)
+			},
+		},
+		"Committer": {
+			opts: &CommitOptions{
+				Message:   "msg",
+				Author:    &Signature{Name: "foo", Email: "foo@example.com"},
+				Committer: &Signature{Name: "bar", Email: "bar@example.com"},
+			},
+			assertFn: func(t *testing.T, c *object.Commit) {
+				assert.Equal(t, "foo", c.Author.Name)
+				assert.Equal(t, "bar", c.Committer.Name)
+			},
+		},
+		"OpenPGP": {
+			opts: &CommitOptions{
+				Message: "msg",
+				Author:  &Signature{Name: "foo", Email: "foo@example.com"},
+				Sign:    &SignOptions{KeyPath: pgpKeyPath},
+			},
+			assertFn: func(t *testing.T, c *object.Commit) {
+				_, err := c.Verify(pgpPubKey)
+				assert.NoError(t, err)
+			},
+		},
+		"SSH": {
+			opts: &CommitOptions{
+				Message: "msg",
+				Author:  &Signature{Name: "foo", Email: "foo@example.com"},
+				Sign:    &SignOptions{Format: SignFormatSSH, KeyPath: sshKeyPath},
+			},
+			assertFn: func(t *testing.T, c *object.Commit) {
+				verifySSHSignature(t, c, sshPubKey)
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := utils.MockLoggerContext()
+			repoDir, err := ioutil.TempDir("", "repo-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(repoDir)
+
+			r, err := Init(ctx, repoDir)
+			assert.NoError(t, err)
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(repoDir, "a"), []byte("a"), 0644))
+			assert.NoError(t, r.Add(ctx, "."))
+
+			h, err := r.Commit(ctx, test.opts)
+			assert.NoError(t, err)
+
+			gr := r.(*repo).r
+			head, err := gr.Head()
+			assert.NoError(t, err)
+			assert.Equal(t, h, head.Hash().String())
+
+			c, err := gr.CommitObject(plumbing.NewHash(h))
+			assert.NoError(t, err)
+			test.assertFn(t, c)
+		})
+	}
+}
+
+// writeOpenPGPKey writes an armored private key and returns its path and the
+// armored public key
+func writeOpenPGPKey(t *testing.T, dir string) (string, string) {
+	e, err := openpgp.NewEntity("foo", "", "foo@example.com", nil)
+	assert.NoError(t, err)
+
+	priv := &bytes.Buffer{}
+	w, err := armor.Encode(priv, openpgp.PrivateKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.SerializePrivate(w, nil))
+	assert.NoError(t, w.Close())
+
+	pub := &bytes.Buffer{}
+	w, err = armor.Encode(pub, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, e.Serialize(w))
+	assert.NoError(t, w.Close())
+
+	p := filepath.Join(dir, "gpg.asc")
+	assert.NoError(t, ioutil.WriteFile(p, priv.Bytes(), 0600))
+
+	return p, pub.String()
+}
+
+func writeSSHKey(t *testing.T, dir string) (string, gossh.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	assert.NoError(t, err)
+
+	p := filepath.Join(dir, "id_ed25519")
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	assert.NoError(t, ioutil.WriteFile(p, pem.EncodeToMemory(block), 0600))
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	assert.NoError(t, err)
+
+	return p, sshPub
+}
+
+// verifySSHSignature verifies the commit signature, as "ssh-keygen -Y verify" would
+func verifySSHSignature(t *testing.T, c *object.Commit, pub gossh.PublicKey) {
+	armored := strings.TrimSpace(c.PGPSignature)
+	assert.True(t, strings.HasPrefix(armored, "-----BEGIN SSH SIGNATURE-----"))
+	armored = strings.TrimPrefix(armored, "-----BEGIN SSH SIGNATURE-----")
+	armored = strings.TrimSuffix(armored, "-----END SSH SIGNATURE-----")
+	blob, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(armored, "\n", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, sshSigMagic, string(blob[:6]))
+
+	sig := struct {
+		Version       uint32
+		PublicKey     string
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     string
+	}{}
+	assert.NoError(t, gossh.Unmarshal(blob[6:], &sig))
+	assert.Equal(t, string(pub.Marshal()), sig.PublicKey)
+	assert.Equal(t, sshSigNamespace, sig.Namespace)
+
+	s := &gossh.Signature{}
+	assert.NoError(t, gossh.Unmarshal([]byte(sig.Signature), s))
+
+	unsigned := &plumbing.MemoryObject{}
+	assert.NoError(t, c.EncodeWithoutSignature(unsigned))
+	rd, err := unsigned.Reader()
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(rd)
+	assert.NoError(t, err)
+
+	h := sha512.Sum512(data)
+	signedData := append([]byte(sshSigMagic), gossh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          string
+	}{sshSigNamespace, "", sshSigHashAlgorithm, string(h[:])})...)
+	assert.NoError(t, pub.Verify(signedData, s))
+}
@@ -2,8 +2,6 @@ package helpers
 
 import (
 	"context"
-	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,7 +9,8 @@ import (
 	"text/template"
 
 	"github.com/codefresh-io/cf-argo/pkg/log"
-	"github.com/yargevad/filepathx"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 )
 
 const (
@@ -32,124 +31,122 @@ func ContextWithCancelOnSignals(ctx context.Context, sigs ...os.Signal) context.
 	return ctx
 }
 
-func CopyDir(source, destination string) error {
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+// CopyDir copies the source directory of srcFS into the destination directory
+// of dstFS, merging with existing directories and overwriting existing files
+func CopyDir(srcFS billy.Filesystem, source string, dstFS billy.Filesystem, destination string) error {
+	return Walk(srcFS, source, func(path string, info os.FileInfo) error {
+		relPath, err := filepath.Rel(source, path)
 		if err != nil {
 			return err
 		}
 
-		var relPath string = strings.Replace(path, source, "", 1)
-		if relPath == "" {
-			return nil
-		}
-
 		absDst := filepath.Join(destination, relPath)
-		if err = ensureDir(absDst); err != nil {
-			return err
-		}
-
 		if info.IsDir() {
-			err = os.Mkdir(absDst, info.Mode())
-			if err != nil {
-				if os.IsExist(err.(*os.PathError).Unwrap()) {
-					return nil
-				}
-			}
+			return dstFS.MkdirAll(absDst, info.Mode())
+		}
 
+		data, err := util.ReadFile(srcFS, path)
+		if err != nil {
 			return err
-		} else {
-			data, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
-			}
-
-			return ioutil.WriteFile(absDst, data, info.Mode())
 		}
+
+		return util.WriteFile(dstFS, absDst, data, info.Mode())
 	})
 }
 
-func ensureDir(path string) error {
-	dstDir := filepath.Dir(path)
-	if _, err := os.Stat(dstDir); err != nil {
-		if !os.IsNotExist(err) {
+// Walk calls fn for the root and every file and directory in it, parents
+// before their children
+func Walk(fs billy.Filesystem, root string, fn func(path string, info os.FileInfo) error) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return err
+	}
+
+	if err = fn(root, info); err != nil || !info.IsDir() {
+		return err
+	}
+
+	infos, err := fs.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range infos {
+		if err = Walk(fs, filepath.Join(root, i.Name()), fn); err != nil {
 			return err
 		}
-
-		return os.MkdirAll(dstDir, 0755)
 	}
 
 	return nil
 }
 
-func RenderDirRecurse(pattern string, values interface{}) error {
-	matches, err := filepathx.Glob(pattern)
-	if err != nil {
-		return err
-	}
+// RenderDirRecurse renders the files of fs whose name matches the pattern as
+// templates with the values
+func RenderDirRecurse(fs billy.Filesystem, pattern string, values interface{}) error {
+	return Walk(fs, "/", func(path string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
 
-	for _, match := range matches {
-		tpl, err := template.ParseFiles(match)
-		if err != nil {
+		match, err := filepath.Match(pattern, info.Name())
+		if err != nil || !match {
 			return err
 		}
 
-		fw, err := os.OpenFile(match, os.O_WRONLY|os.O_TRUNC, 0)
+		data, err := util.ReadFile(fs, path)
 		if err != nil {
 			return err
 		}
 
-		err = tpl.Execute(fw, values)
-		fw.Close()
+		tpl, err := template.New(info.Name()).Parse(string(data))
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
-}
+		fw, err := fs.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return err
+		}
 
-func RenameFilesWithEnvName(ctx context.Context, path, env string) error {
-	matches, err := filepathx.Glob(filepath.Join(path, fmt.Sprintf("**/%s", envNamePlaceholder)))
-	if err != nil {
+		err = tpl.Execute(fw, values)
+		fw.Close()
 		return err
-	}
+	})
+}
 
-	// rename just directories
-	for _, m := range matches {
-		if strings.HasSuffix(m, envNamePlaceholder) {
-			if err := renameEnvName(ctx, m, env); err != nil {
-				return err
-			}
+// RenameFilesWithEnvName replaces the "envName" prefix of the files and
+// directories of fs with the environment name
+func RenameFilesWithEnvName(ctx context.Context, fs billy.Filesystem, env string) error {
+	var paths []string
+	err := Walk(fs, "/", func(path string, info os.FileInfo) error {
+		if strings.HasPrefix(info.Name(), envNamePlaceholder) {
+			paths = append(paths, path)
 		}
-	}
 
-	// run again to rename nested files with envName
-	matches, err = filepathx.Glob(filepath.Join(path, fmt.Sprintf("**/%s*.*", envNamePlaceholder)))
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for _, m := range matches {
-		if strings.Contains(m, envNamePlaceholder) {
-			if err := renameEnvName(ctx, m, env); err != nil {
-				return err
-			}
+
+	// rename children before their parents, so the paths are still valid
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err = renameEnvName(ctx, fs, paths[i], env); err != nil {
+			return err
 		}
 	}
+
 	return nil
 }
 
-func renameEnvName(ctx context.Context, old, env string) error {
-	ap, err := filepath.Abs(old)
-	if err != nil {
-		return err
-	}
-	newName := strings.Replace(ap, "envName", env, 1)
+func renameEnvName(ctx context.Context, fs billy.Filesystem, old, env string) error {
+	newName := filepath.Join(filepath.Dir(old), strings.Replace(filepath.Base(old), envNamePlaceholder, env, 1))
 	log.G(ctx).WithFields(log.Fields{
-		"old-path": ap,
+		"old-path": old,
 		"new-path": newName,
 	}).Debug("renaming with environment name")
 
-	return os.Rename(ap, newName)
+	return fs.Rename(old, newName)
 }
 
 func ClearFolder(ctx context.Context, path string) error {
@@ -10,6 +10,8 @@ import (
 
 	"github.com/bitnami-labs/sealed-secrets/pkg/apis/sealed-secrets/v1alpha1"
 	"github.com/codefresh-io/cf-argo/pkg/store"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -17,12 +19,19 @@ import (
 	"k8s.io/client-go/util/cert"
 )
 
-func CreateSealedSecretFromSecretFile(ctx context.Context, namespace, secretPath string, dryRun bool) (*v1alpha1.SealedSecret, error) {
-	s, err := getSecretFromFile(ctx, secretPath)
+// CreateSealedSecretFromSecretFile seals the secret manifest at secretPath of fs
+func CreateSealedSecretFromSecretFile(ctx context.Context, fs billy.Filesystem, namespace, secretPath string, dryRun bool) (*v1alpha1.SealedSecret, error) {
+	s, err := getSecretFromFile(ctx, fs, secretPath)
 	if err != nil {
 		return nil, err
 	}
 
+	return CreateSealedSecret(ctx, namespace, s, dryRun)
+}
+
+// CreateSealedSecret seals the secret with the public key of the
+// sealed-secrets controller in namespace
+func CreateSealedSecret(ctx context.Context, namespace string, s *v1.Secret, dryRun bool) (*v1alpha1.SealedSecret, error) {
 	if dryRun {
 		s.Data = nil
 		s.StringData = nil
@@ -55,9 +64,9 @@ func addTypeMeta(ss *v1alpha1.SealedSecret) *v1alpha1.SealedSecret {
 	return ss
 }
 
-func getSecretFromFile(ctx context.Context, secretPath string) (*v1.Secret, error) {
+func getSecretFromFile(ctx context.Context, fs billy.Filesystem, secretPath string) (*v1.Secret, error) {
 	d := scheme.Codecs.UniversalDeserializer()
-	bytes, err := ioutil.ReadFile(secretPath)
+	bytes, err := util.ReadFile(fs, secretPath)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,45 @@
+// Package testrepo creates gitops repositories for tests. It is separate from
+// test/utils, which the tests of pkg/git import.
+package testrepo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/codefresh-io/cf-argo/pkg/git"
+	"github.com/codefresh-io/cf-argo/pkg/helpers"
+	"github.com/codefresh-io/cf-argo/test/utils"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/stretchr/testify/assert"
+)
+
+// New creates a repository with the file provider, and pushes the fixture
+// directory to it. It returns the repository url and the provider host, which
+// is removed when the test finishes.
+func New(ctx context.Context, t *testing.T, fixture string) (string, string) {
+	host, err := ioutil.TempDir("", "host-")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(host) })
+
+	p, err := git.NewProvider(&git.Options{Type: "file", Host: host})
+	assert.NoError(t, err)
+	repoURL, err := p.CreateRepository(ctx, &git.CreateRepoOptions{Owner: "foo", Name: "gitops"})
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "repo-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	r, err := git.Init(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, utils.SetGitAuthor(dir))
+	assert.NoError(t, helpers.CopyDir(osfs.New(fixture), "/", osfs.New(dir), "/"))
+	assert.NoError(t, r.Add(ctx, "."))
+	_, err = r.Commit(ctx, &git.CommitOptions{Message: "init"})
+	assert.NoError(t, err)
+	assert.NoError(t, r.AddRemote(ctx, "origin", repoURL))
+	assert.NoError(t, r.Push(ctx, &git.PushOptions{}))
+
+	return repoURL, host
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 
 	"github.com/codefresh-io/cf-argo/pkg/log"
 )
@@ -9,3 +11,16 @@ import (
 func MockLoggerContext() context.Context {
 	return log.WithLogger(context.Background(), log.NopLogger{})
 }
+
+// SetGitAuthor sets the commit author in the config of the local repository
+// at path, so tests do not depend on the global git config
+func SetGitAuthor(path string) error {
+	f, err := os.OpenFile(filepath.Join(path, ".git", "config"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString("[user]\n\tname = test\n\temail = test@example.com\n")
+	return err
+}